@@ -0,0 +1,124 @@
+package kv
+
+import "context"
+
+// defaultMaxAliasHops is how many aliases Get follows unless
+// WithMaxAliasHops says otherwise.
+const defaultMaxAliasHops = 8
+
+// buildAliasPayload encodes [type][alias len][alias][target len][target],
+// the layout of a set entry.
+func buildAliasPayload(alias, target string) []byte {
+	payload := buildSetPayload([]byte(alias), []byte(target))
+	payload[0] = byte(OpAlias)
+	return payload
+}
+
+func decodeAlias(payload []byte) (alias, target string, err error) {
+	alias, t, err := decodeSet(payload)
+	if err != nil {
+		return "", "", err
+	}
+	return alias, string(t), nil
+}
+
+// SetAlias makes alias resolve to target: Get(alias) returns target's
+// current value, following further aliases up to WithMaxAliasHops. The
+// alias replaces any value stored under alias, and a later Set or Del of
+// alias replaces or removes the alias. target need not exist yet. The other
+// reads of a single key (GetUnsafe, GetReader, GetWithChecksum, GetWithLSN,
+// TTL, Tx.Get and ReadTx.Get) follow aliases too; GetWithToken does not.
+// Aliases are written to the log but are not keys: Keys, Scan and Stats do
+// not list them. An alias that would close a loop, or lengthen a chain past the
+// hop limit, is refused with ErrAliasLoop. Like a set, the alias goes
+// through WithWriteInterceptor as OpAlias and is delivered to change
+// streams, and a value it replaces is kept for Undelete as if deleted.
+func (k *KV) SetAlias(alias, target string) error {
+	if alias == target {
+		return ErrAliasLoop
+	}
+	release, err := k.admit()
+	if err != nil {
+		return err
+	}
+	defer release()
+	if err := k.throttle(context.Background(), SetEntrySize(alias, []byte(target))); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.checkWritable(); err != nil {
+		return err
+	}
+	// walk the chain the new alias would start
+	next := target
+	for hops := 1; ; hops++ {
+		if next == alias || hops > k.opts.maxAliasHops {
+			return ErrAliasLoop
+		}
+		t, ok := k.aliases[next]
+		if !ok {
+			break
+		}
+		next = t
+	}
+	if _, err := k.intercept(OpAlias, alias, []byte(target)); err != nil {
+		return err
+	}
+	payload := buildAliasPayload(alias, target)
+	if err := k.writeEntries(payload); err != nil {
+		return err
+	}
+	if k.live(alias) {
+		k.keepDeleted(alias, k.data[alias])
+	}
+	if err := k.apply(payload); err != nil {
+		return err
+	}
+	k.publish(OpAlias, alias, []byte(target))
+	return nil
+}
+
+// resolve follows aliases from key to the key they end at. ok is false if
+// the chain is longer than WithMaxAliasHops. Callers must hold k.mu.
+func (k *KV) resolve(key string) (string, bool) {
+	for hops := 0; ; hops++ {
+		target, ok := k.aliases[key]
+		if !ok {
+			return key, true
+		}
+		if hops >= k.opts.maxAliasHops {
+			return "", false
+		}
+		key = target
+	}
+}
+
+// resolveLive follows aliases from key and reports the key they end at and
+// whether it is live. Callers must hold k.mu.
+func (k *KV) resolveLive(key string) (string, bool) {
+	key, ok := k.resolve(key)
+	return key, ok && k.live(key)
+}
+
+// setAlias records alias in memory. Callers must hold k.mu for writing.
+func (k *KV) setAlias(alias, target string) {
+	k.supersede(alias)
+	k.remove(alias)
+	if k.aliases == nil {
+		k.aliases = make(map[string]string)
+	}
+	k.aliases[alias] = target
+}
+
+// aliasPayloads returns the entries that recreate every alias accepted by
+// keep (all with a nil keep) in a fresh log. Callers must hold k.mu.
+func (k *KV) aliasPayloads(keep func(key string) bool) [][]byte {
+	var payloads [][]byte
+	for alias, target := range k.aliases {
+		if keep == nil || keep(alias) {
+			payloads = append(payloads, buildAliasPayload(alias, target))
+		}
+	}
+	return payloads
+}
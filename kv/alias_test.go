@@ -0,0 +1,189 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAliasResolution(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	k, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.Set("v41", []byte("41"))
+	k.Set("v42", []byte("42"))
+	if err := k.SetAlias("latest", "v41"); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.SetAlias("current", "latest"); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := k.Get("current"); !ok || string(v) != "41" {
+		t.Errorf("Get(current) = %q, %v", v, ok)
+	}
+	if err := k.SetAlias("latest", "v42"); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := k.Get("current"); !ok || string(v) != "42" {
+		t.Errorf("Get(current) after retargeting = %q, %v", v, ok)
+	}
+	k.Close()
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if v, ok := reopened.Get("current"); !ok || string(v) != "42" {
+		t.Errorf("Get(current) after reopen = %q, %v", v, ok)
+	}
+	if err := reopened.Set("latest", []byte("plain")); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := reopened.Get("current"); string(v) != "plain" {
+		t.Errorf("Get(current) after Set(latest) = %q", v)
+	}
+}
+
+func TestAliasLoops(t *testing.T) {
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"), WithMaxAliasHops(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	if err := k.SetAlias("a", "a"); !errors.Is(err, ErrAliasLoop) {
+		t.Errorf("self alias: err = %v", err)
+	}
+	k.SetAlias("a", "b")
+	k.SetAlias("b", "c")
+	if err := k.SetAlias("c", "a"); !errors.Is(err, ErrAliasLoop) {
+		t.Errorf("cycle: err = %v", err)
+	}
+	if err := k.SetAlias("d", "a"); !errors.Is(err, ErrAliasLoop) {
+		t.Errorf("chain past the hop limit: err = %v", err)
+	}
+}
+
+func TestAliasFollowedByReads(t *testing.T) {
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	k.SetWithTTL("target", []byte("v"), time.Hour)
+	k.SetAlias("alias", "target")
+
+	if v, ok := k.GetUnsafe("alias"); !ok || string(v) != "v" {
+		t.Errorf("GetUnsafe = %q, %v", v, ok)
+	}
+	if r, ok := k.GetReader("alias"); !ok {
+		t.Error("GetReader missed the alias")
+	} else if v, _ := io.ReadAll(r); string(v) != "v" {
+		t.Errorf("GetReader read %q", v)
+	}
+	if v, crc, ok := k.GetWithChecksum("alias"); !ok || crc != k.Checksum().Sum(v) {
+		t.Errorf("GetWithChecksum = %q, %d, %v", v, crc, ok)
+	}
+	_, want, _ := k.GetWithLSN("target")
+	if _, lsn, ok := k.GetWithLSN("alias"); !ok || lsn != want {
+		t.Errorf("GetWithLSN = %d, %v, want %d", lsn, ok, want)
+	}
+	if ttl, ok := k.TTL("alias"); !ok || ttl <= 0 {
+		t.Errorf("TTL = %v, %v", ttl, ok)
+	}
+	k.Update(func(tx *Tx) error {
+		if v, ok := tx.Get("alias"); !ok || string(v) != "v" {
+			t.Errorf("Tx.Get = %q, %v", v, ok)
+		}
+		tx.Set("target", []byte("w"))
+		if v, _ := tx.Get("alias"); string(v) != "w" {
+			t.Errorf("Tx.Get after the tx's own write = %q", v)
+		}
+		tx.Set("alias", []byte("own"))
+		if v, _ := tx.Get("alias"); string(v) != "own" {
+			t.Errorf("Tx.Get after replacing the alias = %q", v)
+		}
+		return nil
+	})
+	k.View(func(tx *ReadTx) error {
+		if v, ok := tx.Get("alias"); !ok || string(v) != "own" {
+			t.Errorf("ReadTx.Get = %q, %v", v, ok)
+		}
+		return nil
+	})
+
+	k.SetAlias("alias", "target")
+	k.View(func(tx *ReadTx) error {
+		if v, ok := tx.Get("alias"); !ok || string(v) != "w" {
+			t.Errorf("ReadTx.Get through the alias = %q, %v", v, ok)
+		}
+		return nil
+	})
+	// SetWithToken writes the alias itself, so the token is the alias's own
+	if _, token, ok := k.GetWithToken("alias"); ok || token != 0 {
+		t.Errorf("GetWithToken(alias) = %d, %v; want missing", token, ok)
+	}
+	if ok, err := k.SetWithToken("alias", []byte("x"), 0); err != nil || !ok {
+		t.Errorf("SetWithToken(alias, 0) = %v, %v", ok, err)
+	}
+	if v, _ := k.Get("target"); string(v) != "w" {
+		t.Errorf("SetWithToken(alias) changed the target to %q", v)
+	}
+}
+
+func TestAliasWritePath(t *testing.T) {
+	var seen []string
+	veto := errors.New("vetoed")
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"),
+		WithDeleteUndo(4),
+		WithWriteInterceptor(func(op EntryType, key string, value []byte) ([]byte, error) {
+			seen = append(seen, fmt.Sprintf("%d %s %s", op, key, value))
+			if key == "refused" {
+				return nil, veto
+			}
+			return value, nil
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	k.Set("target", []byte("t"))
+	k.Set("alias", []byte("old"))
+
+	var out syncBuffer
+	stop := k.StreamChanges(&out)
+	if err := k.SetAlias("alias", "target"); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.SetAlias("refused", "target"); !errors.Is(err, veto) {
+		t.Errorf("vetoed SetAlias: err = %v", err)
+	}
+	stop()
+
+	want := fmt.Sprintf("%d alias target", OpAlias)
+	if len(seen) != 4 || seen[2] != want {
+		t.Errorf("interceptor saw %q, want %q third", seen, want)
+	}
+	if _, ok := k.Get("refused"); ok {
+		t.Error("vetoed alias was applied")
+	}
+	lines := parseChanges(t, &out.buf)
+	if len(lines) != 1 || lines[0].Op != "alias" || lines[0].Key != "alias" ||
+		string(lines[0].Value) != "target" || lines[0].LSN == 0 {
+		t.Errorf("changes = %+v, want one alias line", lines)
+	}
+
+	// the value the alias replaced can be restored
+	k.Del("alias")
+	if ok, err := k.Undelete("alias"); err != nil || !ok {
+		t.Fatalf("Undelete = %v, %v", ok, err)
+	}
+	if v, _ := k.Get("alias"); string(v) != "old" {
+		t.Errorf("Get(alias) after Undelete = %q, want old", v)
+	}
+}
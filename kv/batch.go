@@ -0,0 +1,137 @@
+package kv
+
+import "context"
+
+// Batch collects set and delete operations that WriteBatch applies as one
+// atomic unit.
+type Batch struct {
+	ops []batchOp
+}
+
+type batchOp struct {
+	typ   EntryType
+	key   string
+	value []byte
+}
+
+// Set queues a set of key to value. The value is copied.
+func (b *Batch) Set(key string, value []byte) {
+	b.ops = append(b.ops, batchOp{typ: OpSet, key: key, value: append([]byte(nil), value...)})
+}
+
+// Del queues a delete of key.
+func (b *Batch) Del(key string) {
+	b.ops = append(b.ops, batchOp{typ: OpDel, key: key})
+}
+
+// size is roughly how many log bytes writing the batch appends.
+func (b *Batch) size() int {
+	n := 0
+	for _, op := range b.ops {
+		if op.typ == OpSet {
+			n += SetEntrySize(op.key, op.value)
+		} else {
+			n += DelEntrySize(op.key)
+		}
+	}
+	return n
+}
+
+// Len returns the number of queued operations.
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// WriteBatch appends the batch to the log between a begin and a commit
+// marker, fsyncs once, then applies it to the in-memory map. With
+// WithSyncEvery or WithNoSync the batch counts as a single write, so it is
+// applied after the append and may not be durable yet. If the process dies
+// before the commit marker is durable, replay discards the whole batch.
+// The write lock is held from the append until the last operation is
+// applied, so readers see either none of the batch or all of it, and a
+// batch whose append or fsync fails leaves memory untouched.
+func (k *KV) WriteBatch(b *Batch) error {
+	return k.WriteBatchContext(context.Background(), b)
+}
+
+// WriteBatchContext is WriteBatch, except that a wait imposed by
+// WithWriteRateLimit is abandoned with ctx's error when ctx is done.
+func (k *KV) WriteBatchContext(ctx context.Context, b *Batch) error {
+	release, err := k.admit()
+	if err != nil {
+		return err
+	}
+	defer release()
+	if err := k.throttle(ctx, b.size()); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.checkWritable(); err != nil {
+		return err
+	}
+	return k.writeBatch(b)
+}
+
+// writeBatch logs and applies b; the caller holds the write lock.
+func (k *KV) writeBatch(b *Batch) error {
+	if len(b.ops) == 0 {
+		return nil
+	}
+	if k.opts.interceptor != nil {
+		// intercept into a copy so a vetoed batch can be retried as queued
+		ops := make([]batchOp, len(b.ops))
+		for i, op := range b.ops {
+			val, err := k.intercept(op.typ, op.key, op.value)
+			if err != nil {
+				return err
+			}
+			if op.typ == OpSet {
+				op.value = append([]byte(nil), val...)
+			}
+			ops[i] = op
+		}
+		b = &Batch{ops: ops}
+	}
+	payloads := make([][]byte, 0, len(b.ops))
+	for _, op := range b.ops {
+		if op.typ == OpSet {
+			payloads = append(payloads, buildSetPayloads(op.key, op.value, k.opts.chunkSize)...)
+		} else {
+			payloads = append(payloads, buildDelPayload([]byte(op.key)))
+		}
+	}
+	if err := k.writeEntries(payloads...); err != nil {
+		return err
+	}
+
+	for _, op := range b.ops {
+		if op.typ == OpSet {
+			k.put(op.key, op.value)
+		} else {
+			if k.live(op.key) {
+				k.keepDeleted(op.key, k.data[op.key])
+			}
+			k.remove(op.key)
+		}
+		k.publish(op.typ, op.key, op.value)
+	}
+	return nil
+}
+
+// KeyValue is a single key and its value.
+type KeyValue struct {
+	Key   string
+	Value []byte
+}
+
+// SetOrdered writes pairs as one batch, appending entries in exactly the
+// given order so the physical log layout matches it. Compact rewrites keys in
+// map iteration order, so the layout only holds until the next compaction.
+func (k *KV) SetOrdered(pairs []KeyValue) error {
+	var b Batch
+	for _, p := range pairs {
+		b.Set(p.Key, p.Value)
+	}
+	return k.WriteBatch(&b)
+}
@@ -0,0 +1,206 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestWriteBatchAtomicForReaders(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []Option
+	}{
+		{"sync", nil},
+		{"syncEvery", []Option{WithSyncEvery(10)}},
+		{"noSync", []Option{WithNoSync()}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			k, err := Create(filepath.Join(t.TempDir(), "a.log"), tc.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer k.Close()
+			const keys = 200
+
+			stop := make(chan struct{})
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			var torn []string
+			for r := 0; r < 4; r++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for {
+						select {
+						case <-stop:
+							return
+						default:
+						}
+						// every batch writes the same generation to all keys
+						vals := k.GetAll()
+						if len(vals) != 0 && len(vals) != keys {
+							mu.Lock()
+							torn = append(torn, fmt.Sprintf("%d of %d keys", len(vals), keys))
+							mu.Unlock()
+							return
+						}
+						first := vals["k000"]
+						for key, v := range vals {
+							if string(v) != string(first) {
+								mu.Lock()
+								torn = append(torn, fmt.Sprintf("%s=%q, k0=%q", key, v, first))
+								mu.Unlock()
+								return
+							}
+						}
+					}
+				}()
+			}
+			for gen := 0; gen < 50; gen++ {
+				var b Batch
+				for i := 0; i < keys; i++ {
+					b.Set(fmt.Sprintf("k%03d", i), []byte(fmt.Sprint(gen)))
+				}
+				if err := k.WriteBatch(&b); err != nil {
+					t.Fatal(err)
+				}
+			}
+			close(stop)
+			wg.Wait()
+			if len(torn) > 0 {
+				t.Errorf("a reader saw part of a batch: %s", torn[0])
+			}
+		})
+	}
+}
+
+func TestWriteBatchFailedSyncLeavesMemory(t *testing.T) {
+	s := newFaultyStorage()
+	k, err := Create("a.log", WithStorage(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	k.Set("a", []byte("1"))
+
+	s.failSync.Store(true)
+	var b Batch
+	b.Set("a", []byte("2"))
+	b.Set("b", []byte("3"))
+	b.Del("a")
+	if err := k.WriteBatch(&b); !errors.Is(err, errInjected) {
+		t.Fatalf("WriteBatch err = %v, want the sync failure", err)
+	}
+	if v, _ := k.Get("a"); string(v) != "1" {
+		t.Errorf("Get(a) = %q after a failed batch", v)
+	}
+	if _, ok := k.Get("b"); ok {
+		t.Error("b was applied by a failed batch")
+	}
+
+	s.failSync.Store(false)
+	if err := k.WriteBatch(&b); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := k.Get("a"); ok {
+		t.Error("a survived the batch's delete")
+	}
+}
+
+func TestWriteBatchTornBeforeCommit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	k, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.Set("a", []byte("1"))
+	var b Batch
+	b.Set("a", []byte("2"))
+	b.Set("b", []byte("2"))
+	b.Del("c")
+	if err := k.WriteBatch(&b); err != nil {
+		t.Fatal(err)
+	}
+	k.Close()
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit := int64(8 + len(buildBatchCommitPayload(3)))
+	if err := os.Truncate(path, fi.Size()-commit); err != nil {
+		t.Fatal(err)
+	}
+
+	k, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	if v, _ := k.Get("a"); string(v) != "1" {
+		t.Errorf("Get(a) = %q, want the value before the batch", v)
+	}
+	if _, ok := k.Get("b"); ok {
+		t.Error("b from the unterminated batch was applied")
+	}
+	var ce *CorruptionError
+	if info := k.OpenInfo(); !errors.As(info.TailError, &ce) || !errors.Is(ce, ErrUnterminatedBatch) {
+		t.Errorf("OpenInfo tail = %v, want ErrUnterminatedBatch", info.TailError)
+	}
+	// the torn batch is cut off, so new writes replay after reopen
+	k.Set("d", []byte("4"))
+	k.Close()
+	k, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := k.Get("d"); string(v) != "4" {
+		t.Errorf("Get(d) = %q after reopen", v)
+	}
+}
+
+func TestSetOrderedLogOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	k, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	pairs := []KeyValue{{"zeta", []byte("1")}, {"alpha", []byte("2")}, {"mid", []byte("3")}, {"beta", []byte("4")}}
+	if err := k.SetOrdered(pairs); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	lf, _, err := readHeader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(lf.headerLen(), io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	entries, _, tail, err := readLog(f, lf.headerLen(), lf, true)
+	if err != nil || tail != nil {
+		t.Fatalf("readLog: %v, %v", err, tail)
+	}
+	if len(entries) != len(pairs) {
+		t.Fatalf("%d entries in the log, want %d", len(entries), len(pairs))
+	}
+	for i, e := range entries {
+		key, val, err := decodeSet(e.payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != pairs[i].Key || string(val) != string(pairs[i].Value) {
+			t.Errorf("entry %d is %s=%q, want %s=%q", i, key, val, pairs[i].Key, pairs[i].Value)
+		}
+	}
+}
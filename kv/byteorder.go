@@ -0,0 +1,90 @@
+package kv
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// WithByteOrder selects the byte order of the integers in a new log file's
+// entries: frame lengths and CRCs, and the lengths, counts and times inside
+// payloads. The choice is recorded in the header, so existing files are
+// always read in their own order. Big-endian is the default and what files
+// from before this option use. The header itself stays big-endian. Only
+// binary.BigEndian and binary.LittleEndian are accepted.
+func WithByteOrder(order binary.ByteOrder) Option {
+	return func(o *options) {
+		o.byteOrder = order
+	}
+}
+
+func validByteOrder(order binary.ByteOrder) bool {
+	return order == binary.BigEndian || order == binary.LittleEndian
+}
+
+// order returns the byte order of lf's entries.
+func (lf logFormat) order() binary.ByteOrder {
+	if lf.littleEndian {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
+// payloadLayouts describes the integer fields of each entry type, after the
+// type byte, so payloads can be converted between byte orders: 'L' is a
+// uint32 length followed by that many bytes, '4' and '8' are plain uint32
+// and uint64 values, and '1' is a single byte. Bytes past the layout are
+// copied as is.
+var payloadLayouts = map[EntryType]string{
+	OpSet:         "LL",
+	OpDel:         "L",
+	OpBatchCommit: "4",
+	OpChunk:       "1LL",
+	OpExpire:      "L8",
+	OpAlias:       "LL",
+	OpRef:         "L",
+}
+
+// transcodePayload returns payload with its integer fields converted from
+// byte order from to byte order to. Entries are built and decoded
+// big-endian in memory, so this runs as they are written to or read from a
+// little-endian file. A payload that does not fit its layout is returned
+// unchanged and left for decoding to reject.
+func transcodePayload(payload []byte, from, to binary.ByteOrder) []byte {
+	if from == to || len(payload) == 0 {
+		return payload
+	}
+	layout, ok := payloadLayouts[EntryType(payload[0])]
+	if !ok {
+		return payload
+	}
+	out := append([]byte(nil), payload...)
+	off := 1
+	for _, field := range layout {
+		switch field {
+		case '1':
+			off++
+		case '4', 'L':
+			if off+4 > len(out) {
+				return payload
+			}
+			n := from.Uint32(payload[off:])
+			to.PutUint32(out[off:], n)
+			off += 4
+			if field == 'L' {
+				off += int(n)
+			}
+		case '8':
+			if off+8 > len(out) {
+				return payload
+			}
+			to.PutUint64(out[off:], from.Uint64(payload[off:]))
+			off += 8
+		default:
+			panic(fmt.Sprintf("kv: bad payload layout %q", layout))
+		}
+		if off > len(out) {
+			return payload
+		}
+	}
+	return out
+}
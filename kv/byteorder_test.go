@@ -0,0 +1,86 @@
+package kv
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestLittleEndianLog(t *testing.T) {
+	s := NewMemoryStorage()
+	k, err := Create("a.log", WithStorage(s), WithByteOrder(binary.LittleEndian), WithChunkSize(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.Set("a", []byte("1"))
+	k.Set("long", []byte("a value in several chunks"))
+	k.SetWithTTL("ttl", []byte("2"), time.Hour)
+	var b Batch
+	b.Set("b", []byte("3"))
+	b.Del("a")
+	k.WriteBatch(&b)
+	k.SetAlias("alias", "b")
+	k.Close()
+
+	// the first frame's length is little-endian on disk
+	hdr := int64(newLogFormat(defaultOptions()).headerLen())
+	frame := make([]byte, 4)
+	if _, err := s.ReadAt("a.log", frame, hdr); err != nil {
+		t.Fatal(err)
+	}
+	if n := binary.LittleEndian.Uint32(frame); n != uint32(len(buildSetPayload([]byte("a"), []byte("1")))) {
+		t.Errorf("first frame length read little-endian = %d", n)
+	}
+
+	check := func(k *KV) {
+		t.Helper()
+		for key, want := range map[string]string{
+			"long":  "a value in several chunks",
+			"ttl":   "2",
+			"b":     "3",
+			"alias": "3",
+		} {
+			if v, _ := k.Get(key); string(v) != want {
+				t.Errorf("Get(%s) = %q, want %q", key, v, want)
+			}
+		}
+		if _, ok := k.Get("a"); ok {
+			t.Error("a survived its delete")
+		}
+		if ttl, _ := k.TTL("ttl"); ttl <= 0 || ttl > time.Hour {
+			t.Errorf("TTL(ttl) = %v, want up to an hour", ttl)
+		}
+	}
+
+	// the header's order wins over the option on open
+	k, err = Open("a.log", WithStorage(s), WithByteOrder(binary.BigEndian))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(k)
+	if info := k.OpenInfo(); info.TailError != nil {
+		t.Errorf("TailError = %v", info.TailError)
+	}
+	if err := k.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	k.Set("c", []byte("4"))
+	k.Close()
+
+	k, err = Open("a.log", WithStorage(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	if !k.format.littleEndian {
+		t.Error("Compact rewrote the log big-endian")
+	}
+	check(k)
+	if v, _ := k.Get("c"); string(v) != "4" {
+		t.Errorf("Get(c) = %q, want 4", v)
+	}
+
+	if _, err := Create("b.log", WithStorage(s), WithByteOrder(binary.NativeEndian)); err == nil {
+		t.Error("Create accepted a byte order other than big- or little-endian")
+	}
+}
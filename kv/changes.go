@@ -0,0 +1,146 @@
+package kv
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// changeBufferSize is how many changes a stream buffers before dropping.
+const changeBufferSize = 1024
+
+// change is a single applied mutation delivered to change streams, or a
+// marker counting changes the stream had to drop.
+type change struct {
+	ts      time.Time
+	lsn     uint64
+	op      EntryType
+	key     string
+	value   []byte
+	dropped int
+}
+
+type changeStream struct {
+	ch      chan change
+	dropped int // guarded by KV.mu
+	done    chan struct{}
+}
+
+// changeLine is the JSON form of a change written by StreamChanges.
+type changeLine struct {
+	TS      time.Time `json:"ts"`
+	LSN     uint64    `json:"lsn,omitempty"`
+	Op      string    `json:"op"`
+	Key     string    `json:"key,omitempty"`
+	Value   []byte    `json:"value,omitempty"`
+	Dropped int       `json:"dropped,omitempty"`
+}
+
+// StreamChanges writes one JSON object per line to w for every mutation
+// applied after the call, e.g.
+//
+//	{"ts":"...","lsn":42,"op":"set","key":"k","value":"<base64>"}
+//
+// until stop is called or k is closed. SetAlias is reported with
+// "op":"alias" and the target as value. Writing happens on its own goroutine
+// behind a bounded buffer so a slow w never blocks writers: when the buffer
+// is full new changes are dropped and, once there is room again, a line with
+// "op":"dropped" and the number lost is emitted. The stream also ends if w
+// returns an error. stop flushes what is buffered and waits for the writer.
+func (k *KV) StreamChanges(w io.Writer) (stop func()) {
+	s := &changeStream{
+		ch:   make(chan change, changeBufferSize),
+		done: make(chan struct{}),
+	}
+	k.mu.Lock()
+	if k.closed {
+		k.mu.Unlock()
+		return func() {}
+	}
+	if k.streams == nil {
+		k.streams = make(map[*changeStream]struct{})
+	}
+	k.streams[s] = struct{}{}
+	k.mu.Unlock()
+
+	go func() {
+		defer close(s.done)
+		enc := json.NewEncoder(w)
+		failed := false
+		for c := range s.ch {
+			if failed {
+				continue
+			}
+			line := changeLine{TS: c.ts, LSN: c.lsn, Key: c.key, Value: c.value}
+			switch c.op {
+			case OpSet:
+				line.Op = "set"
+			case OpDel:
+				line.Op = "del"
+			case OpAlias:
+				line.Op = "alias"
+			default:
+				line.Op = "dropped"
+				line.Dropped = c.dropped
+			}
+			if err := enc.Encode(line); err != nil {
+				// stop delivering but keep draining until unsubscribed
+				failed = true
+				go k.unsubscribe(s)
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			k.unsubscribe(s)
+			<-s.done
+		})
+	}
+}
+
+func (k *KV) unsubscribe(s *changeStream) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.streams[s]; ok {
+		delete(k.streams, s)
+		close(s.ch)
+	}
+}
+
+// publish hands a mutation to every change stream without blocking.
+// Callers must hold k.mu for writing.
+func (k *KV) publish(op EntryType, key string, value []byte) {
+	if len(k.streams) == 0 {
+		return
+	}
+	c := change{ts: k.opts.clock.Now(), lsn: k.lsn, op: op, key: key, value: value}
+	for s := range k.streams {
+		if s.dropped > 0 {
+			// report the gap before resuming delivery
+			marker := change{ts: c.ts, dropped: s.dropped}
+			select {
+			case s.ch <- marker:
+				s.dropped = 0
+			default:
+				s.dropped++
+				continue
+			}
+		}
+		select {
+		case s.ch <- c:
+		default:
+			s.dropped++
+		}
+	}
+}
+
+// closeStreams ends every change stream. Callers must hold k.mu for writing.
+func (k *KV) closeStreams() {
+	for s := range k.streams {
+		delete(k.streams, s)
+		close(s.ch)
+	}
+}
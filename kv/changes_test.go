@@ -0,0 +1,165 @@
+package kv
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer safe for the stream goroutine to write
+// while the test reads.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func parseChanges(t *testing.T, r io.Reader) []changeLine {
+	t.Helper()
+	var lines []changeLine
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		var l changeLine
+		if err := json.Unmarshal(sc.Bytes(), &l); err != nil {
+			t.Fatalf("bad line %q: %v", sc.Text(), err)
+		}
+		lines = append(lines, l)
+	}
+	return lines
+}
+
+func TestStreamChanges(t *testing.T) {
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	k.Set("before", []byte("x"))
+
+	var out syncBuffer
+	stop := k.StreamChanges(&out)
+	k.Set("a", []byte("1"))
+	k.Del("a")
+	var b Batch
+	b.Set("b", []byte("2"))
+	b.Set("c", []byte("3"))
+	k.WriteBatch(&b)
+	stop()
+	k.Set("after", []byte("y"))
+
+	lines := parseChanges(t, &out.buf)
+	want := []string{"set a 1", "del a ", "set b 2", "set c 3"}
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines, want %d: %+v", len(lines), len(want), lines)
+	}
+	for i, l := range lines {
+		if got := fmt.Sprintf("%s %s %s", l.Op, l.Key, l.Value); got != want[i] {
+			t.Errorf("line %d = %q, want %q", i, got, want[i])
+		}
+		if l.TS.IsZero() || l.LSN == 0 {
+			t.Errorf("line %d lacks a timestamp or LSN: %+v", i, l)
+		}
+	}
+	if lines[1].LSN <= lines[0].LSN || lines[2].LSN != lines[3].LSN {
+		t.Errorf("LSNs = %d %d %d %d, want increasing with the batch sharing one",
+			lines[0].LSN, lines[1].LSN, lines[2].LSN, lines[3].LSN)
+	}
+}
+
+// blockingWriter holds every Write until release is closed.
+type blockingWriter struct {
+	release chan struct{}
+	out     syncBuffer
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return w.out.Write(p)
+}
+
+func TestStreamChangesSlowWriterDrops(t *testing.T) {
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"), WithNoSync())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	w := &blockingWriter{release: make(chan struct{})}
+	stop := k.StreamChanges(w)
+
+	const n = changeBufferSize + 100
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < n; i++ {
+			k.Set(fmt.Sprintf("k%d", i), nil)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("writes blocked on a slow change stream")
+	}
+	close(w.release)
+	// the gap is reported with the first change after the buffer drains
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		k.mu.Lock()
+		drained := true
+		for s := range k.streams {
+			drained = len(s.ch) == 0
+		}
+		k.mu.Unlock()
+		if drained {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("stream did not drain")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	k.Set("last", nil)
+	stop()
+
+	sets, dropped := 0, 0
+	for _, l := range parseChanges(t, &w.out.buf) {
+		switch l.Op {
+		case "set":
+			sets++
+		case "dropped":
+			dropped += l.Dropped
+		}
+	}
+	if dropped == 0 {
+		t.Error("no dropped marker after overflowing the buffer")
+	}
+	if sets+dropped != n+1 {
+		t.Errorf("%d delivered + %d dropped, want %d changes accounted for", sets, dropped, n+1)
+	}
+}
+
+func TestStreamChangesEndsOnClose(t *testing.T) {
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out syncBuffer
+	stop := k.StreamChanges(&out)
+	k.Set("a", nil)
+	k.Close()
+	stop()
+	if lines := parseChanges(t, &out.buf); len(lines) != 1 {
+		t.Errorf("got %d lines, want 1", len(lines))
+	}
+	// streams opened after Close are empty
+	k.StreamChanges(&out)()
+}
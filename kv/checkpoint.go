@@ -0,0 +1,130 @@
+package kv
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+)
+
+// A checkpoint file captures the whole index as of a log offset:
+// [4 bytes magic "GDC2"][8 bytes log offset][4 bytes entry count]
+// [8 bytes base LSN]
+// followed by that many framed set (or chunk) entries encoded like the log
+// itself, one write per key, with the base LSN chosen as in a log header.
+// NewKV loads it and replays only the log entries past the offset.
+// Checkpoints from before LSNs existed ("GDBC") are ignored.
+const (
+	checkpointMagic   = "GDC2"
+	checkpointHdrSize = 24
+)
+
+func checkpointPath(logPath string) string {
+	return logPath + ".ckpt"
+}
+
+// Checkpoint writes the current index to a durable checkpoint file next to
+// the log so the next open only replays entries appended after it.
+func (k *KV) Checkpoint() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.checkRewritable(); err != nil {
+		return err
+	}
+	if k.opts.replayFilter != nil {
+		return ErrReplayFiltered
+	}
+	// the checkpoint must not cover writes a crash could still lose
+	if k.unsynced > 0 {
+		if err := k.syncLog(); err != nil {
+			return err
+		}
+	}
+	offset, err := k.store.Size(k.logPath)
+	if err != nil {
+		return err
+	}
+
+	name := checkpointPath(k.logPath)
+	tmpName := name + ".tmp"
+	if err := k.store.Remove(tmpName); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	aliases := k.aliasPayloads(nil)
+	count := len(aliases)
+	for key, val := range k.data {
+		count += len(k.keyPayloads(key, val))
+	}
+	// an incomplete file holds fewer entries than the header's count, so it
+	// never validates
+	hdr := make([]byte, checkpointHdrSize)
+	copy(hdr[0:4], checkpointMagic)
+	binary.BigEndian.PutUint64(hdr[4:12], uint64(offset))
+	binary.BigEndian.PutUint32(hdr[12:16], uint32(count))
+	if n := uint64(len(k.data) + len(aliases)); n <= k.lsn {
+		binary.BigEndian.PutUint64(hdr[16:24], k.lsn-n)
+	}
+	if err := k.store.Append(tmpName, hdr); err != nil {
+		_ = k.store.Remove(tmpName)
+		return err
+	}
+	for key, val := range k.data {
+		buf := appendEntries(nil, k.format, k.keyPayloads(key, val))
+		if err := k.store.Append(tmpName, buf); err != nil {
+			_ = k.store.Remove(tmpName)
+			return err
+		}
+	}
+	var buf []byte
+	for _, payload := range aliases {
+		buf = appendLogEntry(buf, k.format, payload)
+	}
+	if err := k.store.Append(tmpName, buf); err != nil {
+		_ = k.store.Remove(tmpName)
+		return err
+	}
+	if err := k.store.Sync(tmpName); err != nil {
+		_ = k.store.Remove(tmpName)
+		return err
+	}
+	if err := k.store.Rename(tmpName, name); err != nil {
+		_ = k.store.Remove(tmpName)
+		return err
+	}
+	return nil
+}
+
+// loadCheckpoint reads the checkpoint for the log at logPath. ok is false if
+// there is none or it is incomplete or unusable for a log of logSize bytes,
+// in which case the caller falls back to a full replay.
+func loadCheckpoint(s Storage, logPath string, lf logFormat, logSize int64) (entries []logEntry, offset int64, baseLSN uint64, ok bool) {
+	r, err := sectionFrom(s, checkpointPath(logPath), 0)
+	if err != nil {
+		return nil, 0, 0, false
+	}
+	var hdr [checkpointHdrSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil || string(hdr[0:4]) != checkpointMagic {
+		return nil, 0, 0, false
+	}
+	offset = int64(binary.BigEndian.Uint64(hdr[4:12]))
+	count := int(binary.BigEndian.Uint32(hdr[12:16]))
+	baseLSN = binary.BigEndian.Uint64(hdr[16:24])
+	if offset < lf.headerLen() || offset > logSize {
+		return nil, 0, 0, false
+	}
+	entries, _, tail, err := readLog(r, checkpointHdrSize, lf, true)
+	if err != nil || tail != nil || len(entries) != count {
+		return nil, 0, 0, false
+	}
+	return entries, offset, baseLSN, true
+}
+
+// removeCheckpoint deletes the checkpoint before the log it describes is
+// replaced, so a crash can never pair it with a different log.
+func removeCheckpoint(s Storage, logPath string) error {
+	err := s.Remove(checkpointPath(logPath))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
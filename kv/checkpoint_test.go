@@ -0,0 +1,95 @@
+package kv
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckpointLimitsReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	k, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		k.Set(fmt.Sprintf("k%02d", i), []byte("before"))
+	}
+	if err := k.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.Set("k00", []byte("after"))
+	k.Set("new", []byte("after"))
+	k.Del("k01")
+	hash, lsn := k.StateHash(), k.LastLSN()
+	k.Close()
+
+	k, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := k.OpenInfo()
+	if info.CheckpointOffset != fi.Size() {
+		t.Errorf("CheckpointOffset = %d, want %d", info.CheckpointOffset, fi.Size())
+	}
+	if info.EntriesReplayed != 3 {
+		t.Errorf("EntriesReplayed = %d, want only the 3 writes after the checkpoint", info.EntriesReplayed)
+	}
+	if info.KeysLoaded != 50 {
+		t.Errorf("KeysLoaded = %d, want 50", info.KeysLoaded)
+	}
+	if k.StateHash() != hash {
+		t.Error("state after reopen differs")
+	}
+	if k.LastLSN() != lsn {
+		t.Errorf("LastLSN = %d, want %d", k.LastLSN(), lsn)
+	}
+	k.Close()
+
+	// a damaged checkpoint falls back to a full replay
+	cp := checkpointPath(path)
+	raw, err := os.ReadFile(cp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw[len(raw)-1] ^= 0xff
+	if err := os.WriteFile(cp, raw, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	k, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	if info := k.OpenInfo(); info.CheckpointOffset != 0 || info.EntriesReplayed != 53 {
+		t.Errorf("OpenInfo with a damaged checkpoint = %+v", info)
+	}
+	if k.StateHash() != hash {
+		t.Error("state after a full replay differs")
+	}
+}
+
+func TestCompactRemovesCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	k, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	k.Set("a", []byte("1"))
+	if err := k.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	k.Set("a", []byte("2"))
+	if err := k.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(checkpointPath(path)); !os.IsNotExist(err) {
+		t.Errorf("checkpoint of the old log survived Compact: %v", err)
+	}
+}
@@ -0,0 +1,31 @@
+package kv
+
+import "time"
+
+// Clock is the time source used for timestamps. Tests can supply a fake
+// one with WithClock to control time without sleeping.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// fixedClock always reports the same time.
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time { return time.Time(c) }
+
+// Ticker is implemented by Clocks that can also drive periodic work, such
+// as WithScheduledCompaction. Tick returns a channel delivering a time every
+// d and a function that stops it. Work is ticked by the wall clock when the
+// Clock does not implement Ticker.
+type Ticker interface {
+	Tick(d time.Duration) (c <-chan time.Time, stop func())
+}
+
+func (realClock) Tick(d time.Duration) (<-chan time.Time, func()) {
+	t := time.NewTicker(d)
+	return t.C, t.Stop
+}
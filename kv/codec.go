@@ -0,0 +1,111 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Codec converts Go values to and from bytes for SetEncoded and GetDecoded.
+type Codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+// An encoded value is codecMagic, one byte giving the length of the codec
+// name, the name, and then the codec's output, so GetDecoded can find the
+// codec a value was written with.
+const codecMagic = "\x00GDE"
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{
+		"json": jsonCodec{},
+		"gob":  gobCodec{},
+	}
+)
+
+// RegisterCodec makes c available to SetEncoded and GetDecoded under name,
+// replacing any codec registered under it before. "json" and "gob" are
+// registered by default. Names are stored with each value, so a codec must
+// stay registered under the same name for as long as values use it.
+func RegisterCodec(name string, c Codec) {
+	if name == "" || len(name) > 255 {
+		panic(fmt.Sprintf("kv: invalid codec name %q", name))
+	}
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[name] = c
+}
+
+func lookupCodec(name string) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownCodec, name)
+	}
+	return c, nil
+}
+
+// SetEncoded stores v under key encoded with the named codec.
+func (k *KV) SetEncoded(key string, v any, codec string) error {
+	c, err := lookupCodec(codec)
+	if err != nil {
+		return err
+	}
+	data, err := c.Marshal(v)
+	if err != nil {
+		return err
+	}
+	val := make([]byte, 0, len(codecMagic)+1+len(codec)+len(data))
+	val = append(val, codecMagic...)
+	val = append(val, byte(len(codec)))
+	val = append(val, codec...)
+	val = append(val, data...)
+	return k.Set(key, val)
+}
+
+// GetDecoded decodes the value at key into v with the codec it was stored
+// with by SetEncoded. found is false if the key is absent; a value not
+// written by SetEncoded is an error wrapping ErrNotEncoded.
+func (k *KV) GetDecoded(key string, v any) (found bool, err error) {
+	val, ok := k.Get(key)
+	if !ok {
+		return false, nil
+	}
+	if !bytes.HasPrefix(val, []byte(codecMagic)) || len(val) < len(codecMagic)+1 {
+		return true, ErrNotEncoded
+	}
+	n := int(val[len(codecMagic)])
+	start := len(codecMagic) + 1
+	if start+n > len(val) {
+		return true, ErrNotEncoded
+	}
+	c, err := lookupCodec(string(val[start : start+n]))
+	if err != nil {
+		return true, err
+	}
+	return true, c.Unmarshal(val[start+n:], v)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
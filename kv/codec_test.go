@@ -0,0 +1,79 @@
+package kv
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// lineCodec encodes a []string one element per line.
+type lineCodec struct{}
+
+func (lineCodec) Marshal(v any) ([]byte, error) {
+	lines, ok := v.([]string)
+	if !ok {
+		return nil, errors.New("lineCodec: not a []string")
+	}
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+func (lineCodec) Unmarshal(data []byte, v any) error {
+	p, ok := v.(*[]string)
+	if !ok {
+		return errors.New("lineCodec: not a *[]string")
+	}
+	*p = strings.Split(string(data), "\n")
+	return nil
+}
+
+func TestCodecs(t *testing.T) {
+	RegisterCodec("lines", lineCodec{})
+	defer func() {
+		codecsMu.Lock()
+		delete(codecs, "lines")
+		codecsMu.Unlock()
+	}()
+	path := filepath.Join(t.TempDir(), "a.log")
+	k, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+
+	want := []string{"first", "second", "third"}
+	for _, codec := range []string{"lines", "json", "gob"} {
+		if err := k.SetEncoded(codec, want, codec); err != nil {
+			t.Fatalf("SetEncoded with %s: %v", codec, err)
+		}
+	}
+	v, _ := k.Get("lines")
+	if !strings.HasSuffix(string(v), "first\nsecond\nthird") {
+		t.Errorf("stored value %q was not written by the lines codec", v)
+	}
+	k.Close()
+	k, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	for _, codec := range []string{"lines", "json", "gob"} {
+		var got []string
+		if found, err := k.GetDecoded(codec, &got); !found || err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("GetDecoded via %s = %q, %v, %v", codec, got, found, err)
+		}
+	}
+
+	if err := k.SetEncoded("x", want, "nope"); !errors.Is(err, ErrUnknownCodec) {
+		t.Errorf("SetEncoded with an unknown codec = %v", err)
+	}
+	k.Set("plain", []byte("raw"))
+	var got []string
+	if found, err := k.GetDecoded("plain", &got); !found || !errors.Is(err, ErrNotEncoded) {
+		t.Errorf("GetDecoded of a plain value = %v, %v", found, err)
+	}
+	if found, err := k.GetDecoded("missing", &got); found || err != nil {
+		t.Errorf("GetDecoded of a missing key = %v, %v", found, err)
+	}
+}
@@ -0,0 +1,466 @@
+package kv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCompactEstimateMatchesCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	k, err := Create(path, WithChunkSize(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	for i := 0; i < 5; i++ {
+		for j := 0; j < 10; j++ {
+			k.Set(fmt.Sprintf("k%d", j), bytes.Repeat([]byte{byte('a' + i)}, 10*(i+1)))
+		}
+	}
+	k.Del("k0")
+	k.Set("big", bytes.Repeat([]byte("b"), 200))
+	k.SetWithTTL("ttl", []byte("t"), time.Hour)
+	k.SetAlias("alias1", "k1")
+	k.SetAlias("alias2", "k2")
+
+	live, total, err := k.CompactEstimate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != fi.Size() {
+		t.Errorf("totalBytes = %d, want the file size %d", total, fi.Size())
+	}
+	if live >= total {
+		t.Errorf("liveBytes = %d, want less than %d after overwrites", live, total)
+	}
+	if err := k.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err = os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+	if live != fi.Size() {
+		t.Errorf("estimated %d bytes, compaction wrote %d", live, fi.Size())
+	}
+}
+
+// waitCompacting waits until a throttled Compact has released k.mu for its
+// copy.
+func waitCompacting(t *testing.T, k *KV) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		k.mu.RLock()
+		c := k.compacting
+		k.mu.RUnlock()
+		if c {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("compaction did not start copying")
+}
+
+func TestCompactThrottledDoesNotBlock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	// 30KB at 20KB/s with a full bucket takes about half a second
+	k, err := Create(path, WithCompactionThrottle(20000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	val := bytes.Repeat([]byte("v"), 3000)
+	for i := 0; i < 10; i++ {
+		k.Set(fmt.Sprintf("k%d", i), val)
+	}
+	k.Set("k0", []byte("old"))
+
+	done := make(chan error, 1)
+	go func() { done <- k.Compact() }()
+	waitCompacting(t, k)
+
+	start := time.Now()
+	if v, ok := k.Get("k1"); !ok || !bytes.Equal(v, val) {
+		t.Errorf("Get(k1) during compaction = %q, %v", v, ok)
+	}
+	if err := k.Set("k0", []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Del("k2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Set("late", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 200*time.Millisecond {
+		t.Errorf("reads and writes took %v during a throttled compaction", d)
+	}
+	if err := k.Reset(); !errors.Is(err, ErrCompactionInProgress) {
+		t.Errorf("Reset during compaction: err = %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if k.tombstones != 1 {
+		t.Errorf("tombstones = %d, want the one delete made during the copy", k.tombstones)
+	}
+	lsn := k.LastLSN()
+	k.Close()
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if v, _ := reopened.Get("k0"); string(v) != "new" {
+		t.Errorf("Get(k0) = %q, want the write made during the copy", v)
+	}
+	if _, ok := reopened.Get("k2"); ok {
+		t.Error("k2, deleted during the copy, came back")
+	}
+	if v, _ := reopened.Get("late"); string(v) != "1" {
+		t.Errorf("Get(late) = %q", v)
+	}
+	if got := reopened.LastLSN(); got != lsn {
+		t.Errorf("LastLSN after reopen = %d, want %d", got, lsn)
+	}
+}
+
+func TestCloseCutsThrottledCompactionShort(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	// the copy would take about 20 seconds
+	k, err := Create(path, WithCompactionThrottle(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	val := bytes.Repeat([]byte("v"), 1000)
+	for i := 0; i < 20; i++ {
+		k.Set(fmt.Sprintf("k%d", i), val)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- k.Compact() }()
+	waitCompacting(t, k)
+	start := time.Now()
+	if err := k.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Close waited %v for the compaction", d)
+	}
+	if err := <-done; !errors.Is(err, ErrClosed) {
+		t.Errorf("Compact err = %v, want ErrClosed", err)
+	}
+	if _, err := os.Stat(path + ".compact.tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file left behind: %v", err)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if keys := reopened.Keys(); len(keys) != 20 {
+		t.Errorf("%d keys after reopen, want 20", len(keys))
+	}
+}
+
+func TestConcurrentCompact(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.log")
+	k, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	for i := 0; i < 200; i++ {
+		k.Set(fmt.Sprintf("k%d", i%50), []byte(fmt.Sprint(i)))
+	}
+
+	errs := make(chan error, 8)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- k.Compact()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil && !errors.Is(err, ErrCompactionInProgress) {
+			t.Errorf("concurrent Compact = %v", err)
+		}
+	}
+
+	// a Compact that finds one running fails instead of waiting
+	k.compactMu.Lock()
+	err = k.Compact()
+	k.compactMu.Unlock()
+	if !errors.Is(err, ErrCompactionInProgress) {
+		t.Errorf("Compact during another = %v, want ErrCompactionInProgress", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("files left after compacting: %v", entries)
+	}
+	k.Close()
+	k, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	for i := 150; i < 200; i++ {
+		if v, _ := k.Get(fmt.Sprintf("k%d", i%50)); string(v) != fmt.Sprint(i) {
+			t.Errorf("k%d = %q after reopening, want %d", i%50, v, i)
+		}
+	}
+}
+
+func TestCompactToNewFormat(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "a.log")
+	k, err := Create(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	for i := 0; i < 10; i++ {
+		k.Set(fmt.Sprintf("k%d", i), bytes.Repeat([]byte{byte('a' + i)}, 100))
+	}
+	k.Del("k0")
+	k.SetWithTTL("ttl", []byte("t"), time.Hour)
+	before, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(dir, "b.log")
+	if err := k.CompactTo(dst, WithChecksum(ChecksumCastagnoli), WithChunkSize(32)); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := os.ReadFile(src); !bytes.Equal(before, after) {
+		t.Error("CompactTo changed the active log")
+	}
+	if err := k.CompactTo(src); err == nil {
+		t.Error("CompactTo onto the active log succeeded")
+	}
+	k.Set("k1", []byte("later"))
+
+	c, err := Open(dst, WithChunkSize(32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.Checksum() != ChecksumCastagnoli {
+		t.Errorf("copy uses %v, want Castagnoli", c.Checksum())
+	}
+	if _, ok := c.Get("k0"); ok {
+		t.Error("deleted key copied")
+	}
+	if v, _ := c.Get("k1"); !bytes.Equal(v, bytes.Repeat([]byte("b"), 100)) {
+		t.Errorf("k1 = %q in the copy, want the value at CompactTo time", v)
+	}
+	if ttl, ok := c.TTL("ttl"); !ok || ttl <= 0 {
+		t.Errorf("TTL lost in the copy: %v, %v", ttl, ok)
+	}
+	if n := c.Stats().Keys; n != 10 {
+		t.Errorf("copy has %d keys, want 10", n)
+	}
+}
+
+func TestCompactIfNeeded(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	k, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	for i := 0; i < 10; i++ {
+		k.Set(fmt.Sprintf("k%d", i), []byte("value"))
+	}
+	// one overwrite in eleven writes reclaims well under half
+	k.Set("k0", []byte("again"))
+	size := func() int64 {
+		t.Helper()
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fi.Size()
+	}
+	before := size()
+	if ran, err := k.CompactIfNeeded(0.5); err != nil || ran {
+		t.Errorf("CompactIfNeeded(0.5) = %v, %v; want no compaction", ran, err)
+	}
+	if size() != before {
+		t.Error("the log changed without a compaction")
+	}
+
+	for i := 0; i < 30; i++ {
+		k.Set("k0", []byte(fmt.Sprint(i)))
+	}
+	if ran, err := k.CompactIfNeeded(0.5); err != nil || !ran {
+		t.Errorf("CompactIfNeeded(0.5) = %v, %v; want a compaction", ran, err)
+	}
+	if size() >= before {
+		t.Errorf("log is %d bytes after compacting, was %d before the churn", size(), before)
+	}
+	if v, _ := k.Get("k0"); string(v) != "29" {
+		t.Errorf("k0 = %q after compacting, want 29", v)
+	}
+}
+
+func TestExportSubset(t *testing.T) {
+	dir := t.TempDir()
+	k, err := Create(filepath.Join(dir, "a.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	for _, tenant := range []string{"acme", "globex", "acme2"} {
+		for i := 0; i < 3; i++ {
+			k.Set(fmt.Sprintf("%s:%d", tenant, i), []byte(tenant))
+		}
+	}
+	k.Del("acme:1")
+	k.SetWithTTL("acme:ttl", []byte("t"), time.Hour)
+	k.SetWithTTL("acme:expired", []byte("t"), -time.Second)
+
+	dst := filepath.Join(dir, "acme.log")
+	if err := k.ExportSubset(dst, func(key string) bool { return strings.HasPrefix(key, "acme:") }); err != nil {
+		t.Fatal(err)
+	}
+	e, err := Open(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	if got := fmt.Sprint(e.Keys()); got != "[acme:0 acme:2 acme:ttl]" {
+		t.Errorf("exported keys = %s", got)
+	}
+	if v, _ := e.Get("acme:2"); string(v) != "acme" {
+		t.Errorf("acme:2 = %q in the export", v)
+	}
+	if ttl, ok := e.TTL("acme:ttl"); !ok || ttl <= 0 {
+		t.Errorf("exported TTL = %v, %v", ttl, ok)
+	}
+	if n := e.Stats().Keys; n != 3 {
+		t.Errorf("export holds %d keys, want 3", n)
+	}
+	if n := len(k.Keys()); n != 9 {
+		t.Errorf("source has %d keys after the export, want 9", n)
+	}
+}
+
+func TestCompactOnOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	k, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		k.Set("key", []byte(fmt.Sprint(i)))
+	}
+	k.Close()
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grown := fi.Size()
+
+	// under the threshold nothing happens
+	k, err = Open(path, WithCompactOnOpenIf(grown))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.OpenInfo().CompactedOnOpen {
+		t.Error("a log at the threshold was compacted")
+	}
+	k.Close()
+
+	k, err = Open(path, WithCompactOnOpenIf(grown-1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	info := k.OpenInfo()
+	if !info.CompactedOnOpen || info.EntriesReplayed != 100 {
+		t.Errorf("OpenInfo = %+v, want a compaction after replaying 100 entries", info)
+	}
+	if fi, err = os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() >= grown {
+		t.Errorf("log is %d bytes after compacting on open, was %d", fi.Size(), grown)
+	}
+	if v, _ := k.Get("key"); string(v) != "99" {
+		t.Errorf("key = %q, want 99", v)
+	}
+}
+
+func TestCompactToDedupValues(t *testing.T) {
+	dir := t.TempDir()
+	k, err := Create(filepath.Join(dir, "a.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	shared := bytes.Repeat([]byte("shared value "), 100)
+	for i := 0; i < 10; i++ {
+		k.Set(fmt.Sprintf("dup%d", i), shared)
+	}
+	k.Set("unique", []byte("u"))
+	k.SetWithTTL("dupttl", shared, time.Hour)
+
+	plain, dedup := filepath.Join(dir, "plain.log"), filepath.Join(dir, "dedup.log")
+	if err := k.CompactTo(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.CompactTo(dedup, WithDedupValues()); err != nil {
+		t.Fatal(err)
+	}
+	pfi, err := os.Stat(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dfi, err := os.Stat(dedup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dfi.Size() >= pfi.Size()/5 {
+		t.Errorf("deduplicated copy is %d bytes, plain %d", dfi.Size(), pfi.Size())
+	}
+
+	d, err := Open(dedup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if d.StateHash() != k.StateHash() {
+		t.Error("deduplicated copy holds different data")
+	}
+	if ttl, ok := d.TTL("dupttl"); !ok || ttl <= 0 {
+		t.Errorf("TTL of a deduplicated key = %v, %v", ttl, ok)
+	}
+	// a key rewritten after replay no longer shares the value
+	d.Set("dup0", []byte("changed"))
+	if v, _ := d.Get("dup1"); !bytes.Equal(v, shared) {
+		t.Error("writing one key changed another sharing its value")
+	}
+}
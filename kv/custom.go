@@ -0,0 +1,80 @@
+package kv
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// OpCustom is the first of the entry types reserved for applications,
+// OpCustom to 255. godb never uses them itself; see RegisterEntryHandler.
+const OpCustom EntryType = 128
+
+var (
+	entryHandlersMu sync.RWMutex
+	entryHandlers   = map[EntryType]func(payload []byte) error{}
+)
+
+// RegisterEntryHandler makes entries of type typ, which must be OpCustom or
+// above, part of the log format. replay is called with the payload given to
+// WriteCustom for each such entry as a log is replayed: on open, by a
+// standby catching up, by SwapFile and ImportStream. An error from replay
+// fails the replay as corruption at that entry. A log holding a type with
+// no handler registered cannot be opened, so register handlers before
+// opening. replay must not retain payload or use the KV being replayed.
+//
+// Custom entries are not part of the key space: Compact and CompactTo drop
+// them, as they do overwritten values.
+func RegisterEntryHandler(typ EntryType, replay func(payload []byte) error) {
+	if typ < OpCustom {
+		panic(fmt.Sprintf("kv: entry type %d is reserved", typ))
+	}
+	entryHandlersMu.Lock()
+	defer entryHandlersMu.Unlock()
+	entryHandlers[typ] = replay
+}
+
+func lookupEntryHandler(typ EntryType) (func(payload []byte) error, error) {
+	entryHandlersMu.RLock()
+	defer entryHandlersMu.RUnlock()
+	h, ok := entryHandlers[typ]
+	if !ok {
+		return nil, fmt.Errorf("%w %d", ErrUnknownEntryType, typ)
+	}
+	return h, nil
+}
+
+// WriteCustom durably appends an entry of type typ carrying payload. typ
+// must have been registered with RegisterEntryHandler, so the log stays
+// readable. The handler is not called for the write itself.
+func (k *KV) WriteCustom(typ EntryType, payload []byte) error {
+	if _, err := lookupEntryHandler(typ); err != nil {
+		return err
+	}
+	release, err := k.admit()
+	if err != nil {
+		return err
+	}
+	defer release()
+	if err := k.throttle(context.Background(), 8+1+len(payload)); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.checkWritable(); err != nil {
+		return err
+	}
+	return k.writeEntries(append([]byte{byte(typ)}, payload...))
+}
+
+// applyCustom replays a custom entry through its handler.
+func (k *KV) applyCustom(payload []byte) error {
+	h, err := lookupEntryHandler(EntryType(payload[0]))
+	if err != nil {
+		return err
+	}
+	if k.verifying {
+		return nil
+	}
+	return h(payload[1:])
+}
@@ -0,0 +1,100 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+const (
+	migrationType  = OpCustom + 1
+	unregisteredTy = OpCustom + 2
+)
+
+func TestCustomEntries(t *testing.T) {
+	var replayed []string
+	var failWith error
+	RegisterEntryHandler(migrationType, func(payload []byte) error {
+		replayed = append(replayed, string(payload))
+		return failWith
+	})
+
+	s := NewMemoryStorage()
+	k, err := Create("a.log", WithStorage(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.Set("a", []byte("1"))
+	if err := k.WriteCustom(migrationType, []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	k.Set("b", []byte("2"))
+	if err := k.WriteCustom(migrationType, []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.WriteCustom(unregisteredTy, nil); !errors.Is(err, ErrUnknownEntryType) {
+		t.Errorf("WriteCustom of an unregistered type = %v, want ErrUnknownEntryType", err)
+	}
+	if err := k.WriteCustom(OpSet, nil); !errors.Is(err, ErrUnknownEntryType) {
+		t.Errorf("WriteCustom of a built-in type = %v, want ErrUnknownEntryType", err)
+	}
+	if len(replayed) != 0 {
+		t.Errorf("the handler ran for writes: %q", replayed)
+	}
+	k.Close()
+
+	k, err = Open("a.log", WithStorage(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(replayed); got != "[v1 v2]" {
+		t.Errorf("handler replayed %s, want [v1 v2]", got)
+	}
+	if n := k.Stats().Keys; n != 2 {
+		t.Errorf("%d keys after replay, want 2", n)
+	}
+	k.Close()
+
+	// a handler error fails the open as corruption at that entry
+	replayed, failWith = nil, errors.New("bad migration")
+	var ce *CorruptionError
+	if _, err := Open("a.log", WithStorage(s)); !errors.Is(err, failWith) || !errors.As(err, &ce) {
+		t.Errorf("Open with a failing handler = %v, want its error as a *CorruptionError", err)
+	}
+	failWith = nil
+
+	// Compact drops custom entries with the other dead records
+	k, err = Open("a.log", WithStorage(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	k.Close()
+	replayed = nil
+	k, err = Open("a.log", WithStorage(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replayed) != 0 {
+		t.Errorf("compacted log still replayed %q", replayed)
+	}
+	lf := k.format
+	k.Close()
+
+	// a log holding a type with no handler cannot be opened
+	if err := s.Append("a.log", appendLogEntry(nil, lf, []byte{byte(unregisteredTy)})); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open("a.log", WithStorage(s)); !errors.Is(err, ErrUnknownEntryType) {
+		t.Errorf("Open with an unregistered entry type = %v, want ErrUnknownEntryType", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("RegisterEntryHandler accepted a reserved type")
+		}
+	}()
+	RegisterEntryHandler(OpAlias, func([]byte) error { return nil })
+}
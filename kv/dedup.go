@@ -0,0 +1,97 @@
+package kv
+
+import (
+	"crypto/sha256"
+	"fmt"
+)
+
+// buildBlobPayload encodes [type][32 byte sha256][value]: a value shared by
+// several keys, stored once by a deduplicating compaction.
+func buildBlobPayload(sum [sha256.Size]byte, val []byte) []byte {
+	buf := make([]byte, 0, 1+sha256.Size+len(val))
+	buf = append(buf, byte(OpBlob))
+	buf = append(buf, sum[:]...)
+	return append(buf, val...)
+}
+
+// decodeBlob parses a blob payload; the returned value aliases payload.
+func decodeBlob(payload []byte) (sum [sha256.Size]byte, val []byte, err error) {
+	if len(payload) < 1+sha256.Size {
+		return sum, nil, fmt.Errorf("%w: blob entry", ErrMalformedEntry)
+	}
+	copy(sum[:], payload[1:])
+	return sum, payload[1+sha256.Size:], nil
+}
+
+// buildRefPayload encodes [type][key len][key][32 byte sha256]: a set of key
+// to the value of an earlier blob entry.
+func buildRefPayload(key string, sum [sha256.Size]byte) []byte {
+	payload := buildDelPayload([]byte(key))
+	payload[0] = byte(OpRef)
+	return append(payload, sum[:]...)
+}
+
+func decodeRef(payload []byte) (key string, sum [sha256.Size]byte, err error) {
+	key, err = decodeDel(payload[:max(len(payload)-sha256.Size, 0)])
+	if err != nil || len(payload) != 1+4+len(key)+sha256.Size {
+		return "", sum, fmt.Errorf("%w: ref entry", ErrMalformedEntry)
+	}
+	copy(sum[:], payload[len(payload)-sha256.Size:])
+	return key, sum, nil
+}
+
+// applyBlob keeps a blob's value for the refs that follow it in the file
+// being replayed. The value is capped so that appending to one key's copy
+// never writes into another's.
+func (k *KV) applyBlob(sum [sha256.Size]byte, val []byte) {
+	if k.blobs == nil {
+		k.blobs = make(map[[sha256.Size]byte][]byte)
+	}
+	v := append([]byte(nil), val...)
+	k.blobs[sum] = v[:len(v):len(v)]
+}
+
+// deduper rewrites the entries of a deduplicating compaction so each value
+// held by more than one key is written once as a blob and referenced by
+// hash from each key.
+type deduper struct {
+	sums    map[string][sha256.Size]byte
+	count   map[[sha256.Size]byte]int
+	written map[[sha256.Size]byte]bool
+}
+
+// newDeduper hashes the values of keys, the keys a rewrite writes. Values no longer than a hash, or that chunkSize would split,
+// are left alone. Callers must hold k.mu.
+func (k *KV) newDeduper(chunkSize int, keys []string) *deduper {
+	d := &deduper{
+		sums:    make(map[string][sha256.Size]byte),
+		count:   make(map[[sha256.Size]byte]int),
+		written: make(map[[sha256.Size]byte]bool),
+	}
+	for _, key := range keys {
+		val := k.data[key]
+		if len(val) <= sha256.Size || (chunkSize > 0 && len(val) > chunkSize) {
+			continue
+		}
+		sum := sha256.Sum256(val)
+		d.sums[key] = sum
+		d.count[sum]++
+	}
+	return d
+}
+
+// rewrite returns key's entries with its set entry replaced by a ref, and
+// preceded by the blob the first time the value is seen, when the value is
+// shared. payloads are the key's entries as returned by keyPayloadsChunked.
+func (d *deduper) rewrite(key string, val []byte, payloads [][]byte) [][]byte {
+	sum, ok := d.sums[key]
+	if !ok || d.count[sum] < 2 {
+		return payloads
+	}
+	out := [][]byte{buildRefPayload(key, sum)}
+	if !d.written[sum] {
+		d.written[sum] = true
+		out = [][]byte{buildBlobPayload(sum, val), out[0]}
+	}
+	return append(out, payloads[1:]...)
+}
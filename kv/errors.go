@@ -0,0 +1,83 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+var (
+	// ErrClosed is returned by operations on a KV after Close.
+	ErrClosed = errors.New("kv: database is closed")
+	// ErrReplayFiltered is returned by operations that rewrite the log from
+	// memory when only part of it was loaded (see WithReplayFilter).
+	ErrReplayFiltered = errors.New("kv: database was opened with a replay filter")
+	// ErrCompactionInProgress is returned by Compact while another
+	// compaction of the same KV is running, and by the other rewrites of
+	// the log while a throttled Compact is copying.
+	ErrCompactionInProgress = errors.New("kv: compaction already in progress")
+	// ErrReadOnly is returned by writes to a standby opened with OpenStandby.
+	ErrReadOnly = errors.New("kv: database is read-only")
+	// ErrNotStandby is returned by Promote on a KV not opened with OpenStandby.
+	ErrNotStandby = errors.New("kv: database is not a standby")
+	// ErrNotExist is matched (via errors.Is) by the error Open returns for a
+	// missing log file.
+	ErrNotExist = fs.ErrNotExist
+	// ErrExist is matched by the error Create returns when the log file is
+	// already there.
+	ErrExist = fs.ErrExist
+	// ErrNotList is returned by the List methods for a key whose value was
+	// not written by ListPush.
+	ErrNotList = errors.New("kv: value is not a list")
+	// ErrUnknownCodec is returned for a codec name not registered with
+	// RegisterCodec.
+	ErrUnknownCodec = errors.New("kv: unknown codec")
+	// ErrNotEncoded is returned by GetDecoded for a value not written by
+	// SetEncoded.
+	ErrNotEncoded = errors.New("kv: value was not written by SetEncoded")
+	// ErrUnsupportedVersion is returned when opening a log whose format
+	// version this build cannot read.
+	ErrUnsupportedVersion = errors.New("kv: unsupported log version")
+	// ErrBusy is returned by writes rejected because WithMaxInflightWrites'
+	// limit was reached.
+	ErrBusy = errors.New("kv: too many writes in flight")
+	// ErrAliasLoop is returned by SetAlias for an alias that would form a
+	// cycle or a chain longer than WithMaxAliasHops.
+	ErrAliasLoop = errors.New("kv: alias loop or chain too long")
+	// ErrNoSpace is returned by writes that failed because the disk is
+	// full. The partial write is cut off the log and memory is unchanged,
+	// so the KV stays usable once space is freed.
+	ErrNoSpace = errors.New("kv: no space left on device")
+	// ErrPaused is returned by writes to a KV paused with Pause.
+	ErrPaused = errors.New("kv: database is paused")
+)
+
+// Corruption kinds found while reading a log. They are wrapped in a
+// *CorruptionError carrying the offset, so use errors.Is to tell them apart.
+var (
+	// ErrMalformedEntry means an entry's payload does not decode.
+	ErrMalformedEntry = errors.New("kv: malformed entry")
+	// ErrUnknownEntryType means an entry has a type byte this version does
+	// not understand.
+	ErrUnknownEntryType = errors.New("kv: unknown entry type")
+	// ErrChecksumMismatch means an entry's CRC does not match its payload.
+	ErrChecksumMismatch = errors.New("kv: checksum mismatch")
+	// ErrTruncatedEntry means the log ends partway through an entry.
+	ErrTruncatedEntry = errors.New("kv: truncated entry")
+	// ErrUnterminatedBatch means a batch has no matching commit marker.
+	ErrUnterminatedBatch = errors.New("kv: unterminated batch")
+)
+
+// CorruptionError reports a damaged entry and where it starts in the file.
+type CorruptionError struct {
+	Offset int64
+	Err    error
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("%v at offset %d", e.Err, e.Offset)
+}
+
+func (e *CorruptionError) Unwrap() error {
+	return e.Err
+}
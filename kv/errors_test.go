@@ -0,0 +1,143 @@
+package kv
+
+import (
+	"errors"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+)
+
+func TestCorruptionErrorOffset(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		payload []byte
+		want    error
+	}{
+		{"unknown type", []byte{99, 0, 0}, ErrUnknownEntryType},
+		{"malformed set", []byte{byte(OpSet), 0, 0, 0, 9}, ErrMalformedEntry},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewMemoryStorage()
+			k, err := Create("a.log", WithStorage(s))
+			if err != nil {
+				t.Fatal(err)
+			}
+			k.Set("a", []byte("1"))
+			lf := k.format
+			k.Close()
+			off, err := s.Size("a.log")
+			if err != nil {
+				t.Fatal(err)
+			}
+			bad := appendLogEntry(nil, lf, tc.payload)
+			good := appendLogEntry(nil, lf, buildSetPayload([]byte("b"), []byte("2")))
+			if err := s.Append("a.log", append(bad, good...)); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = Open("a.log", WithStorage(s))
+			var ce *CorruptionError
+			if !errors.As(err, &ce) {
+				t.Fatalf("Open = %v, want a *CorruptionError", err)
+			}
+			if ce.Offset != off {
+				t.Errorf("Offset = %d, want %d", ce.Offset, off)
+			}
+			if !errors.Is(err, tc.want) {
+				t.Errorf("Open = %v, want %v", err, tc.want)
+			}
+		})
+	}
+
+	// a damaged CRC is reported the same way, here as the tail cut at open
+	s := NewMemoryStorage()
+	k, err := Create("a.log", WithStorage(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.Set("a", []byte("1"))
+	off, _ := s.Size("a.log")
+	k.Set("b", []byte("2"))
+	k.Close()
+	flipByte(t, s, "a.log", off+8)
+	k, err = Open("a.log", WithStorage(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	var ce *CorruptionError
+	if tail := k.OpenInfo().TailError; !errors.As(tail, &ce) || !errors.Is(tail, ErrChecksumMismatch) || ce.Offset != off {
+		t.Errorf("TailError = %v, want a checksum mismatch at offset %d", tail, off)
+	}
+}
+
+// fullDiskStorage writes half of each append once full is set and then
+// fails it with ENOSPC, as a disk filling up mid-write would.
+type fullDiskStorage struct {
+	Storage
+	full atomic.Bool
+}
+
+func (s *fullDiskStorage) Append(name string, data []byte) error {
+	if !s.full.Load() {
+		return s.Storage.Append(name, data)
+	}
+	if err := s.Storage.Append(name, data[:len(data)/2]); err != nil {
+		return err
+	}
+	return &os.PathError{Op: "write", Path: name, Err: syscall.ENOSPC}
+}
+
+func TestNoSpace(t *testing.T) {
+	s := &fullDiskStorage{Storage: NewMemoryStorage()}
+	k, err := Create("a.log", WithStorage(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.Set("a", []byte("1"))
+	size, _ := s.Size("a.log")
+
+	s.full.Store(true)
+	for name, write := range map[string]func() error{
+		"Set": func() error { return k.Set("a", []byte("2")) },
+		"Del": func() error { return k.Del("a") },
+		"WriteBatch": func() error {
+			var b Batch
+			b.Set("b", []byte("3"))
+			b.Set("c", []byte("4"))
+			return k.WriteBatch(&b)
+		},
+	} {
+		if err := write(); !errors.Is(err, ErrNoSpace) || !errors.Is(err, syscall.ENOSPC) {
+			t.Errorf("%s = %v, want ErrNoSpace wrapping ENOSPC", name, err)
+		}
+		if got, _ := s.Size("a.log"); got != size {
+			t.Errorf("after %s the log is %d bytes, want %d", name, got, size)
+		}
+	}
+	if v, ok := k.Get("a"); !ok || string(v) != "1" {
+		t.Errorf("Get(a) = %q, %v, want the value from before the disk filled", v, ok)
+	}
+	if _, ok := k.Get("b"); ok {
+		t.Error("keys from the failed batch are visible")
+	}
+
+	// with space again the log carries on cleanly and reopens without a tail
+	s.full.Store(false)
+	if err := k.Set("b", []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	k.Close()
+	k, err = Open("a.log", WithStorage(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	if tail := k.OpenInfo().TailError; tail != nil {
+		t.Errorf("TailError = %v, want a clean log", tail)
+	}
+	if v, _ := k.Get("b"); string(v) != "3" {
+		t.Errorf("Get(b) = %q, want 3", v)
+	}
+}
@@ -0,0 +1,79 @@
+package kv
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+// EstimateReplay reports roughly how much work NewKV would do to open the
+// log at logPath: how many entries it would replay and how many bytes it
+// would read, header included, counting a checkpoint if one would be used.
+// It reads only the frame headers, skipping payloads and checksums, so it
+// is much cheaper than opening the log and can be used to pick a startup
+// timeout. Batch markers and padding are counted as entries, and a damaged
+// log may stop replay earlier than the estimate suggests.
+func EstimateReplay(logPath string) (entries int, bytes int64, err error) {
+	f, err := os.Open(logPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+	size := fi.Size()
+	lf, ok, err := readHeader(f)
+	if err != nil {
+		return 0, 0, err
+	}
+	if !ok {
+		// NewKV would just write a fresh header
+		return 0, 0, nil
+	}
+	start := lf.headerLen()
+	if count, offset, cpBytes, ok := checkpointExtent(logPath, lf, size); ok {
+		entries += count
+		bytes += cpBytes
+		start = offset
+	}
+	off := start
+	var hdr [8]byte
+	for off+8 <= size {
+		if _, err := f.ReadAt(hdr[:], off); err != nil {
+			return 0, 0, err
+		}
+		next := off + 8 + int64(lf.order().Uint32(hdr[0:4]))
+		if next > size {
+			break
+		}
+		entries++
+		off = next
+	}
+	return entries, lf.headerLen() + bytes + off - start, nil
+}
+
+// checkpointExtent reads just the header of the checkpoint for the log at
+// logPath and reports the entries it holds, the log offset it covers and
+// its size, if it looks usable for a log of logSize bytes.
+func checkpointExtent(logPath string, lf logFormat, logSize int64) (count int, offset, size int64, ok bool) {
+	f, err := os.Open(checkpointPath(logPath))
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	defer f.Close()
+	var hdr [checkpointHdrSize]byte
+	if _, err := io.ReadFull(f, hdr[:]); err != nil || string(hdr[0:4]) != checkpointMagic {
+		return 0, 0, 0, false
+	}
+	offset = int64(binary.BigEndian.Uint64(hdr[4:12]))
+	if offset < lf.headerLen() || offset > logSize {
+		return 0, 0, 0, false
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	return int(binary.BigEndian.Uint32(hdr[12:16])), offset, fi.Size(), true
+}
@@ -0,0 +1,48 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestEstimateReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	k, err := Create(path, WithNoSync())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		k.Set(fmt.Sprintf("k%d", i%300), []byte("some value"))
+	}
+	for i := 0; i < 10; i++ {
+		var b Batch
+		b.Set(fmt.Sprintf("b%d", i), nil)
+		b.Del(fmt.Sprintf("k%d", i))
+		k.WriteBatch(&b)
+	}
+	k.Close()
+
+	entries, n, err := EstimateReplay(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	info := k.OpenInfo()
+	// the estimate also counts the batch markers
+	if entries < info.EntriesReplayed || entries > info.EntriesReplayed*11/10 {
+		t.Errorf("estimated %d entries, open replayed %d", entries, info.EntriesReplayed)
+	}
+	if n != info.BytesRead {
+		t.Errorf("estimated %d bytes, open read %d", n, info.BytesRead)
+	}
+
+	if _, _, err := EstimateReplay(filepath.Join(t.TempDir(), "missing.log")); !errors.Is(err, ErrNotExist) {
+		t.Errorf("EstimateReplay of a missing log = %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Checksum selects the CRC32 polynomial protecting each log entry.
+type Checksum uint8
+
+const (
+	// ChecksumIEEE is the original polynomial and the default.
+	ChecksumIEEE Checksum = 1
+	// ChecksumCastagnoli (crc32c) is hardware accelerated on most modern CPUs.
+	ChecksumCastagnoli Checksum = 2
+)
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+func (c Checksum) table() *crc32.Table {
+	if c == ChecksumCastagnoli {
+		return castagnoliTable
+	}
+	return crc32.IEEETable
+}
+
+// Sum returns the CRC32 of p using polynomial c.
+func (c Checksum) Sum(p []byte) uint32 {
+	return crc32.Checksum(p, c.table())
+}
+
+func (c Checksum) valid() bool {
+	return c == ChecksumIEEE || c == ChecksumCastagnoli
+}
+
+// Log files start with a fixed-size header:
+// [4 bytes magic "GODB"][2 bytes version][1 byte checksum][1 byte flags]
+// [8 bytes base LSN]
+// The only flag is headerLittleEndian, see WithByteOrder.
+// Files written before the header existed have none and are read as
+// version 1 with IEEE checksums.
+const (
+	logMagic   = "GODB"
+	headerSize = 16
+
+	logVersion1 uint16 = 1
+	logVersion2 uint16 = 2
+
+	headerLittleEndian byte = 1
+)
+
+// logFormat describes how entries in a log file are encoded.
+type logFormat struct {
+	version  uint16
+	checksum Checksum
+	// baseLSN is the LSN just before the file's first write; see LastLSN.
+	baseLSN uint64
+	// littleEndian is set for files written with WithByteOrder(binary.LittleEndian).
+	littleEndian bool
+}
+
+func newLogFormat(o options) logFormat {
+	return logFormat{
+		version:      logVersion2,
+		checksum:     o.checksum,
+		littleEndian: o.byteOrder == binary.LittleEndian,
+	}
+}
+
+// headerLen is the offset of the first entry in the file.
+func (lf logFormat) headerLen() int64 {
+	if lf.version == logVersion1 {
+		return 0
+	}
+	return headerSize
+}
+
+// encodeHeader returns the file header for lf. Version 1 files have no
+// header, so it is empty for them.
+func encodeHeader(lf logFormat) []byte {
+	if lf.version == logVersion1 {
+		return nil
+	}
+	hdr := make([]byte, headerSize)
+	copy(hdr[0:4], logMagic)
+	binary.BigEndian.PutUint16(hdr[4:6], lf.version)
+	hdr[6] = byte(lf.checksum)
+	if lf.littleEndian {
+		hdr[7] |= headerLittleEndian
+	}
+	binary.BigEndian.PutUint64(hdr[8:16], lf.baseLSN)
+	return hdr
+}
+
+// readHeader reads the header at the start of f. ok is false when the file
+// holds no complete header yet (empty, or torn while being created), in
+// which case the caller should write a fresh one.
+func readHeader(f io.ReaderAt) (lf logFormat, ok bool, err error) {
+	var hdr [headerSize]byte
+	n, err := f.ReadAt(hdr[:], 0)
+	if err != nil && err != io.EOF {
+		return logFormat{}, false, err
+	}
+	if m := min(n, len(logMagic)); !bytes.Equal(hdr[:m], []byte(logMagic)[:m]) {
+		// headerless log from before the header existed
+		return logFormat{version: logVersion1, checksum: ChecksumIEEE}, true, nil
+	}
+	if n < headerSize {
+		return logFormat{}, false, nil
+	}
+	lf, err = decodeHeader(hdr[:])
+	if err != nil {
+		return logFormat{}, false, err
+	}
+	return lf, true, nil
+}
+
+// decodeHeader parses a complete version 2 header.
+func decodeHeader(hdr []byte) (lf logFormat, err error) {
+	lf.version = binary.BigEndian.Uint16(hdr[4:6])
+	lf.checksum = Checksum(hdr[6])
+	lf.littleEndian = hdr[7]&headerLittleEndian != 0
+	lf.baseLSN = binary.BigEndian.Uint64(hdr[8:16])
+	if lf.version != logVersion2 {
+		return logFormat{}, fmt.Errorf("%w %d (this build reads versions 1 to %d; see UpgradeLog)", ErrUnsupportedVersion, lf.version, LatestLogVersion)
+	}
+	if !lf.checksum.valid() {
+		return logFormat{}, fmt.Errorf("unknown checksum type %d", lf.checksum)
+	}
+	return lf, nil
+}
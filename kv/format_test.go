@@ -0,0 +1,86 @@
+package kv
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestChecksumRecordedInHeader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	k, err := Create(path, WithChecksum(ChecksumCastagnoli))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.Set("a", []byte("1"))
+	k.Close()
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame := raw[headerSize:]
+	payload := frame[8 : 8+binary.BigEndian.Uint32(frame[0:4])]
+	crc := binary.BigEndian.Uint32(frame[4:8])
+	if crc != ChecksumCastagnoli.Sum(payload) || crc == ChecksumIEEE.Sum(payload) {
+		t.Errorf("entry CRC %#x is not the Castagnoli sum of its payload", crc)
+	}
+
+	// the header, not the option, decides the polynomial on reopen
+	reopened, err := Open(path, WithChecksum(ChecksumIEEE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if c := reopened.Checksum(); c != ChecksumCastagnoli {
+		t.Errorf("Checksum after reopen = %d, want Castagnoli", c)
+	}
+	if v, ok := reopened.Get("a"); !ok || string(v) != "1" {
+		t.Errorf("Get(a) = %q, %v", v, ok)
+	}
+	if info := reopened.OpenInfo(); info.EntriesReplayed != 1 {
+		t.Errorf("EntriesReplayed = %d, want 1", info.EntriesReplayed)
+	}
+}
+
+func TestHeaderlessLogReadAsIEEE(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	lf := logFormat{version: logVersion1, checksum: ChecksumIEEE}
+	buf := appendLogEntry(nil, lf, buildSetPayload([]byte("a"), []byte("1")))
+	if err := os.WriteFile(path, buf, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	k, err := Open(path, WithChecksum(ChecksumCastagnoli))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	if v, ok := k.Get("a"); !ok || string(v) != "1" {
+		t.Errorf("Get(a) = %q, %v", v, ok)
+	}
+	if c := k.Checksum(); c != ChecksumIEEE {
+		t.Errorf("Checksum = %d, want IEEE for a headerless log", c)
+	}
+}
+
+func BenchmarkChecksum(b *testing.B) {
+	payload := make([]byte, 1<<20)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	for _, bc := range []struct {
+		name string
+		c    Checksum
+	}{
+		{"IEEE", ChecksumIEEE},
+		{"Castagnoli", ChecksumCastagnoli},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.SetBytes(int64(len(payload)))
+			for i := 0; i < b.N; i++ {
+				bc.c.Sum(payload)
+			}
+		})
+	}
+}
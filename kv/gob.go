@@ -0,0 +1,98 @@
+package kv
+
+import (
+	"encoding/gob"
+	"io"
+	"time"
+)
+
+// gobSnapshot is what SnapshotGob writes: the live data set and the LSN it
+// was taken at.
+type gobSnapshot struct {
+	LSN     uint64
+	Data    map[string][]byte
+	Expiry  map[string]time.Time
+	Aliases map[string]string
+}
+
+// SnapshotGob writes every live key, its value and expiry, and every alias
+// to w with encoding/gob, for fast Go-to-Go copies where a portable format
+// does not matter; LoadGob reads it back. Keys set with SetWithIdleTTL are
+// saved with the expiry their last access gives them. The snapshot is not
+// durable storage and does not replace the log or Checkpoint: it is only
+// as safe as wherever w puts it, and only Go programs can read it. The
+// read lock is held while encoding, so a slow w holds up writers.
+func (k *KV) SnapshotGob(w io.Writer) error {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.closed {
+		return ErrClosed
+	}
+	snap := gobSnapshot{
+		LSN:     k.lsn,
+		Data:    make(map[string][]byte, len(k.data)),
+		Aliases: k.aliases,
+	}
+	for key, val := range k.data {
+		if k.expired(key) {
+			continue
+		}
+		snap.Data[key] = val
+		if at, ok := k.expiryOf(key); ok {
+			if snap.Expiry == nil {
+				snap.Expiry = make(map[string]time.Time)
+			}
+			snap.Expiry[key] = at
+		}
+	}
+	return gob.NewEncoder(w).Encode(&snap)
+}
+
+// LoadGob creates a database at path, which must not exist yet, holding
+// the snapshot read from r, which was written by SnapshotGob. The data is
+// loaded straight into memory and written out as a compacted log, so the
+// result is as durable as any other KV and nothing is replayed; LSNs carry
+// on from the snapshot's. opts are as for NewKV.
+func LoadGob(path string, r io.Reader, opts ...Option) (*KV, error) {
+	var snap gobSnapshot
+	if err := gob.NewDecoder(r).Decode(&snap); err != nil {
+		return nil, err
+	}
+	o, err := resolveOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	s := o.storage
+	if s == nil {
+		s = newFileStorage(false)
+	}
+	k, err := loadKV(path, s, createNew, o)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	// k is not shared yet, so it is filled in without the lock. Each key
+	// and alias counts as one write ending at the snapshot's LSN, as if the
+	// compacted log written below were replayed.
+	if n := uint64(len(snap.Data) + len(snap.Aliases)); snap.LSN > n {
+		k.lsn = snap.LSN - n
+	}
+	for key, val := range snap.Data {
+		k.lsn++
+		k.put(key, val)
+	}
+	for key, at := range snap.Expiry {
+		k.setExpiry(key, at)
+	}
+	for alias, target := range snap.Aliases {
+		k.lsn++
+		k.setAlias(alias, target)
+	}
+	if err := k.Compact(); err != nil {
+		k.Close()
+		return nil, err
+	}
+	k.openInfo.KeysLoaded = len(k.data)
+	k.startWorkers()
+	return k, nil
+}
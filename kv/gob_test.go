@@ -0,0 +1,88 @@
+package kv
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshotGobRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	k, err := Create(filepath.Join(dir, "a.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.Set("a", []byte("1"))
+	k.Set("a", []byte("2"))
+	k.Set("b", []byte("3"))
+	k.SetWithTTL("ttl", []byte("4"), time.Hour)
+	k.SetAlias("alias", "a")
+	var buf bytes.Buffer
+	if err := k.SnapshotGob(&buf); err != nil {
+		t.Fatal(err)
+	}
+	hash, lsn := k.StateHash(), k.LastLSN()
+	k.Close()
+
+	path := filepath.Join(dir, "b.log")
+	loaded, err := LoadGob(path, bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.StateHash() != hash {
+		t.Error("loaded data differs from the snapshot")
+	}
+	if got := loaded.LastLSN(); got != lsn {
+		t.Errorf("LastLSN = %d, want %d", got, lsn)
+	}
+	if v, ok := loaded.Get("alias"); !ok || string(v) != "2" {
+		t.Errorf("Get(alias) = %q, %v", v, ok)
+	}
+	if ttl, ok := loaded.TTL("ttl"); !ok || ttl <= 0 {
+		t.Errorf("TTL(ttl) = %v, %v", ttl, ok)
+	}
+	loaded.Close()
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if reopened.StateHash() != hash {
+		t.Error("reopened data differs from the snapshot")
+	}
+	if _, err := LoadGob(path, bytes.NewReader(buf.Bytes())); err == nil {
+		t.Error("LoadGob replaced an existing log")
+	}
+}
+
+func TestLoadGobTokens(t *testing.T) {
+	dir := t.TempDir()
+	k, err := Create(filepath.Join(dir, "a.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.Set("a", []byte("1"))
+	var buf bytes.Buffer
+	if err := k.SnapshotGob(&buf); err != nil {
+		t.Fatal(err)
+	}
+	k.Close()
+
+	loaded, err := LoadGob(filepath.Join(dir, "b.log"), &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loaded.Close()
+	_, token, ok := loaded.GetWithToken("a")
+	if !ok || token == 0 {
+		t.Fatalf("GetWithToken(a) = token %d, %v; want a non-zero token", token, ok)
+	}
+	if done, err := loaded.SetWithToken("a", []byte("2"), 0); err != nil || done {
+		t.Errorf("SetWithToken with token 0 on an existing key = %v, %v", done, err)
+	}
+	if done, err := loaded.SetWithToken("a", []byte("2"), token); err != nil || !done {
+		t.Errorf("SetWithToken with a fresh token = %v, %v", done, err)
+	}
+}
@@ -0,0 +1,142 @@
+package kv
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flipByte inverts the byte at off in the named file of s.
+func flipByte(t *testing.T, s Storage, name string, off int64) {
+	t.Helper()
+	m, ok := s.(*memStorage)
+	if !ok {
+		t.Fatalf("flipByte needs a memory storage, got %T", s)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[name][off] ^= 0xff
+}
+
+// readAll returns the whole named file of s.
+func readAll(t *testing.T, s Storage, name string) []byte {
+	t.Helper()
+	size, err := s.Size(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, size)
+	if _, err := s.ReadAt(name, buf, 0); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+var errInjected = errors.New("injected fault")
+
+// faultyStorage wraps a Storage, failing Append or Sync while the matching
+// flag is set.
+type faultyStorage struct {
+	Storage
+	failAppend atomic.Bool
+	failSync   atomic.Bool
+}
+
+func newFaultyStorage() *faultyStorage {
+	return &faultyStorage{Storage: NewMemoryStorage()}
+}
+
+func (s *faultyStorage) Append(name string, data []byte) error {
+	if s.failAppend.Load() {
+		return errInjected
+	}
+	return s.Storage.Append(name, data)
+}
+
+func (s *faultyStorage) Sync(name string) error {
+	if s.failSync.Load() {
+		return errInjected
+	}
+	return s.Storage.Sync(name)
+}
+
+// fakeClock is a Clock and Ticker that only moves when advanced.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+type fakeTicker struct {
+	c        chan time.Time
+	every    time.Duration
+	next     time.Time
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock on by d and delivers the ticks that became due.
+// Tick channels are unbuffered, so Advance returns once each tick has been
+// received, which for a worker means the work of its previous tick is done.
+func (c *fakeClock) Advance(d time.Duration) {
+	type tick struct {
+		t  *fakeTicker
+		at time.Time
+	}
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	var due []tick
+	for _, t := range c.tickers {
+		for ; !t.next.After(c.now); t.next = t.next.Add(t.every) {
+			due = append(due, tick{t, t.next})
+		}
+	}
+	c.mu.Unlock()
+	for _, d := range due {
+		select {
+		case d.t.c <- d.at:
+		case <-d.t.stopped:
+		}
+	}
+}
+
+func (c *fakeClock) Tick(d time.Duration) (<-chan time.Time, func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTicker{
+		c:       make(chan time.Time),
+		every:   d,
+		next:    c.now.Add(d),
+		stopped: make(chan struct{}),
+	}
+	c.tickers = append(c.tickers, t)
+	return t.c, func() { t.stopOnce.Do(func() { close(t.stopped) }) }
+}
+
+// waitTickers waits until n tickers have been started on c.
+func (c *fakeClock) waitTickers(t *testing.T, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		c.mu.Lock()
+		started := len(c.tickers)
+		c.mu.Unlock()
+		if started >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d tickers started, want %d", started, n)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package kv
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// idleKey is the sliding expiry of a key set with SetWithIdleTTL. last is
+// updated by Get under the read lock, so it is atomic.
+type idleKey struct {
+	window time.Duration
+	last   atomic.Int64 // unix nanoseconds
+}
+
+func (ik *idleKey) deadline() time.Time {
+	return time.Unix(0, ik.last.Load()).Add(ik.window)
+}
+
+// SetWithIdleTTL sets key to value and expires it once idle has passed
+// without a Get of it; each Get starts the window again, while Peek and the
+// other reads do not. Like SetWithTTL,
+// an expired key is hidden from reads at once and reclaimed by the next
+// Compact or WithExpirySweep sweep, and a later Set or Del clears the
+// expiry.
+//
+// Accesses are kept in memory only, since logging every read would make
+// reads as costly as writes. The log holds an absolute expiry instead: one
+// idle window after the write, brought forward to one window after the
+// last access whenever Compact or Checkpoint rewrites the key. After a
+// restart the key expires at that logged time, however often it was read
+// since, and no longer slides.
+func (k *KV) SetWithIdleTTL(key string, value []byte, idle time.Duration) error {
+	release, err := k.admit()
+	if err != nil {
+		return err
+	}
+	defer release()
+	if err := k.throttle(context.Background(), SetEntrySize(key, value)); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.checkWritable(); err != nil {
+		return err
+	}
+	now := k.opts.clock.Now()
+	if err := k.setExpiring(key, value, now.Add(idle)); err != nil {
+		return err
+	}
+	ik := &idleKey{window: idle}
+	ik.last.Store(now.UnixNano())
+	if k.idle == nil {
+		k.idle = make(map[string]*idleKey)
+	}
+	k.idle[key] = ik
+	return nil
+}
+
+// touch records an access to key for SetWithIdleTTL. Callers must hold
+// k.mu, for reading at least.
+func (k *KV) touch(key string) {
+	if ik, ok := k.idle[key]; ok {
+		ik.last.Store(k.opts.clock.Now().UnixNano())
+	}
+}
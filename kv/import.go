@@ -0,0 +1,183 @@
+package kv
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ImportStream reads entries framed as in a log file (a copy of a log,
+// with or without its header, works) from r and writes them to k, batchSize
+// entries at a time with one fsync per batch, calling progress, if not
+// nil, with the number of entries imported so far after each batch.
+// Batches in the stream are never split, so a batch may run over
+// batchSize; entries already imported stay if a later batch fails. A torn
+// or corrupt stream is an error wrapping the corruption kind. Keys a
+// WithDedupValues compaction wrote by reference to a shared value are
+// imported as ordinary sets of that value.
+func (k *KV) ImportStream(r io.Reader, batchSize int, progress func(done int)) error {
+	br := bufio.NewReader(r)
+	lf := logFormat{version: logVersion1, checksum: ChecksumIEEE}
+	if hdr, err := br.Peek(headerSize); err == nil && bytes.HasPrefix(hdr, []byte(logMagic)) {
+		var err error
+		if lf, err = decodeHeader(hdr); err != nil {
+			return fmt.Errorf("%w: unsupported stream header", ErrMalformedEntry)
+		}
+		_, _ = br.Discard(headerSize)
+	}
+
+	batchSize = max(batchSize, 1)
+	var pending [][]byte
+	done := 0
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		if err := k.throttle(context.Background(), groupSize(pending)); err != nil {
+			return err
+		}
+		if err := k.importEntries(pending); err != nil {
+			return err
+		}
+		done += len(pending)
+		pending = pending[:0]
+		if progress != nil {
+			progress(done)
+		}
+		return nil
+	}
+
+	var off int64 = lf.headerLen()
+	inBatch := false
+	// shared values of a deduplicated stream, for the refs that follow
+	blobs := make(map[[sha256.Size]byte][]byte)
+	for {
+		payload, n, err := readFrame(br, lf)
+		if err == io.EOF {
+			if inBatch {
+				return &CorruptionError{Offset: off, Err: ErrUnterminatedBatch}
+			}
+			return flush()
+		}
+		if err != nil {
+			return &CorruptionError{Offset: off, Err: err}
+		}
+		off += n
+		if len(payload) == 0 {
+			continue
+		}
+		switch EntryType(payload[0]) {
+		case OpPad:
+			continue
+		case OpBatchBegin:
+			inBatch = true
+			continue
+		case OpBatchCommit:
+			inBatch = false
+		case OpBlob:
+			sum, val, err := decodeBlob(payload)
+			if err != nil {
+				return &CorruptionError{Offset: off - n, Err: err}
+			}
+			blobs[sum] = val
+			continue
+		case OpRef:
+			key, sum, err := decodeRef(payload)
+			if err != nil {
+				return &CorruptionError{Offset: off - n, Err: err}
+			}
+			val, ok := blobs[sum]
+			if !ok {
+				return &CorruptionError{Offset: off - n, Err: fmt.Errorf("%w: ref to unknown blob", ErrMalformedEntry)}
+			}
+			// refs only resolve within the file holding their blob, so
+			// the live log gets the value itself
+			pending = append(pending, buildSetPayloads(key, val, k.opts.chunkSize)...)
+		default:
+			if err := validatePayload(payload); err != nil {
+				return &CorruptionError{Offset: off - n, Err: err}
+			}
+			pending = append(pending, payload)
+		}
+		if !inBatch && len(pending) >= batchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// readFrame reads one framed entry and returns its payload and the number
+// of bytes consumed. It returns io.EOF only at a clean end of stream.
+func readFrame(br *bufio.Reader, lf logFormat) ([]byte, int64, error) {
+	var hdr [8]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, 0, ErrTruncatedEntry
+		}
+		return nil, 0, err
+	}
+	payload := make([]byte, lf.order().Uint32(hdr[0:4]))
+	if _, err := io.ReadFull(br, payload); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, 0, ErrTruncatedEntry
+		}
+		return nil, 0, err
+	}
+	if lf.checksum.Sum(payload) != lf.order().Uint32(hdr[4:8]) {
+		return nil, 0, ErrChecksumMismatch
+	}
+	return transcodePayload(payload, lf.order(), binary.BigEndian), 8 + int64(len(payload)), nil
+}
+
+// validatePayload checks that payload is an entry apply understands, so
+// nothing that would fail replay is written to the log.
+func validatePayload(payload []byte) error {
+	var err error
+	switch EntryType(payload[0]) {
+	case OpSet:
+		_, _, err = decodeSet(payload)
+	case OpChunk:
+		_, _, _, err = decodeChunk(payload)
+	case OpDel:
+		_, err = decodeDel(payload)
+	case OpAlias:
+		_, _, err = decodeAlias(payload)
+	case OpExpire:
+		_, _, err = decodeExpire(payload)
+	case OpBlob:
+		_, _, err = decodeBlob(payload)
+	case OpRef:
+		_, _, err = decodeRef(payload)
+	default:
+		if EntryType(payload[0]) >= OpCustom {
+			_, err = lookupEntryHandler(EntryType(payload[0]))
+			break
+		}
+		err = fmt.Errorf("%w %d", ErrUnknownEntryType, payload[0])
+	}
+	return err
+}
+
+// importEntries writes payloads to the log as one batch and applies them.
+func (k *KV) importEntries(payloads [][]byte) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.checkWritable(); err != nil {
+		return err
+	}
+	if err := k.writeEntries(payloads...); err != nil {
+		return err
+	}
+	for _, p := range payloads {
+		if err := k.apply(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
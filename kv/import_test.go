@@ -0,0 +1,163 @@
+package kv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestImportStreamRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	src, err := Create(filepath.Join(dir, "src.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src.Set("a", []byte("1"))
+	src.Set("b", []byte("2"))
+	src.Del("a")
+	var b Batch
+	b.Set("c", []byte("3"))
+	b.Set("d", []byte("4"))
+	src.WriteBatch(&b)
+	src.Close()
+	raw, err := os.ReadFile(filepath.Join(dir, "src.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst, err := Create(filepath.Join(dir, "dst.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	var calls []int
+	if err := dst.ImportStream(bytes.NewReader(raw), 2, func(done int) { calls = append(calls, done) }); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := dst.Get("a"); ok {
+		t.Error("deleted key a was imported")
+	}
+	for key, want := range map[string]string{"b": "2", "c": "3", "d": "4"} {
+		if v, ok := dst.Get(key); !ok || string(v) != want {
+			t.Errorf("Get(%q) = %q, %v, want %q", key, v, ok, want)
+		}
+	}
+	if len(calls) == 0 || calls[len(calls)-1] != 5 {
+		t.Errorf("progress calls = %v, want a final 5", calls)
+	}
+}
+
+func TestImportStreamDedupLog(t *testing.T) {
+	dir := t.TempDir()
+	src, err := Create(filepath.Join(dir, "src.log"), WithDedupValues())
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared := bytes.Repeat([]byte("v"), 256)
+	for _, key := range []string{"a", "b", "c"} {
+		src.Set(key, shared)
+	}
+	src.Set("u", []byte("unique"))
+	if err := src.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	src.Close()
+	raw, err := os.ReadFile(filepath.Join(dir, "src.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "dst.log")
+	dst, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a batch size of 1 puts the blob and its refs in different batches
+	if err := dst.ImportStream(bytes.NewReader(raw), 1, nil); err != nil {
+		t.Fatal(err)
+	}
+	check := func(k *KV) {
+		t.Helper()
+		for _, key := range []string{"a", "b", "c"} {
+			if v, ok := k.Get(key); !ok || !bytes.Equal(v, shared) {
+				t.Errorf("Get(%q) = %q, %v", key, v, ok)
+			}
+		}
+		if v, ok := k.Get("u"); !ok || string(v) != "unique" {
+			t.Errorf("Get(u) = %q, %v", v, ok)
+		}
+	}
+	check(dst)
+	dst.Close()
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	check(reopened)
+}
+
+func TestImportStreamCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	src, err := Create(filepath.Join(dir, "src.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src.Set("a", []byte("1"))
+	src.Close()
+	raw, err := os.ReadFile(filepath.Join(dir, "src.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst, err := Create(filepath.Join(dir, "dst.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	bad := append([]byte(nil), raw...)
+	bad[len(bad)-1] ^= 0xff
+	if err := dst.ImportStream(bytes.NewReader(bad), 10, nil); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("corrupt stream: err = %v, want ErrChecksumMismatch", err)
+	}
+	if err := dst.ImportStream(bytes.NewReader(raw[:len(raw)-2]), 10, nil); !errors.Is(err, ErrTruncatedEntry) {
+		t.Errorf("torn stream: err = %v, want ErrTruncatedEntry", err)
+	}
+	if keys := dst.Keys(); len(keys) != 0 {
+		t.Errorf("Keys = %q after failed imports, want none", keys)
+	}
+}
+
+func TestImportStreamBatches(t *testing.T) {
+	// a generated stream of 100 sets, headerless
+	var stream []byte
+	lf := newLogFormat(defaultOptions())
+	for i := 0; i < 100; i++ {
+		stream = appendLogEntry(stream, lf, buildSetPayload([]byte(fmt.Sprintf("k%03d", i)), []byte("v")))
+	}
+
+	s := &flakySyncStorage{Storage: NewMemoryStorage()}
+	k, err := Create("a.log", WithStorage(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	s.calls = 0
+	var calls []int
+	if err := k.ImportStream(bytes.NewReader(stream), 7, func(done int) { calls = append(calls, done) }); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 15 || calls[0] != 7 || calls[14] != 100 {
+		t.Errorf("progress calls = %v, want 7, 14, ... 98, 100", calls)
+	}
+	if s.calls != 15 {
+		t.Errorf("%d syncs for 15 batches", s.calls)
+	}
+	if n := k.Stats().Keys; n != 100 {
+		t.Errorf("imported %d keys, want 100", n)
+	}
+}
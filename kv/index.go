@@ -0,0 +1,238 @@
+package kv
+
+import (
+	"sort"
+	"strings"
+)
+
+// indexDegree is the B-tree degree of orderedIndex: nodes hold between
+// indexDegree-1 and 2*indexDegree-1 keys, the root excepted.
+const indexDegree = 32
+
+// orderedIndex keeps keys in sorted order next to the map so scans can walk
+// a range without sorting. It is a B-tree, so adding or removing a key
+// costs O(log n) whether it comes from a write or from replay, and
+// overwrites of existing keys cost nothing. Keys are ordered by cmp, see
+// WithKeyComparator; keys cmp reports as equal are kept apart in byte
+// order.
+type orderedIndex struct {
+	root *indexNode
+	cmp  func(a, b string) int
+}
+
+type indexNode struct {
+	keys     []string
+	children []*indexNode // empty for a leaf, else len(keys)+1
+}
+
+// compare is cmp made strict, so distinct keys never compare equal.
+func (ix *orderedIndex) compare(a, b string) int {
+	if c := ix.cmp(a, b); c != 0 {
+		return c
+	}
+	return strings.Compare(a, b)
+}
+
+// find returns the position of key in n, or where it would be inserted.
+func (ix *orderedIndex) find(n *indexNode, key string) (int, bool) {
+	i := sort.Search(len(n.keys), func(i int) bool {
+		return ix.compare(n.keys[i], key) >= 0
+	})
+	return i, i < len(n.keys) && n.keys[i] == key
+}
+
+func (ix *orderedIndex) insert(key string) {
+	if ix.root == nil {
+		ix.root = &indexNode{keys: []string{key}}
+		return
+	}
+	if len(ix.root.keys) == 2*indexDegree-1 {
+		mid, right := ix.root.split(indexDegree - 1)
+		ix.root = &indexNode{
+			keys:     []string{mid},
+			children: []*indexNode{ix.root, right},
+		}
+	}
+	ix.insertInto(ix.root, key)
+}
+
+// insertInto adds key below n, which is not full.
+func (ix *orderedIndex) insertInto(n *indexNode, key string) {
+	for {
+		i, found := ix.find(n, key)
+		if found {
+			return
+		}
+		if len(n.children) == 0 {
+			n.keys = insertAt(n.keys, i, key)
+			return
+		}
+		if len(n.children[i].keys) == 2*indexDegree-1 {
+			mid, right := n.children[i].split(indexDegree - 1)
+			n.keys = insertAt(n.keys, i, mid)
+			n.children = insertAt(n.children, i+1, right)
+			switch c := ix.compare(key, mid); {
+			case c == 0:
+				return
+			case c > 0:
+				i++
+			}
+		}
+		n = n.children[i]
+	}
+}
+
+// split cuts n at key i, keeping the keys before it, and returns that key
+// and a new node with the keys after it.
+func (n *indexNode) split(i int) (string, *indexNode) {
+	mid := n.keys[i]
+	right := &indexNode{keys: append([]string(nil), n.keys[i+1:]...)}
+	n.keys = n.keys[:i:i]
+	if len(n.children) > 0 {
+		right.children = append([]*indexNode(nil), n.children[i+1:]...)
+		n.children = n.children[: i+1 : i+1]
+	}
+	return mid, right
+}
+
+func (ix *orderedIndex) remove(key string) {
+	if ix.root == nil {
+		return
+	}
+	ix.removeFrom(ix.root, key, false)
+	if len(ix.root.keys) == 0 {
+		if len(ix.root.children) > 0 {
+			ix.root = ix.root.children[0]
+		} else {
+			ix.root = nil
+		}
+	}
+}
+
+// removeFrom removes key, or with max the largest key, from below n and
+// returns the key removed. Every node it descends into is first given more
+// than the minimum number of keys, so removing one never underfills it.
+func (ix *orderedIndex) removeFrom(n *indexNode, key string, max bool) (string, bool) {
+	var i int
+	var found bool
+	if max {
+		i = len(n.keys)
+		if len(n.children) == 0 {
+			last := n.keys[i-1]
+			n.keys = n.keys[:i-1]
+			return last, true
+		}
+	} else {
+		i, found = ix.find(n, key)
+		if len(n.children) == 0 {
+			if !found {
+				return "", false
+			}
+			n.keys = removeAt(n.keys, i)
+			return key, true
+		}
+	}
+	if len(n.children[i].keys) < indexDegree {
+		ix.grow(n, i)
+		return ix.removeFrom(n, key, max)
+	}
+	if found {
+		// replace key with its predecessor, the largest key to its left
+		n.keys[i], _ = ix.removeFrom(n.children[i], "", true)
+		return key, true
+	}
+	return ix.removeFrom(n.children[i], key, max)
+}
+
+// grow gives child i of n at least indexDegree keys, by taking one from a
+// sibling through n or by merging it with a sibling.
+func (ix *orderedIndex) grow(n *indexNode, i int) {
+	child := n.children[i]
+	switch {
+	case i > 0 && len(n.children[i-1].keys) >= indexDegree:
+		left := n.children[i-1]
+		child.keys = insertAt(child.keys, 0, n.keys[i-1])
+		n.keys[i-1] = left.keys[len(left.keys)-1]
+		left.keys = left.keys[:len(left.keys)-1]
+		if len(left.children) > 0 {
+			child.children = insertAt(child.children, 0, left.children[len(left.children)-1])
+			left.children = left.children[:len(left.children)-1]
+		}
+	case i < len(n.keys) && len(n.children[i+1].keys) >= indexDegree:
+		right := n.children[i+1]
+		child.keys = append(child.keys, n.keys[i])
+		n.keys[i] = right.keys[0]
+		right.keys = removeAt(right.keys, 0)
+		if len(right.children) > 0 {
+			child.children = append(child.children, right.children[0])
+			right.children = removeAt(right.children, 0)
+		}
+	default:
+		if i == len(n.keys) {
+			i--
+		}
+		left, right := n.children[i], n.children[i+1]
+		left.keys = append(append(left.keys, n.keys[i]), right.keys...)
+		left.children = append(left.children, right.children...)
+		n.keys = removeAt(n.keys, i)
+		n.children = removeAt(n.children, i+1)
+	}
+}
+
+func insertAt[T any](s []T, i int, v T) []T {
+	var zero T
+	s = append(s, zero)
+	copy(s[i+1:], s[i:])
+	s[i] = v
+	return s
+}
+
+func removeAt[T any](s []T, i int) []T {
+	copy(s[i:], s[i+1:])
+	var zero T
+	s[len(s)-1] = zero
+	return s[:len(s)-1]
+}
+
+// rangeKeys returns the keys in [start, end); an empty start or end means
+// no bound.
+func (ix *orderedIndex) rangeKeys(start, end string) []string {
+	var keys []string
+	ix.ascend(start, end, func(key string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+// ascend calls fn for the keys in [start, end) in order until fn returns
+// false. fn must not modify the index.
+func (ix *orderedIndex) ascend(start, end string, fn func(key string) bool) {
+	if ix.root != nil {
+		ix.ascendNode(ix.root, start, end, fn)
+	}
+}
+
+func (ix *orderedIndex) ascendNode(n *indexNode, start, end string, fn func(key string) bool) bool {
+	i := 0
+	if start != "" {
+		i = sort.Search(len(n.keys), func(i int) bool {
+			return ix.cmp(n.keys[i], start) >= 0
+		})
+	}
+	for ; i < len(n.keys); i++ {
+		if len(n.children) > 0 && !ix.ascendNode(n.children[i], start, end, fn) {
+			return false
+		}
+		if end != "" && ix.cmp(n.keys[i], end) >= 0 {
+			return false
+		}
+		if !fn(n.keys[i]) {
+			return false
+		}
+	}
+	if len(n.children) > 0 {
+		return ix.ascendNode(n.children[len(n.keys)], start, end, fn)
+	}
+	return true
+}
@@ -0,0 +1,100 @@
+package kv
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestOrderedIndexTree(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	ix := &orderedIndex{cmp: strings.Compare}
+	want := make(map[string]bool)
+	check := func() {
+		t.Helper()
+		var keys []string
+		for key := range want {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		if got := ix.rangeKeys("", ""); fmt.Sprint(got) != fmt.Sprint(keys) {
+			t.Fatalf("index holds %d keys, want %d", len(got), len(keys))
+		}
+		for i := 0; i < 20; i++ {
+			start, end := fmt.Sprintf("%04d", rng.Intn(3000)), fmt.Sprintf("%04d", rng.Intn(3000))
+			var inRange []string
+			for _, key := range keys {
+				if key >= start && key < end {
+					inRange = append(inRange, key)
+				}
+			}
+			if got := ix.rangeKeys(start, end); fmt.Sprint(got) != fmt.Sprint(inRange) {
+				t.Fatalf("rangeKeys(%s, %s) = %v, want %v", start, end, got, inRange)
+			}
+		}
+	}
+
+	// grow well past a few levels of nodes, then shrink back to nothing,
+	// splitting, borrowing and merging on the way
+	for i := 0; i < 20000; i++ {
+		key := fmt.Sprintf("%04d", rng.Intn(3000))
+		if rng.Intn(3) > 0 {
+			ix.insert(key)
+			want[key] = true
+		} else {
+			ix.remove(key)
+			delete(want, key)
+		}
+		if i%2000 == 0 {
+			check()
+		}
+	}
+	check()
+	for _, i := range rng.Perm(3000) {
+		key := fmt.Sprintf("%04d", i)
+		ix.remove(key)
+		delete(want, key)
+		if i%300 == 0 {
+			check()
+		}
+	}
+	check()
+	if ix.root != nil {
+		t.Error("an emptied index kept a root node")
+	}
+
+	// ascend stops when fn returns false
+	for i := 0; i < 500; i++ {
+		ix.insert(fmt.Sprintf("%04d", i))
+	}
+	var seen []string
+	ix.ascend("0100", "", func(key string) bool {
+		seen = append(seen, key)
+		return len(seen) < 3
+	})
+	if fmt.Sprint(seen) != "[0100 0101 0102]" {
+		t.Errorf("ascend visited %v", seen)
+	}
+}
+
+func BenchmarkOpenOrderedIndex(b *testing.B) {
+	s := NewMemoryStorage()
+	k, err := Create("a.log", WithStorage(s), WithNoSync())
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < 100000; i++ {
+		k.Set(fmt.Sprintf("key:%06d", (i*7919)%100000), nil)
+	}
+	k.Close()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		k, err := Open("a.log", WithStorage(s), WithOrderedIndex())
+		if err != nil {
+			b.Fatal(err)
+		}
+		k.Close()
+	}
+}
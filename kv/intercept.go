@@ -0,0 +1,31 @@
+package kv
+
+// WriteInterceptor is called by WithWriteInterceptor before each set or
+// delete is logged. See there.
+type WriteInterceptor func(op EntryType, key string, value []byte) ([]byte, error)
+
+// WithWriteInterceptor calls fn before each set (OpSet), delete (OpDel) or
+// SetAlias (OpAlias, with the target as value) is written, including those
+// in batches and transactions; only the counter writes of NextID bypass it.
+// A non-nil error vetoes the write, failing it with that error; for a
+// batch, the whole batch. For a set, the returned value is written in
+// place of value, so fn can validate, sign or rewrite values; for a delete
+// or an alias it is ignored. fn runs with the write lock
+// held but before anything is appended or synced, so it sees a consistent
+// state without lengthening the fsync. It must not call k, and must not
+// modify value in place. It sees values exactly as stored, including the
+// encodings written by ListPush and SetEncoded.
+func WithWriteInterceptor(fn WriteInterceptor) Option {
+	return func(o *options) {
+		o.interceptor = fn
+	}
+}
+
+// intercept runs the write interceptor, if any, on a write of key.
+// Callers must hold k.mu for writing.
+func (k *KV) intercept(op EntryType, key string, value []byte) ([]byte, error) {
+	if k.opts.interceptor == nil {
+		return value, nil
+	}
+	return k.opts.interceptor(op, key, value)
+}
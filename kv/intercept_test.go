@@ -0,0 +1,88 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestWriteInterceptor(t *testing.T) {
+	errReadOnly := errors.New("read-only key")
+	var seen []string
+	intercept := func(op EntryType, key string, value []byte) ([]byte, error) {
+		seen = append(seen, fmt.Sprintf("%d:%s", op, key))
+		switch {
+		case strings.HasPrefix(key, "ro:"):
+			return nil, errReadOnly
+		case strings.HasPrefix(key, "signed:") && op == OpSet:
+			return append(append([]byte(nil), value...), "|sig"...), nil
+		}
+		return value, nil
+	}
+	s := NewMemoryStorage()
+	k, err := Create("a.log", WithStorage(s), WithWriteInterceptor(intercept))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// pass-through
+	if err := k.Set("plain", []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := k.Get("plain"); string(v) != "v" {
+		t.Errorf("Get(plain) = %q, want v", v)
+	}
+	if err := k.Del("plain"); err != nil {
+		t.Fatal(err)
+	}
+
+	// veto
+	size, _ := s.Size("a.log")
+	if err := k.Set("ro:a", []byte("v")); !errors.Is(err, errReadOnly) {
+		t.Errorf("Set(ro:a) = %v, want the veto", err)
+	}
+	var b Batch
+	b.Set("x", []byte("1"))
+	b.Set("ro:b", []byte("2"))
+	if err := k.WriteBatch(&b); !errors.Is(err, errReadOnly) {
+		t.Errorf("WriteBatch = %v, want the veto", err)
+	}
+	if _, ok := k.Get("x"); ok {
+		t.Error("a vetoed batch was partly applied")
+	}
+	if got, _ := s.Size("a.log"); got != size {
+		t.Errorf("vetoed writes grew the log from %d to %d bytes", size, got)
+	}
+
+	// transform
+	if err := k.Set("signed:a", []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := k.Get("signed:a"); string(v) != "v|sig" {
+		t.Errorf("Get(signed:a) = %q, want the transformed value", v)
+	}
+	want := fmt.Sprint([]string{
+		fmt.Sprintf("%d:plain", OpSet), fmt.Sprintf("%d:plain", OpDel),
+		fmt.Sprintf("%d:ro:a", OpSet), fmt.Sprintf("%d:x", OpSet), fmt.Sprintf("%d:ro:b", OpSet),
+		fmt.Sprintf("%d:signed:a", OpSet),
+	})
+	if got := fmt.Sprint(seen); got != want {
+		t.Errorf("interceptor saw %s, want %s", got, want)
+	}
+	k.Close()
+
+	// the transformed value is what was logged; replay does not intercept
+	seen = nil
+	k, err = Open("a.log", WithStorage(s), WithWriteInterceptor(intercept))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	if v, _ := k.Get("signed:a"); string(v) != "v|sig" {
+		t.Errorf("after reopening Get(signed:a) = %q, want v|sig", v)
+	}
+	if len(seen) != 0 {
+		t.Errorf("replay called the interceptor for %v", seen)
+	}
+}
@@ -1,202 +1,1310 @@
 package kv
 
 import (
-	"encoding/binary"
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"errors"
 	"fmt"
-	"os"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"maps"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // KV is the in-memory map backed by an append-only log file.
 type KV struct {
-	data   map[string][]byte
-	log    *os.File
-	logPath string
+	mu   sync.RWMutex
+	data map[string][]byte
+	meta map[string]keyMeta
+	// writeOrder lists live keys from least to most recently written
+	writeOrder *list.List
+	// lsn is the LSN of the latest write applied, see LastLSN
+	lsn      uint64
+	store    Storage
+	logPath  string
+	opts     options
+	format   logFormat
+	openInfo OpenInfo
+	closed   bool
+	streams  map[*changeStream]struct{}
+	index    *orderedIndex // nil unless WithOrderedIndex
+	standby  *standby      // non-nil while following a primary
+	expiry   map[string]time.Time
+	// idle holds the sliding expiry of keys set with SetWithIdleTTL, which
+	// also have an entry in expiry
+	idle map[string]*idleKey
+
+	// compactMu is held for the duration of a compaction; compacting is
+	// set while a throttled Compact writes without holding mu
+	compactMu  sync.Mutex
+	compacting bool
+	// closeCtx is cancelled by Close to cut a throttled compaction short
+	closeCtx    context.Context
+	cancelClose context.CancelFunc
+	// limiter enforces WithWriteRateLimit; nil when unlimited
+	limiter *rateLimiter
+	// workers are the background goroutines started by options such as
+	// WithScheduledCompaction
+	workers []*worker
+	// seqs caches NextID state by counter key
+	seqs map[string]*seqState
+	// wasted counts superseded entries seen by apply, see OpenInfo
+	wasted int
+	// tombstones counts delete entries in the log since open or the last
+	// compaction; tombstoneKick wakes the WithTombstoneCompaction worker
+	tombstones    int
+	tombstoneKick chan struct{}
+	// undo holds recently deleted values, oldest first, see WithDeleteUndo
+	undo []deletedValue
+	// unsynced counts writes appended since the last fsync, see WithSyncEvery
+	unsynced int
+	// durableLSN is the LSN of the latest synced write; durableCh is closed
+	// and replaced whenever it advances, see WaitDurable
+	durableLSN uint64
+	durableCh  chan struct{}
+	// syncedAt is when the log was last fsynced, see DurabilityLag
+	syncedAt time.Time
+	// reverse indexes keys by value when WithReverseIndex is set
+	reverse reverseIndex
+	// aliases maps alias keys to their targets, see SetAlias
+	aliases map[string]string
+	// blobs holds the values of blob entries while a file is replayed,
+	// see WithDedupValues
+	blobs map[[sha256.Size]byte][]byte
+	// inflight counts writes admitted but not finished, see
+	// WithMaxInflightWrites
+	inflight atomic.Int64
+	// paused is set between Pause and Resume; resumed, on k.mu, wakes the
+	// writes waiting for Resume
+	paused  bool
+	resumed *sync.Cond
+	// loads holds the WithLoader calls in progress by key
+	loadMu sync.Mutex
+	loads  map[string]*loadCall
+	// verifying marks VerifyOnline's scratch replay, which checks custom
+	// entries without running their handlers
+	verifying bool
+	// keyCount mirrors len(data) for lock-free reads by ApproxLen
+	keyCount atomic.Int64
+
+	// running totals behind Stats
+	keyBytes   int64
+	valueBytes int64
+}
+
+// keyMeta is what the KV tracks about each live key besides its value.
+type keyMeta struct {
+	crc   uint32        // checksum of the value, see GetWithChecksum
+	order *list.Element // position in KV.writeOrder
+	lsn   uint64        // LSN of the write that set the value
+}
+
+// OpenInfo summarizes what NewKV loaded from the log.
+type OpenInfo struct {
+	// EntriesReplayed counts set and delete entries applied during replay.
+	// Entries covered by a checkpoint are not included.
+	EntriesReplayed int
+	// KeysLoaded is the number of live keys after replay.
+	KeysLoaded int
+	// BytesRead is the length of the valid part of the log, header included.
+	BytesRead int64
+	// TruncatedTail reports whether a torn or uncommitted tail was cut off;
+	// TruncatedBytes is how much was discarded.
+	TruncatedTail  bool
+	TruncatedBytes int64
+	// TailError is a *CorruptionError describing why replay stopped before
+	// the end of the file, or nil.
+	TailError error
+	// ReplayDuration is the time spent reading and applying the log.
+	ReplayDuration time.Duration
+	// CheckpointOffset is the log offset replay started from when a
+	// checkpoint was loaded, or zero.
+	CheckpointOffset int64
+	// WastedEntries counts replayed entries made obsolete by later ones:
+	// each value that was overwritten or deleted (a chunked value counts
+	// once) and each delete. Compact would drop them; compare it with
+	// EntriesReplayed to judge fragmentation.
+	WastedEntries int
+	// MirrorRecoveredBytes is how much of a damaged log tail was restored
+	// from the WithMirror copy.
+	MirrorRecoveredBytes int64
+	// CompactedOnOpen reports whether the log exceeded the
+	// WithCompactOnOpenIf threshold and was compacted after replay.
+	CompactedOnOpen bool
 }
 
 // NewKV opens or creates the log file, replays it into memory and seeks to end for appends.
-func NewKV(logPath string) (*KV, error) {
-	f, err := os.OpenFile(logPath, os.O_RDWR|os.O_CREATE, 0o664)
+func NewKV(logPath string, opts ...Option) (*KV, error) {
+	return openKV(logPath, openOrCreate, opts)
+}
+
+// Open is NewKV for a log that must already exist: a missing file is an
+// error matching ErrNotExist rather than a new, empty database.
+func Open(logPath string, opts ...Option) (*KV, error) {
+	return openKV(logPath, openExisting, opts)
+}
+
+// Create is NewKV for a log that must not exist yet: an existing file is an
+// error matching ErrExist and is left untouched.
+func Create(logPath string, opts ...Option) (*KV, error) {
+	return openKV(logPath, createNew, opts)
+}
+
+// openMode says whether openKV may create the log, or must.
+type openMode int
+
+const (
+	openOrCreate openMode = iota
+	openExisting
+	createNew
+)
+
+func openKV(logPath string, mode openMode, opts []Option) (*KV, error) {
+	o, err := resolveOptions(opts)
 	if err != nil {
 		return nil, err
 	}
-	k := &KV{
-		data: make(map[string][]byte),
-		log:  f,
-		logPath: logPath,
+	s := o.storage
+	if s == nil {
+		s = newFileStorage(false)
 	}
-	entries, err := readLog(f)
+	k, err := loadKV(logPath, s, mode, o)
 	if err != nil {
-		f.Close()
+		s.Close()
 		return nil, err
 	}
-
-	// replay entries
-	for _, payload := range entries {
-		if len(payload) == 0 {
-			continue
+	if limit := o.compactOnOpen; limit > 0 && k.openInfo.BytesRead > limit && o.replayFilter == nil {
+		if err := k.Compact(); err != nil {
+			k.Close()
+			return nil, err
 		}
-		switch EntryType(payload[0]) {
-		case OpSet:
-			off := 1
-			if off+4 > len(payload) {
-				return nil, fmt.Errorf("malformed set entry")
-			}
-			klen := int(binary.BigEndian.Uint32(payload[off : off+4])); off += 4
-			if off+klen > len(payload) {
-				return nil, fmt.Errorf("malformed set entry key")
-			}
-			key := string(payload[off : off+klen]); off += klen
+		k.openInfo.CompactedOnOpen = true
+	}
+	k.startWorkers()
+	return k, nil
+}
 
-			if off+4 > len(payload) {
-				return nil, fmt.Errorf("malformed set entry value length")
-			}
-			vlen := int(binary.BigEndian.Uint32(payload[off : off+4])); off += 4
-			if off+vlen > len(payload) {
-				return nil, fmt.Errorf("malformed set entry value")
+// loadKV replays the log at logPath in s into a new KV, creating the log
+// if mode allows and it does not exist.
+func loadKV(logPath string, s Storage, mode openMode, o options) (*KV, error) {
+	_, err := s.Size(logPath)
+	exists := err == nil
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if mode == openExisting && !exists {
+		return nil, &fs.PathError{Op: "open", Path: logPath, Err: fs.ErrNotExist}
+	}
+	if mode == createNew && exists {
+		return nil, &fs.PathError{Op: "open", Path: logPath, Err: fs.ErrExist}
+	}
+	k := newKV(logPath, s, o)
+	var lf logFormat
+	ok := false
+	if exists {
+		if lf, ok, err = readHeader(storageReader{s, logPath}); err != nil {
+			return nil, err
+		}
+	}
+	if !ok {
+		// new (or torn while being created) file: start it with a header
+		lf = newLogFormat(o)
+		if err := removeCheckpoint(s, logPath); err != nil {
+			return nil, err
+		}
+		if exists {
+			if err := s.Truncate(logPath, 0); err != nil {
+				return nil, err
 			}
-			val := make([]byte, vlen)
-			copy(val, payload[off:off+vlen])
-			k.data[key] = val
-
-		case OpDel:
-			off := 1
-			if off+4 > len(payload) {
-				return nil, fmt.Errorf("malformed del entry")
+		}
+		if err := s.Append(logPath, encodeHeader(lf)); err != nil {
+			return nil, err
+		}
+		if err := s.Sync(logPath); err != nil {
+			return nil, err
+		}
+	}
+	k.format = lf
+	k.lsn = lf.baseLSN
+	started := o.clock.Now()
+	size, err := s.Size(logPath)
+	if err != nil {
+		return nil, err
+	}
+	start := lf.headerLen()
+	if cp, offset, base, ok := loadCheckpoint(s, logPath, lf, size); ok {
+		k.lsn = base
+		for _, e := range cp {
+			if err := k.applyEntry(e); err != nil {
+				return nil, err
 			}
-			klen := int(binary.BigEndian.Uint32(payload[off : off+4])); off += 4
-			if off+klen > len(payload) {
-				return nil, fmt.Errorf("malformed del entry key")
+		}
+		start = offset
+		k.openInfo.CheckpointOffset = offset
+	}
+	replayed, end, tail, err := k.replayFrom(start)
+	if err != nil {
+		return nil, err
+	}
+	if tail != nil && o.mirror != "" {
+		n, err := k.recoverFromMirror(end)
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			more, moreEnd, moreTail, err := k.replayFrom(end)
+			if err != nil {
+				return nil, err
 			}
-			key := string(payload[off : off+klen])
-			delete(k.data, key)
-
-		default:
-			return nil, fmt.Errorf("unknown entry type %d", payload[0])
+			replayed, end, tail = replayed+more, moreEnd, moreTail
+			k.openInfo.MirrorRecoveredBytes = n
 		}
 	}
 
-	// seek to end for subsequent appends
-	if _, err := f.Seek(0, 2); err != nil {
-		f.Close()
+	// drop a torn tail or unterminated batch so new appends follow valid data
+	size, err = s.Size(logPath)
+	if err != nil {
 		return nil, err
 	}
+	if size > end {
+		if err := s.Truncate(logPath, end); err != nil {
+			return nil, err
+		}
+	}
+	// a mirror that is missing, or longer or shorter than the log, no
+	// longer copies it
+	if o.mirror != "" {
+		if msize, err := s.Size(o.mirror); err != nil || msize != end {
+			if err := k.rebuildMirror(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	k.openInfo.EntriesReplayed = replayed
+	k.openInfo.KeysLoaded = len(k.data)
+	k.openInfo.BytesRead = end
+	k.openInfo.TruncatedTail = size > end
+	k.openInfo.TruncatedBytes = size - end
+	k.openInfo.TailError = tail
+	k.openInfo.WastedEntries = k.wasted
+	k.openInfo.ReplayDuration = o.clock.Now().Sub(started)
 	return k, nil
 }
 
+func resolveOptions(opts []Option) (options, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if !o.checksum.valid() {
+		return o, fmt.Errorf("unknown checksum type %d", o.checksum)
+	}
+	if !validByteOrder(o.byteOrder) {
+		return o, fmt.Errorf("unsupported byte order %v", o.byteOrder)
+	}
+	return o, nil
+}
+
+// newKV returns an empty KV over the log at logPath in s.
+func newKV(logPath string, s Storage, o options) *KV {
+	k := &KV{
+		data:       make(map[string][]byte),
+		meta:       make(map[string]keyMeta),
+		writeOrder: list.New(),
+		durableCh:  make(chan struct{}),
+		store:      s,
+		logPath:    logPath,
+		opts:       o,
+	}
+	k.resumed = sync.NewCond(&k.mu)
+	k.closeCtx, k.cancelClose = context.WithCancel(context.Background())
+	if o.orderedIndex {
+		k.index = &orderedIndex{cmp: o.keyCompare()}
+	}
+	if o.reverseIndex {
+		k.reverse = make(reverseIndex)
+	}
+	if o.writeRate > 0 {
+		k.limiter = newRateLimiter(o.writeRate)
+	}
+	return k
+}
+
+// replayFrom applies the log entries from offset start on. It returns how
+// many entries were applied, the offset just past the last valid one and
+// why reading stopped short of the end of the file, if it did.
+func (k *KV) replayFrom(start int64) (replayed int, end int64, tail, err error) {
+	r, err := sectionFrom(k.store, k.logPath, start)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	entries, end, tail, err := readLog(r, start, k.format, k.opts.verifyChecksums)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	// blobs are only referenced from the file that holds them
+	defer func() { k.blobs = nil }()
+	for _, e := range entries {
+		if err := k.applyEntry(e); err != nil {
+			return 0, 0, nil, &CorruptionError{Offset: e.offset, Err: err}
+		}
+	}
+	// what was read back is on disk already
+	k.markDurable()
+	return len(entries), end, tail, nil
+}
+
+// clearMemory drops all in-memory state derived from the log.
+func (k *KV) clearMemory() {
+	k.data = make(map[string][]byte)
+	k.meta = make(map[string]keyMeta)
+	k.writeOrder.Init()
+	if k.index != nil {
+		k.index = &orderedIndex{cmp: k.index.cmp}
+	}
+	if k.reverse != nil {
+		k.reverse = make(reverseIndex)
+	}
+	k.expiry = nil
+	k.idle = nil
+	k.aliases = nil
+	k.seqs = nil
+	k.keyCount.Store(0)
+	k.keyBytes = 0
+	k.valueBytes = 0
+}
+
+// checkWritable reports why k cannot accept writes, if it cannot, first
+// waiting out a Pause with WithBlockWhenPaused. Callers must hold k.mu for
+// writing.
+func (k *KV) checkWritable() error {
+	if err := k.waitResumed(); err != nil {
+		return err
+	}
+	if k.closed {
+		return ErrClosed
+	}
+	if k.standby != nil {
+		return ErrReadOnly
+	}
+	return nil
+}
+
+// OpenInfo returns the replay summary recorded when the database was opened.
+func (k *KV) OpenInfo() OpenInfo {
+	return k.openInfo
+}
+
+// apply replays a single log payload into the in-memory map.
+func (k *KV) apply(payload []byte) error {
+	if len(payload) == 0 {
+		return nil
+	}
+	switch EntryType(payload[0]) {
+	case OpSet:
+		key, val, err := decodeSet(payload)
+		if err != nil {
+			return err
+		}
+		if k.opts.replayFilter != nil && !k.opts.replayFilter(key) {
+			return nil
+		}
+		k.supersede(key)
+		k.put(key, append([]byte(nil), val...))
+	case OpChunk:
+		key, part, first, err := decodeChunk(payload)
+		if err != nil {
+			return err
+		}
+		if k.opts.replayFilter != nil && !k.opts.replayFilter(key) {
+			return nil
+		}
+		if first {
+			k.supersede(key)
+			k.put(key, append([]byte(nil), part...))
+		} else {
+			// extend the checksum rather than rehashing the whole value
+			sum := crc32.Update(k.meta[key].crc, k.format.checksum.table(), part)
+			k.putSum(key, append(k.data[key], part...), sum)
+		}
+	case OpDel:
+		key, err := decodeDel(payload)
+		if err != nil {
+			return err
+		}
+		k.wasted++
+		k.supersede(key)
+		k.remove(key)
+	case OpBlob:
+		sum, val, err := decodeBlob(payload)
+		if err != nil {
+			return err
+		}
+		k.applyBlob(sum, val)
+	case OpRef:
+		key, sum, err := decodeRef(payload)
+		if err != nil {
+			return err
+		}
+		val, ok := k.blobs[sum]
+		if !ok {
+			return fmt.Errorf("%w: ref to unknown blob", ErrMalformedEntry)
+		}
+		if k.opts.replayFilter != nil && !k.opts.replayFilter(key) {
+			return nil
+		}
+		k.supersede(key)
+		// keys sharing a blob share its memory too
+		k.put(key, val)
+	case OpAlias:
+		alias, target, err := decodeAlias(payload)
+		if err != nil {
+			return err
+		}
+		if k.opts.replayFilter != nil && !k.opts.replayFilter(alias) {
+			return nil
+		}
+		k.setAlias(alias, target)
+	case OpExpire:
+		key, at, err := decodeExpire(payload)
+		if err != nil {
+			return err
+		}
+		if _, ok := k.data[key]; !ok {
+			return nil
+		}
+		if k.opts.clock.Now().Before(at) {
+			k.setExpiry(key, at)
+		} else {
+			// already expired: do not load it at all
+			k.supersede(key)
+			k.remove(key)
+		}
+	default:
+		if EntryType(payload[0]) >= OpCustom {
+			return k.applyCustom(payload)
+		}
+		return fmt.Errorf("%w %d", ErrUnknownEntryType, payload[0])
+	}
+	return nil
+}
+
+// supersede counts key's current value or alias, if any, as wasted because
+// a replayed entry is about to replace or remove it.
+func (k *KV) supersede(key string) {
+	_, isAlias := k.aliases[key]
+	if _, ok := k.data[key]; ok || isAlias {
+		k.wasted++
+	}
+}
+
+// put stores val under key, clears any TTL, and keeps the Stats totals in
+// step. The caller hands over ownership of val.
+func (k *KV) put(key string, val []byte) {
+	k.putSum(key, val, k.format.checksum.Sum(val))
+}
+
+// putSum is put with the value's checksum already computed.
+func (k *KV) putSum(key string, val []byte, sum uint32) {
+	delete(k.expiry, key)
+	delete(k.idle, key)
+	delete(k.aliases, key)
+	k.forgetSeq(key)
+	m := keyMeta{crc: sum, order: k.meta[key].order, lsn: k.lsn}
+	if m.order != nil {
+		k.writeOrder.MoveToBack(m.order)
+	} else {
+		m.order = k.writeOrder.PushBack(key)
+	}
+	k.meta[key] = m
+	if old, ok := k.data[key]; ok {
+		k.valueBytes -= int64(len(old))
+		if k.reverse != nil {
+			k.reverse.drop(key, old)
+		}
+	} else {
+		k.keyCount.Add(1)
+		k.keyBytes += int64(len(key))
+		if k.index != nil {
+			k.index.insert(key)
+		}
+	}
+	k.valueBytes += int64(len(val))
+	k.data[key] = val
+	if k.reverse != nil {
+		k.reverse.add(key, val)
+	}
+}
+
+// remove deletes key and keeps the Stats totals in step.
+func (k *KV) remove(key string) {
+	delete(k.aliases, key)
+	old, ok := k.data[key]
+	if !ok {
+		return
+	}
+	k.keyCount.Add(-1)
+	k.keyBytes -= int64(len(key))
+	k.valueBytes -= int64(len(old))
+	delete(k.data, key)
+	if k.reverse != nil {
+		k.reverse.drop(key, old)
+	}
+	k.forgetSeq(key)
+	k.writeOrder.Remove(k.meta[key].order)
+	delete(k.meta, key)
+	delete(k.expiry, key)
+	delete(k.idle, key)
+	if k.index != nil {
+		k.index.remove(key)
+	}
+}
+
+// writeEntries appends payloads to the log as one unit and fsyncs it. If
+// the append or fsync fails the bytes are cut off again so later entries are
+// not appended after a torn one, and a full disk is reported as ErrNoSpace.
+// Callers must hold k.mu for writing.
+func (k *KV) writeEntries(payloads ...[]byte) error {
+	start, err := k.store.Size(k.logPath)
+	if err != nil {
+		return err
+	}
+	buf := k.appendAligned(nil, start, payloads)
+	if err := k.store.Append(k.logPath, buf); err != nil {
+		k.truncateLog(start)
+		return noSpace(err)
+	}
+	if k.opts.mirror != "" {
+		if err := k.store.Append(k.opts.mirror, buf); err != nil {
+			k.truncateLog(start)
+			return noSpace(err)
+		}
+	}
+	// durable write, or once every WithSyncEvery writes, or never with
+	// WithNoSync
+	k.unsynced++
+	k.lsn++
+	if !k.opts.noSync && k.unsynced >= k.opts.syncEvery {
+		if err := k.syncLog(); err != nil {
+			k.unsynced--
+			k.lsn--
+			k.truncateLog(start)
+			return noSpace(err)
+		}
+	}
+	k.countTombstones(payloads...)
+	return nil
+}
+
+// syncLog fsyncs the log, making every write appended so far durable.
+// Callers must hold k.mu for writing.
+func (k *KV) syncLog() error {
+	for _, name := range []string{k.logPath, k.opts.mirror} {
+		if name == "" {
+			continue
+		}
+		sync := func() error { return k.store.Sync(name) }
+		if err := syncWithRetry(sync, k.opts.syncRetries, k.opts.syncBackoff); err != nil {
+			return err
+		}
+	}
+	k.markDurable()
+	return nil
+}
+
+// Sync makes every write so far durable. It is only needed with
+// WithSyncEvery or WithNoSync, since otherwise each write is synced before
+// it returns.
+func (k *KV) Sync() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.closed {
+		return ErrClosed
+	}
+	if k.unsynced == 0 {
+		return nil
+	}
+	return k.syncLog()
+}
+
+// appendAligned appends to dst the frames for payloads written as one unit
+// to a log ending at start. With WithBlockAlignment the unit is preceded, if
+// the log does not already end on a block boundary, and followed by
+// padding, so it starts and ends on boundaries.
+func (k *KV) appendAligned(dst []byte, start int64, payloads [][]byte) []byte {
+	align := k.opts.blockAlign
+	dst = appendPadding(dst, k.format, start, align)
+	dst = appendEntries(dst, k.format, payloads)
+	return appendPadding(dst, k.format, start+int64(len(dst)), align)
+}
+
 // Set writes a set entry and updates in-memory map.
 func (k *KV) Set(key string, value []byte) error {
-	payload := buildSetPayload([]byte(key), value)
-	if err := writeLogEntry(k.log, payload); err != nil {
+	return k.SetContext(context.Background(), key, value)
+}
+
+// SetContext is Set, except that a wait imposed by WithWriteRateLimit is
+// abandoned with ctx's error when ctx is done.
+func (k *KV) SetContext(ctx context.Context, key string, value []byte) error {
+	release, err := k.admit()
+	if err != nil {
 		return err
 	}
-	k.data[key] = append([]byte(nil), value...)
+	defer release()
+	if err := k.throttle(ctx, SetEntrySize(key, value)); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.checkWritable(); err != nil {
+		return err
+	}
+	if k.opts.skipRedundantWrite {
+		if cur, ok := k.data[key]; ok && k.expiry[key].IsZero() && bytes.Equal(cur, value) {
+			return nil
+		}
+	}
+	return k.set(key, value)
+}
+
+// SetIf sets key to value only if cond, called with the current value and
+// whether the key exists, returns true. cond runs under the write lock, so no
+// other write can slip in between the check and the write; it must not call
+// back into the KV, nor modify or keep current. The result reports whether
+// the write happened.
+func (k *KV) SetIf(key string, value []byte, cond func(current []byte, exists bool) bool) (bool, error) {
+	release, err := k.admit()
+	if err != nil {
+		return false, err
+	}
+	defer release()
+	if err := k.throttle(context.Background(), SetEntrySize(key, value)); err != nil {
+		return false, err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.checkWritable(); err != nil {
+		return false, err
+	}
+	exists := k.live(key)
+	var cur []byte
+	if exists {
+		cur = k.data[key]
+	}
+	if !cond(cur, exists) {
+		return false, nil
+	}
+	if err := k.set(key, value); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// set passes a write of key through the write interceptor, then logs and
+// applies it; the caller holds the write lock.
+func (k *KV) set(key string, value []byte) error {
+	value, err := k.intercept(OpSet, key, value)
+	if err != nil {
+		return err
+	}
+	return k.writeSet(key, value)
+}
+
+// writeSet logs and applies a write of key as is; the caller holds the
+// write lock.
+func (k *KV) writeSet(key string, value []byte) error {
+	if err := k.writeEntries(buildSetPayloads(key, value, k.opts.chunkSize)...); err != nil {
+		return err
+	}
+	val := append([]byte(nil), value...)
+	k.put(key, val)
+	k.publish(OpSet, key, val)
 	return nil
 }
 
 // Del writes a delete entry and removes from in-memory map.
 func (k *KV) Del(key string) error {
-	payload := buildDelPayload([]byte(key))
-	if err := writeLogEntry(k.log, payload); err != nil {
+	return k.DelContext(context.Background(), key)
+}
+
+// DelContext is Del, except that a wait imposed by WithWriteRateLimit is
+// abandoned with ctx's error when ctx is done.
+func (k *KV) DelContext(ctx context.Context, key string) error {
+	release, err := k.admit()
+	if err != nil {
 		return err
 	}
-	delete(k.data, key)
+	defer release()
+	if err := k.throttle(ctx, DelEntrySize(key)); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.checkWritable(); err != nil {
+		return err
+	}
+	return k.del(key)
+}
+
+// DeleteIf deletes key only if its current value equals expected, or, with
+// a nil expected, if it exists with any value. The check and the delete
+// happen under the write lock. The result reports whether it deleted.
+func (k *KV) DeleteIf(key string, expected []byte) (bool, error) {
+	release, err := k.admit()
+	if err != nil {
+		return false, err
+	}
+	defer release()
+	if err := k.throttle(context.Background(), DelEntrySize(key)); err != nil {
+		return false, err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.checkWritable(); err != nil {
+		return false, err
+	}
+	if !k.live(key) || (expected != nil && !bytes.Equal(k.data[key], expected)) {
+		return false, nil
+	}
+	if err := k.del(key); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// DeleteFunc deletes every live key for which pred returns true, as one
+// batch with a single fsync, and returns how many it deleted. pred sees each
+// key, in sorted order, with a copy of its value; the write lock is held
+// throughout, so the keys are judged against one consistent state and pred
+// must not use k.
+func (k *KV) DeleteFunc(pred func(key string, value []byte) bool) (int, error) {
+	release, err := k.admit()
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.checkWritable(); err != nil {
+		return 0, err
+	}
+	var b Batch
+	for _, key := range k.rangeKeys("", "") {
+		if pred(key, append([]byte(nil), k.data[key]...)) {
+			b.Del(key)
+		}
+	}
+	if err := k.writeBatch(&b); err != nil {
+		return 0, err
+	}
+	return b.Len(), nil
+}
+
+// del logs and applies a delete of key; the caller holds the write lock.
+func (k *KV) del(key string) error {
+	if _, err := k.intercept(OpDel, key, nil); err != nil {
+		return err
+	}
+	if err := k.writeEntries(buildDelPayload([]byte(key))); err != nil {
+		return err
+	}
+	if k.live(key) {
+		k.keepDeleted(key, k.data[key])
+	}
+	k.remove(key)
+	k.publish(OpDel, key, nil)
 	return nil
 }
 
-// Get returns a copy of the value if present.
+// Get returns a copy of the value if present, calling the WithLoader
+// loader, if any, on a miss. A hit counts as an access of a key set with
+// SetWithIdleTTL, starting its idle window again. After Close it always
+// reports the key as missing.
 func (k *KV) Get(key string) ([]byte, bool) {
-	v, ok := k.data[key]
+	val, ok, closed := k.get(key)
+	if ok || closed || k.opts.loader == nil {
+		return val, ok
+	}
+	return k.load(key)
+}
+
+// Peek is Get without side effects, for monitoring tools that sample keys:
+// it does not count as an access for SetWithIdleTTL, so it never keeps an
+// idle key alive, and it does not call the WithLoader loader.
+func (k *KV) Peek(key string) ([]byte, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.closed {
+		return nil, false
+	}
+	key, ok := k.resolveLive(key)
 	if !ok {
 		return nil, false
 	}
-	val := append([]byte(nil), v...)
-	return val, true
+	return append([]byte(nil), k.data[key]...), true
 }
 
-// Close closes the log file handle.
+// get is Get without WithLoader, also reporting whether k is closed.
+func (k *KV) get(key string) (val []byte, ok, closed bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.closed {
+		return nil, false, true
+	}
+	key, ok = k.resolveLive(key)
+	if !ok {
+		return nil, false, false
+	}
+	k.touch(key)
+	return append([]byte(nil), k.data[key]...), true, false
+}
+
+// GetWithChecksum returns a copy of the value together with the checksum
+// computed when it was written, using the log's polynomial (see
+// KV.Checksum). Callers can recompute it with Checksum.Sum after passing the
+// value along to detect corruption end to end.
+func (k *KV) GetWithChecksum(key string) (value []byte, crc uint32, ok bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.closed {
+		return nil, 0, false
+	}
+	if key, ok = k.resolveLive(key); !ok {
+		return nil, 0, false
+	}
+	return append([]byte(nil), k.data[key]...), k.meta[key].crc, true
+}
+
+// Checksum returns the CRC32 polynomial this log uses.
+func (k *KV) Checksum() Checksum {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.format.checksum
+}
+
+// GetUnsafe returns the stored value without copying it. The slice belongs to
+// the KV: callers must not modify it and must not use it after the next
+// write to k. It exists for hot read paths where Get's copy is too costly.
+func (k *KV) GetUnsafe(key string) ([]byte, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.closed {
+		return nil, false
+	}
+	key, ok := k.resolveLive(key)
+	if !ok {
+		return nil, false
+	}
+	return k.data[key], true
+}
+
+// GetReader returns a reader over key's value, for streaming it to an
+// io.Writer such as an HTTP response. Values live in memory, so this does
+// not save loading them, but it avoids Get's copy: a write replaces a
+// stored value rather than modifying it, so the reader keeps returning the
+// value as it was when GetReader was called.
+func (k *KV) GetReader(key string) (io.ReadCloser, bool) {
+	val, ok := k.GetUnsafe(key)
+	if !ok {
+		return nil, false
+	}
+	return io.NopCloser(bytes.NewReader(val)), true
+}
+
+// Close closes the log file handle. Calling it again is a no-op.
 func (k *KV) Close() error {
-	return k.log.Close()
+	k.cancelClose()
+	k.mu.RLock()
+	sb, workers := k.standby, k.workers
+	k.mu.RUnlock()
+	if sb != nil {
+		sb.halt()
+	}
+	// workers may be waiting for k.mu, so stop them before taking the lock
+	for _, w := range workers {
+		w.halt()
+	}
+	// a compaction writing without k.mu must not outlive the storage
+	k.compactMu.Lock()
+	defer k.compactMu.Unlock()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.closed {
+		return nil
+	}
+	k.closed = true
+	k.resumed.Broadcast()
+	k.closeStreams()
+	// wake WaitDurable callers so they see the KV is closed
+	close(k.durableCh)
+	k.durableCh = make(chan struct{})
+	var err error
+	if k.unsynced > 0 {
+		err = k.syncLog()
+	}
+	if cerr := k.store.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// CompactEstimate reports what Compact would produce without writing anything.
+// liveBytes is the size of the compacted log (one set entry per live key) and
+// totalBytes is the current size of the log file.
+func (k *KV) CompactEstimate() (liveBytes, totalBytes int64, err error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.closed {
+		return 0, 0, ErrClosed
+	}
+	totalBytes, err = k.store.Size(k.logPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	for key, val := range k.data {
+		if !k.expired(key) {
+			liveBytes += int64(groupSize(k.keyPayloads(key, val)))
+		}
+	}
+	// aliases are written one entry each, not as a group
+	for _, payload := range k.aliasPayloads(nil) {
+		liveBytes += int64(groupSize([][]byte{payload}))
+	}
+	liveBytes += k.format.headerLen()
+	return liveBytes, totalBytes, nil
+}
+
+// CompactIfNeeded runs Compact only if CompactEstimate says it would
+// reclaim at least minReclaimRatio of the log file (0.5 means half), and
+// reports whether it ran. It is cheap enough to call on a timer.
+func (k *KV) CompactIfNeeded(minReclaimRatio float64) (bool, error) {
+	live, total, err := k.CompactEstimate()
+	if err != nil {
+		return false, err
+	}
+	if total <= 0 || float64(total-live)/float64(total) < minReclaimRatio {
+		return false, nil
+	}
+	if err := k.Compact(); err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
 // Compact builds a compacted log file from current in-memory state.
 // Steps:
-// 1) Create a temporary new log file (e.g., db.log.compact.tmp).
-// 2) Write set entries for all current keys to temp log, fsync the file.
-// 3) Rename temp -> db.log.rotated (atomic).
-// 4) fsync the directory to make rename durable.
-// 5) Reopen new log file for further appends.
+// 1) Write one entry per live key and alias to db.log.compact.tmp, with a
+//    base LSN that keeps LSNs where they were, and fsync it.
+// 2) Rename it to db.log.compact.new and remove the checkpoint, which
+//    describes the old log.
+// 3) Rename db.log.compact.new over db.log. Every rename fsyncs the
+//    directory, so a crash leaves either the old log or the new one.
+// 4) Rebuild the mirror, if any, and drop keys that expired from memory.
+// Later writes append to the new file.
+//
+// Compactions do not queue: if one is already running, Compact returns
+// ErrCompactionInProgress at once instead of waiting for it. With
+// WithCompactionThrottle, step 1 writes a snapshot of the state at the
+// throttled rate without holding the write lock, then retakes it to copy
+// the entries appended meanwhile to the end of the new file before the
+// renames.
 func (k *KV) Compact() error {
-	dir := filepath.Dir(k.logPath)
-	tmpName := k.logPath + ".compact.tmp"
-	tmpF, err := os.OpenFile(tmpName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC|os.O_EXCL, 0o664)
-	if err != nil {
+	if !k.compactMu.TryLock() {
+		return ErrCompactionInProgress
+	}
+	defer k.compactMu.Unlock()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.checkRewritable(); err != nil {
 		return err
 	}
-
-	// write current state as set entries (deterministic order is not necessary, but could be sorted)
-	for key, val := range k.data {
-		payload := buildSetPayload([]byte(key), val)
-		if err := writeLogEntry(tmpF, payload); err != nil {
-			tmpF.Close()
-			_ = os.Remove(tmpName)
+	if k.opts.replayFilter != nil {
+		return ErrReplayFiltered
+	}
+	tmpName := k.logPath + ".compact.tmp"
+	lf := k.format
+	tombstones := k.tombstones
+	if k.opts.compactRate > 0 {
+		if err := k.writeThrottled(tmpName, &lf); err != nil {
 			return err
 		}
-	}
-	// ensure tmp file is closed
-	if err := tmpF.Close(); err != nil {
-		_ = os.Remove(tmpName)
+	} else if err := k.writeCompacted(tmpName, &lf, k.opts, nil); err != nil {
 		return err
 	}
 
 	// rename tmp -> new log file atomically
 	rotatedName := k.logPath + ".compact.new"
-	if err := os.Rename(tmpName, rotatedName); err != nil {
-		_ = os.Remove(tmpName)
+	if err := k.store.Rename(tmpName, rotatedName); err != nil {
+		_ = k.store.Remove(tmpName)
 		return err
 	}
 
-	// fsync directory to make rename durable
-	df, err := os.Open(dir)
-	if err != nil {
+	// the checkpoint describes the old log
+	if err := removeCheckpoint(k.store, k.logPath); err != nil {
 		return err
 	}
-	if err := df.Sync(); err != nil {
-		df.Close()
+
+	// Finally, replace the active log with rotatedName using atomic rename
+	if err := k.store.Rename(rotatedName, k.logPath); err != nil {
 		return err
 	}
-	if err := df.Close(); err != nil {
+	k.format = lf
+	// writes not yet synced are in the new, synced log
+	k.markDurable()
+	// only deletes written during a throttled copy are left
+	k.tombstones -= tombstones
+	if err := k.rebuildMirror(); err != nil {
 		return err
 	}
+	k.dropExpired()
+	return nil
+}
 
-	// close current log
-	if err := k.log.Close(); err != nil {
+// writeThrottled is writeCompacted for a throttled Compact. It writes a
+// snapshot of the state with k.mu released, so reads and writes are not
+// held up by the rate limit, then retakes the lock and copies the log
+// entries appended since the snapshot to the end of the new file. Callers
+// must hold k.mu for writing, and hold it again when it returns.
+func (k *KV) writeThrottled(name string, lf *logFormat) error {
+	from, err := k.store.Size(k.logPath)
+	if err != nil {
+		return err
+	}
+	snap := k.rewriteSnapshot()
+	k.compacting = true
+	k.mu.Unlock()
+	err = snap.writeCompacted(name, lf, k.opts, nil)
+	k.mu.Lock()
+	k.compacting = false
+	if err != nil {
+		return err
+	}
+	// Close, Pause or a demotion may have happened during the copy
+	if err := k.checkRewritable(); err != nil {
+		_ = k.store.Remove(name)
+		return err
+	}
+	to, err := k.store.Size(k.logPath)
+	if err == nil && to > from {
+		// rewrites are refused while compacting, so the log has only
+		// grown, and in the format the new file shares
+		tail := make([]byte, to-from)
+		if _, err = k.store.ReadAt(k.logPath, tail, from); err == nil {
+			if err = k.store.Append(name, tail); err == nil {
+				err = k.store.Sync(name)
+			}
+		}
+	}
+	if err != nil {
+		_ = k.store.Remove(name)
 		return err
 	}
+	return nil
+}
 
-	// Finally, replace the active log with rotatedName using atomic rename
-	if err := os.Rename(rotatedName, k.logPath); err != nil {
+// rewriteSnapshot returns a detached KV holding what writeCompacted writes
+// for k: the values, expiry times, aliases and LSN, with expiry judged as
+// of now. Values are shared rather than copied, since a write replaces a
+// stored value instead of modifying it. Callers must hold k.mu.
+func (k *KV) rewriteSnapshot() *KV {
+	snap := &KV{
+		data:     make(map[string][]byte, len(k.data)),
+		expiry:   make(map[string]time.Time),
+		aliases:  maps.Clone(k.aliases),
+		lsn:      k.lsn,
+		store:    k.store,
+		opts:     k.opts,
+		closeCtx: k.closeCtx,
+	}
+	snap.opts.clock = fixedClock(k.opts.clock.Now())
+	for key, val := range k.data {
+		snap.data[key] = val
+		if at, ok := k.expiryOf(key); ok {
+			snap.expiry[key] = at
+		}
+	}
+	return snap
+}
+
+// dropExpired forgets expired keys in memory after a rewrite of the log
+// left them out. Callers must hold k.mu for writing.
+func (k *KV) dropExpired() {
+	for key := range k.expiry {
+		if k.expired(key) {
+			k.remove(key)
+		}
+	}
+}
+
+// CompactTo writes a compacted copy of the database to destPath, replacing
+// any file there, and leaves the active log alone. The copy is written in
+// the format described by opts (checksum, chunk size) rather than this
+// KV's, so it can be used to migrate a log to new settings: open the result
+// with the same options.
+func (k *KV) CompactTo(destPath string, opts ...Option) error {
+	o, err := resolveOptions(opts)
+	if err != nil {
 		return err
 	}
+	return k.compactTo(destPath, o, nil)
+}
+
+// ExportSubset is CompactTo for just the keys for which pred returns true,
+// for example one tenant's prefix, written in this KV's format. pred is
+// called with the read lock held and must not use k.
+func (k *KV) ExportSubset(destPath string, pred func(key string) bool) error {
+	return k.compactTo(destPath, k.opts, pred)
+}
 
-	// fsync dir again to ensure final rename durable
-	df2, err := os.Open(dir)
+// compactTo writes the keys accepted by keep (all with a nil keep) to
+// destPath in the format described by o.
+func (k *KV) compactTo(destPath string, o options, keep func(key string) bool) error {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.closed {
+		return ErrClosed
+	}
+	if k.opts.replayFilter != nil {
+		return ErrReplayFiltered
+	}
+	src, err := filepath.Abs(k.logPath)
 	if err != nil {
 		return err
 	}
-	if err := df2.Sync(); err != nil {
-		df2.Close()
+	dst, err := filepath.Abs(destPath)
+	if err != nil {
+		return err
+	}
+	if src == dst {
+		return errors.New("kv: destination is the active log")
+	}
+
+	lf := newLogFormat(o)
+	tmpName := destPath + ".compact.tmp"
+	if err := k.writeCompacted(tmpName, &lf, o, keep); err != nil {
 		return err
 	}
-	if err := df2.Close(); err != nil {
+	// a checkpoint left beside an older file at destPath does not describe
+	// the new one
+	if err := removeCheckpoint(k.store, destPath); err != nil {
+		_ = k.store.Remove(tmpName)
 		return err
 	}
+	if err := k.store.Rename(tmpName, destPath); err != nil {
+		_ = k.store.Remove(tmpName)
+		return err
+	}
+	return nil
+}
 
-	// reopen the log for appends
-	newLog, err := os.OpenFile(k.logPath, os.O_RDWR|os.O_APPEND, 0o664)
-	if err != nil {
+// writeCompacted writes a durable log file at name holding one write per
+// live key accepted by keep (every live key with a nil keep), in format lf
+// with the chunking and deduplication set in o. It sets lf's base LSN so
+// that replaying the file ends at k's LSN. A file already at name is
+// replaced; on failure nothing is left there. Callers must hold k.mu.
+func (k *KV) writeCompacted(name string, lf *logFormat, o options, keep func(key string) bool) error {
+	// only one compaction of a file runs at a time, so a leftover file is
+	// from a crashed one and safe to discard
+	if err := k.store.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	// judge expiry at one instant, so the writes counted for the base LSN
+	// are exactly the writes made
+	now := k.opts.clock.Now()
+	keys := make([]string, 0, len(k.data))
+	for key := range k.data {
+		if at, ok := k.expiryOf(key); ok && !now.Before(at) {
+			continue
+		}
+		if keep == nil || keep(key) {
+			keys = append(keys, key)
+		}
+	}
+	aliases := k.aliasPayloads(keep)
+	lf.baseLSN = k.rewriteBaseLSN(len(keys) + len(aliases))
+	if err := k.store.Append(name, encodeHeader(*lf)); err != nil {
+		_ = k.store.Remove(name)
+		return err
+	}
+	var dedup *deduper
+	if o.dedupValues {
+		dedup = k.newDeduper(o.chunkSize, keys)
+	}
+	var limiter *rateLimiter
+	if o.compactRate > 0 {
+		limiter = newRateLimiter(o.compactRate)
+	}
+	// write current state as set entries (deterministic order is not necessary, but could be sorted)
+	for _, key := range keys {
+		val := k.data[key]
+		payloads := k.keyPayloadsChunked(key, val, o.chunkSize)
+		if dedup != nil {
+			payloads = dedup.rewrite(key, val, payloads)
+		}
+		buf := appendEntries(nil, *lf, payloads)
+		if limiter != nil {
+			if err := limiter.wait(k.closeCtx, len(buf)); err != nil {
+				_ = k.store.Remove(name)
+				return ErrClosed
+			}
+		}
+		if err := k.store.Append(name, buf); err != nil {
+			_ = k.store.Remove(name)
+			return err
+		}
+	}
+	var buf []byte
+	for _, payload := range aliases {
+		buf = appendLogEntry(buf, *lf, payload)
+	}
+	if err := k.store.Append(name, buf); err != nil {
+		_ = k.store.Remove(name)
+		return err
+	}
+	if err := k.store.Sync(name); err != nil {
+		_ = k.store.Remove(name)
 		return err
 	}
-	k.log = newLog
 	return nil
 }
+
+// Reset truncates the log to an empty file (keeping its header) and clears
+// the in-memory map. Unlike deleting every key, the space is reclaimed
+// immediately.
+func (k *KV) Reset() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.checkRewritable(); err != nil {
+		return err
+	}
+	if err := removeCheckpoint(k.store, k.logPath); err != nil {
+		return err
+	}
+	if err := k.store.Truncate(k.logPath, 0); err != nil {
+		return err
+	}
+	// the reset itself counts as a write, so LSNs keep increasing
+	lf := k.format
+	lf.baseLSN = k.lsn + 1
+	if err := k.store.Append(k.logPath, encodeHeader(lf)); err != nil {
+		return err
+	}
+	if err := k.store.Sync(k.logPath); err != nil {
+		return err
+	}
+	k.format = lf
+	k.lsn = lf.baseLSN
+	k.markDurable()
+	k.tombstones = 0
+	for key := range k.data {
+		k.publish(OpDel, key, nil)
+	}
+	k.clearMemory()
+	return k.rebuildMirror()
+}
@@ -0,0 +1,682 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOpenInfo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	k, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.Set("a", []byte("1"))
+	k.Set("b", []byte("2"))
+	k.Set("a", []byte("3"))
+	k.Set("c", []byte("4"))
+	k.Del("b")
+	k.Close()
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := k.OpenInfo()
+	k.Close()
+	if info.EntriesReplayed != 5 || info.KeysLoaded != 2 || info.BytesRead != fi.Size() {
+		t.Errorf("OpenInfo = %+v, want 5 entries, 2 keys, %d bytes", info, fi.Size())
+	}
+	if info.TruncatedTail || info.TailError != nil {
+		t.Errorf("clean log reported a torn tail: %+v", info)
+	}
+
+	// half an entry left by a crash
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 0, 20, 0, 0})
+	f.Close()
+	k, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	info = k.OpenInfo()
+	if !info.TruncatedTail || info.TruncatedBytes != 6 || info.BytesRead != fi.Size() {
+		t.Errorf("OpenInfo after a torn write = %+v", info)
+	}
+	if info.EntriesReplayed != 5 || info.KeysLoaded != 2 {
+		t.Errorf("torn tail changed the counts: %+v", info)
+	}
+}
+
+func TestSkipRedundantWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	k, err := Create(path, WithSkipRedundantWrites())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	size := func() int64 {
+		t.Helper()
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fi.Size()
+	}
+	k.Set("a", []byte("1"))
+	before := size()
+	if err := k.Set("a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if size() != before {
+		t.Error("an identical Set was written")
+	}
+	k.Set("a", []byte("2"))
+	if size() == before {
+		t.Error("a changed Set was skipped")
+	}
+
+	// a TTL refresh must still be written
+	before = size()
+	k.SetWithTTL("a", []byte("2"), time.Hour)
+	if size() == before {
+		t.Error("SetWithTTL with an unchanged value was skipped")
+	}
+	// and a plain Set clearing that TTL too
+	before = size()
+	k.Set("a", []byte("2"))
+	if size() == before {
+		t.Error("Set clearing a TTL was skipped")
+	}
+	if ttl, _ := k.TTL("a"); ttl != 0 {
+		t.Errorf("TTL = %v after Set, want none", ttl)
+	}
+}
+
+// closedTestType is a custom entry type for TestOperationsAfterClose.
+const closedTestType = OpCustom + 100
+
+func TestOperationsAfterClose(t *testing.T) {
+	dir := t.TempDir()
+	k, err := Create(filepath.Join(dir, "a.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.Set("a", []byte("1"))
+	k.Set("doc", []byte(`{"n":1}`))
+	k.SetAlias("alias", "a")
+	if err := k.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Close(); err != nil {
+		t.Errorf("second Close = %v, want nil", err)
+	}
+
+	var b Batch
+	b.Set("b", []byte("2"))
+	stream := appendLogEntry(nil, k.format, buildSetPayload([]byte("b"), []byte("2")))
+	RegisterEntryHandler(closedTestType, func([]byte) error { return nil })
+	ctx := context.Background()
+	for name, op := range map[string]func() error{
+		"Set":          func() error { return k.Set("a", []byte("2")) },
+		"SetContext":   func() error { return k.SetContext(ctx, "a", []byte("2")) },
+		"SetWithTTL":   func() error { return k.SetWithTTL("a", nil, time.Hour) },
+		"SetWithIdle":  func() error { return k.SetWithIdleTTL("a", nil, time.Hour) },
+		"SetOrdered":   func() error { return k.SetOrdered([]KeyValue{{"a", nil}}) },
+		"SetAlias":     func() error { return k.SetAlias("x", "a") },
+		"SetEncoded":   func() error { return k.SetEncoded("a", 1, "json") },
+		"Del":          func() error { return k.Del("a") },
+		"DelContext":   func() error { return k.DelContext(ctx, "a") },
+		"WriteBatch":   func() error { return k.WriteBatch(&b) },
+		"WriteCustom":  func() error { return k.WriteCustom(closedTestType, nil) },
+		"ListPush":     func() error { return k.ListPush("l", nil) },
+		"Update":       func() error { return k.Update(func(*Tx) error { return nil }) },
+		"View":         func() error { return k.View(func(*ReadTx) error { return nil }) },
+		"Sync":         k.Sync,
+		"Compact":      k.Compact,
+		"Checkpoint":   k.Checkpoint,
+		"Reset":        k.Reset,
+		"Pause":        k.Pause,
+		"CompactTo":    func() error { return k.CompactTo(filepath.Join(dir, "b.log")) },
+		"ExportSubset": func() error { return k.ExportSubset(filepath.Join(dir, "b.log"), nil) },
+		"Relocate":     func() error { return k.Relocate(filepath.Join(dir, "b.log")) },
+		"SwapFile":     func() error { return k.SwapFile(filepath.Join(dir, "b.log")) },
+		"ImportStream": func() error { return k.ImportStream(bytes.NewReader(stream), 1, nil) },
+		"SnapshotGob":  func() error { return k.SnapshotGob(io.Discard) },
+		"ScanFunc":     func() error { return k.ScanFunc("", func(string, []byte) error { return nil }) },
+		"WaitDurable":  func() error { return k.WaitDurable(k.LastLSN()+1, ctx) },
+		"WalkLog":      func() error { return k.WalkLog(func(int64, EntryType, string, []byte) error { return nil }) },
+		"CompactIf":    func() error { _, err := k.CompactIfNeeded(0); return err },
+		"Estimate":     func() error { _, _, err := k.CompactEstimate(); return err },
+		"SetIf":        func() error { _, err := k.SetIf("a", nil, nil); return err },
+		"SetWithToken": func() error { _, err := k.SetWithToken("a", nil, 0); return err },
+		"DeleteIf":     func() error { _, err := k.DeleteIf("a", nil); return err },
+		"DeleteFunc":   func() error { _, err := k.DeleteFunc(nil); return err },
+		"Undelete":     func() error { _, err := k.Undelete("a"); return err },
+		"NextID":       func() error { _, err := k.NextID("seq"); return err },
+		"ListLen":      func() error { _, err := k.ListLen("l"); return err },
+		"ListRange":    func() error { _, err := k.ListRange("l", 0, -1); return err },
+		"WriteCount":   func() error { _, err := k.WriteCount("a"); return err },
+		"VerifyOnline": func() error { _, err := k.VerifyOnline(); return err },
+	} {
+		if err := op(); !errors.Is(err, ErrClosed) {
+			t.Errorf("%s after Close: err = %v, want ErrClosed", name, err)
+		}
+	}
+
+	if _, ok := k.Get("a"); ok {
+		t.Error("Get found a key after Close")
+	}
+	if _, ok := k.Get("alias"); ok {
+		t.Error("Get followed an alias after Close")
+	}
+	var v string
+	if found, err := k.GetDecoded("a", &v); found || err != nil {
+		t.Errorf("GetDecoded after Close = %v, %v", found, err)
+	}
+	if _, ok := k.GetUnsafe("a"); ok {
+		t.Error("GetUnsafe found a key after Close")
+	}
+	if _, ok := k.Peek("a"); ok {
+		t.Error("Peek found a key after Close")
+	}
+	if keys := k.Keys(); len(keys) != 0 {
+		t.Errorf("Keys after Close = %q", keys)
+	}
+	if m := k.GetAll(); len(m) != 0 {
+		t.Errorf("GetAll after Close = %v", m)
+	}
+	if keys := k.QueryJSON("", "n", "=", "1"); len(keys) != 0 {
+		t.Errorf("QueryJSON after Close = %q", keys)
+	}
+}
+
+func TestReset(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	k, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		k.Set(key, []byte("value"))
+	}
+	lsn := k.LastLSN()
+	if err := k.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != headerSize {
+		t.Errorf("log is %d bytes after Reset, want just the header", fi.Size())
+	}
+	if keys := k.Keys(); len(keys) != 0 {
+		t.Errorf("Keys after Reset = %q", keys)
+	}
+	if s := k.Stats(); s != (Stats{}) {
+		t.Errorf("Stats after Reset = %+v", s)
+	}
+	if k.LastLSN() <= lsn {
+		t.Errorf("LastLSN went from %d to %d", lsn, k.LastLSN())
+	}
+
+	k.Set("d", []byte("1"))
+	k.Close()
+	k, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	if keys := k.Keys(); len(keys) != 1 || keys[0] != "d" {
+		t.Errorf("Keys after reopen = %q", keys)
+	}
+}
+
+func TestReplayWithoutChecksums(t *testing.T) {
+	s := NewMemoryStorage()
+	k, err := Create("a.log", WithStorage(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.Set("a", []byte("1"))
+	k.Set("b", []byte("2"))
+	k.Close()
+	size, err := s.Size("a.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// damage b's value, leaving the framing intact
+	flipByte(t, s, "a.log", size-1)
+
+	k, err = Open("a.log", WithStorage(s), WithVerifyChecksums(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, ok := k.Get("b"); !ok || string(v) == "2" {
+		t.Errorf("Get(b) = %q, %v; want the damaged value applied as is", v, ok)
+	}
+	if v, _ := k.Get("a"); string(v) != "1" {
+		t.Errorf("Get(a) = %q", v)
+	}
+	k.Close()
+
+	k, err = Open("a.log", WithStorage(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	if _, ok := k.Get("b"); ok || !k.OpenInfo().TruncatedTail {
+		t.Error("verified replay applied a damaged entry")
+	}
+}
+
+func BenchmarkReplay(b *testing.B) {
+	path := filepath.Join(b.TempDir(), "a.log")
+	k, err := Create(path, WithNoSync())
+	if err != nil {
+		b.Fatal(err)
+	}
+	val := bytes.Repeat([]byte("v"), 1024)
+	for i := 0; i < 20000; i++ {
+		k.Set(fmt.Sprintf("key%d", i%5000), val)
+	}
+	k.Close()
+	fi, err := os.Stat(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, verify := range []bool{true, false} {
+		b.Run(fmt.Sprintf("verify=%v", verify), func(b *testing.B) {
+			b.SetBytes(fi.Size())
+			for i := 0; i < b.N; i++ {
+				k, err := Open(path, WithVerifyChecksums(verify))
+				if err != nil {
+					b.Fatal(err)
+				}
+				k.Close()
+			}
+		})
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	k := benchmarkKV(b, 1000)
+	k.Set("big", bytes.Repeat([]byte("v"), 4096))
+	b.Run("Get", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			k.Get("big")
+		}
+	})
+	b.Run("GetUnsafe", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			k.GetUnsafe("big")
+		}
+	})
+}
+
+func TestGetUnsafeSharesStorage(t *testing.T) {
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	k.Set("a", []byte("1"))
+	v, ok := k.GetUnsafe("a")
+	if !ok || string(v) != "1" {
+		t.Fatalf("GetUnsafe = %q, %v", v, ok)
+	}
+	// a later write replaces the value rather than modifying it
+	k.Set("a", []byte("2"))
+	if string(v) != "1" {
+		t.Errorf("slice from GetUnsafe changed to %q by a write", v)
+	}
+	if _, ok := k.GetUnsafe("missing"); ok {
+		t.Error("GetUnsafe found a missing key")
+	}
+}
+
+func TestReplayFilter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	k, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.Set("user:1", []byte("u1"))
+	k.Set("order:1", []byte("o1"))
+	k.Set("user:2", []byte("u2"))
+	k.Del("user:2")
+	k.SetAlias("user:me", "user:1")
+	k.SetAlias("order:last", "order:1")
+	k.Close()
+
+	users := func(key string) bool { return strings.HasPrefix(key, "user:") }
+	k, err = Open(path, WithReplayFilter(users))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := k.Keys(); len(keys) != 1 || keys[0] != "user:1" {
+		t.Errorf("Keys = %q, want only user:1", keys)
+	}
+	if v, _ := k.Get("user:me"); string(v) != "u1" {
+		t.Errorf("Get(user:me) = %q", v)
+	}
+	if _, ok := k.Get("order:last"); ok {
+		t.Error("filtered alias was loaded")
+	}
+	if err := k.Compact(); !errors.Is(err, ErrReplayFiltered) {
+		t.Errorf("Compact = %v, want ErrReplayFiltered", err)
+	}
+	if err := k.Checkpoint(); !errors.Is(err, ErrReplayFiltered) {
+		t.Errorf("Checkpoint = %v, want ErrReplayFiltered", err)
+	}
+	// writes outside the filter still reach the shared log
+	k.Set("order:2", []byte("o2"))
+	k.Close()
+
+	k, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	if keys := k.Keys(); len(keys) != 3 {
+		t.Errorf("Keys without a filter = %q", keys)
+	}
+}
+
+func TestGetWithChecksum(t *testing.T) {
+	for _, c := range []Checksum{ChecksumIEEE, ChecksumCastagnoli} {
+		path := filepath.Join(t.TempDir(), "a.log")
+		k, err := Create(path, WithChecksum(c), WithChunkSize(4))
+		if err != nil {
+			t.Fatal(err)
+		}
+		k.Set("blob", []byte("important bytes"))
+		_, want, _ := k.GetWithChecksum("blob")
+		k.Close()
+
+		// the checksum comes back from replay, chunked values included
+		k, err = Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer k.Close()
+		v, crc, ok := k.GetWithChecksum("blob")
+		if !ok || crc != want {
+			t.Fatalf("GetWithChecksum after reopen = %x, %v; want %x", crc, ok, want)
+		}
+		if k.Checksum().Sum(v) != crc {
+			t.Errorf("%v: re-check of an intact value failed", c)
+		}
+		v[0] ^= 1
+		if k.Checksum().Sum(v) == crc {
+			t.Errorf("%v: re-check missed a flipped byte", c)
+		}
+		if _, _, ok := k.GetWithChecksum("missing"); ok {
+			t.Error("GetWithChecksum found a missing key")
+		}
+	}
+}
+
+func TestSetIf(t *testing.T) {
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+
+	// monotonic versions: a write only lands if it is newer
+	newer := func(v uint64) func([]byte, bool) bool {
+		return func(cur []byte, exists bool) bool {
+			return !exists || binary.BigEndian.Uint64(cur) < v
+		}
+	}
+	version := func(v uint64) []byte { return binary.BigEndian.AppendUint64(nil, v) }
+	for _, tc := range []struct {
+		v     uint64
+		wrote bool
+	}{{5, true}, {3, false}, {5, false}, {6, true}} {
+		wrote, err := k.SetIf("doc", version(tc.v), newer(tc.v))
+		if err != nil || wrote != tc.wrote {
+			t.Errorf("SetIf(v%d) = %v, %v; want %v", tc.v, wrote, err, tc.wrote)
+		}
+	}
+	if v, _ := k.Get("doc"); binary.BigEndian.Uint64(v) != 6 {
+		t.Errorf("doc is at version %d, want 6", binary.BigEndian.Uint64(v))
+	}
+
+	// absent keys, including expired ones, reach cond with exists false
+	k.SetWithTTL("old", []byte("x"), -time.Second)
+	for _, key := range []string{"new", "old"} {
+		var sawExists bool
+		var sawCur []byte
+		wrote, err := k.SetIf(key, []byte("v"), func(cur []byte, exists bool) bool {
+			sawCur, sawExists = cur, exists
+			return !exists
+		})
+		if err != nil || !wrote || sawExists || sawCur != nil {
+			t.Errorf("SetIf(%s) = %v, %v; cond saw %q, %v", key, wrote, err, sawCur, sawExists)
+		}
+		if v, _ := k.Get(key); string(v) != "v" {
+			t.Errorf("%s = %q, want v", key, v)
+		}
+	}
+	k.Close()
+	if _, err := k.SetIf("doc", nil, newer(9)); !errors.Is(err, ErrClosed) {
+		t.Errorf("SetIf after Close = %v, want ErrClosed", err)
+	}
+}
+
+func TestOpenCreateNewKV(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.log")
+
+	if _, err := Open(path); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Open of a missing file = %v, want ErrNotExist", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Open created the missing file")
+	}
+
+	k, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.Set("a", []byte("1"))
+	k.Close()
+	if _, err := Create(path); !errors.Is(err, ErrExist) {
+		t.Errorf("Create of an existing file = %v, want ErrExist", err)
+	}
+
+	// NewKV opens what is there and creates what is not
+	k, err = NewKV(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := k.Get("a"); string(v) != "1" {
+		t.Errorf("NewKV of an existing log: a = %q, want 1", v)
+	}
+	k.Close()
+	k, err = NewKV(filepath.Join(dir, "b.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	if n := k.Stats().Keys; n != 0 {
+		t.Errorf("NewKV of a missing log has %d keys", n)
+	}
+}
+
+func TestDeleteIf(t *testing.T) {
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	k.Set("a", []byte("1"))
+	k.Set("b", []byte("2"))
+	k.Set("empty", []byte{})
+
+	for _, tc := range []struct {
+		key      string
+		expected []byte
+		deleted  bool
+	}{
+		{"a", []byte("other"), false}, // mismatch
+		{"a", []byte("1"), true},      // match
+		{"a", []byte("1"), false},     // now absent
+		{"missing", nil, false},       // absent, any value
+		{"b", nil, true},              // present, any value
+		{"empty", []byte{}, true},     // an empty value is not "any"
+	} {
+		deleted, err := k.DeleteIf(tc.key, tc.expected)
+		if err != nil || deleted != tc.deleted {
+			t.Errorf("DeleteIf(%s, %q) = %v, %v; want %v", tc.key, tc.expected, deleted, err, tc.deleted)
+		}
+		if _, ok := k.Get(tc.key); ok && tc.deleted {
+			t.Errorf("%s still present after DeleteIf reported a delete", tc.key)
+		}
+	}
+	if n := k.Stats().Keys; n != 0 {
+		t.Errorf("%d keys left, want 0", n)
+	}
+}
+
+func TestDeleteFunc(t *testing.T) {
+	s := &flakySyncStorage{Storage: NewMemoryStorage()}
+	k, err := Create("a.log", WithStorage(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		val := "keep"
+		if i%3 == 0 {
+			val = "stale"
+		}
+		k.Set(fmt.Sprintf("k%d", i), []byte(val))
+	}
+
+	s.calls = 0
+	n, err := k.DeleteFunc(func(_ string, value []byte) bool { return string(value) == "stale" })
+	if err != nil || n != 4 {
+		t.Fatalf("DeleteFunc = %d, %v; want 4 deleted", n, err)
+	}
+	if s.calls != 1 {
+		t.Errorf("DeleteFunc synced %d times, want once", s.calls)
+	}
+	if n, err := k.DeleteFunc(func(string, []byte) bool { return false }); err != nil || n != 0 {
+		t.Errorf("DeleteFunc matching nothing = %d, %v; want 0", n, err)
+	}
+	k.Close()
+
+	// the deletes are durable and the unrelated keys survive
+	k, err = Open("a.log", WithStorage(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("k%d", i)
+		v, ok := k.Get(key)
+		if i%3 == 0 && ok {
+			t.Errorf("%s survived DeleteFunc", key)
+		}
+		if i%3 != 0 && string(v) != "keep" {
+			t.Errorf("Get(%s) = %q, %v; want keep", key, v, ok)
+		}
+	}
+}
+
+func TestWastedEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	k, err := Create(path, WithChunkSize(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.Set("a", []byte("1"))
+	k.Set("a", []byte("2"))
+	k.Set("a", []byte("3")) // 2 wasted
+	k.Set("b", []byte("2"))
+	k.Del("b")                                   // the value and the delete
+	k.Set("big", []byte("spans several chunks")) // one value, however chunked
+	k.Set("big", []byte("short"))
+	k.Del("never set") // just the delete
+	k.Set("c", []byte("kept"))
+	k.Close()
+
+	k, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := k.OpenInfo().WastedEntries; n != 6 {
+		t.Errorf("WastedEntries = %d, want 6", n)
+	}
+	if err := k.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	k.Close()
+	k, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	if n := k.OpenInfo().WastedEntries; n != 0 {
+		t.Errorf("WastedEntries after Compact = %d, want 0", n)
+	}
+}
+
+func TestGetReader(t *testing.T) {
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"), WithChunkSize(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	big := bytes.Repeat([]byte("0123456789"), 10000)
+	k.Set("big", big)
+
+	r, ok := k.GetReader("big")
+	if !ok {
+		t.Fatal("GetReader missed the key")
+	}
+	// a write while streaming does not change what the reader returns
+	head := make([]byte, 10)
+	io.ReadFull(r, head)
+	k.Set("big", []byte("replaced"))
+	var out bytes.Buffer
+	out.Write(head)
+	if _, err := io.Copy(&out, r); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	if !bytes.Equal(out.Bytes(), big) {
+		t.Errorf("streamed %d bytes that differ from the %d written", out.Len(), len(big))
+	}
+
+	r, _ = k.GetReader("big")
+	got, _ := io.ReadAll(r)
+	if v, _ := k.Get("big"); !bytes.Equal(got, v) {
+		t.Errorf("GetReader = %q, Get = %q", got, v)
+	}
+	if _, ok := k.GetReader("missing"); ok {
+		t.Error("GetReader found a missing key")
+	}
+}
@@ -0,0 +1,325 @@
+package kv
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// ReadLatest returns key's current value in the log at logPath without
+// opening it as a KV: nothing is replayed into memory, and entries are
+// examined from the newest back, stopping at the last write of key. It
+// follows aliases and honors TTLs as Get would.
+//
+// Frames carry no trailer to step back over, so entries are located by an
+// offset index of the log, built by reading forward in large chunks and
+// skipping payloads. The index is kept in memory for the most recently
+// used logs and, while the file is only appended to, later calls frame
+// just the entries added since, so repeated lookups in a growing log read
+// little more than the new tail and the payloads they examine. A log
+// replaced by Compact, or cut back and rewritten, is framed afresh.
+//
+// Checksums are verified only on the payloads read. Replay stops at the
+// oldest damaged entry and drops everything after it; ReadLatest instead
+// skips a damaged entry it passes over, forgets any partial match newer
+// than it, and goes on to older entries, so it can return a value from
+// before or after damage that Open would have cut off. A damaged length
+// may also frame the rest of the file wrongly. That makes it a cheap way
+// for tools to look up one key in a large log, not a substitute for Get.
+func ReadLatest(logPath, key string) ([]byte, bool, error) {
+	f, err := os.Open(logPath)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+	lf, frames, err := indexLog(logPath, f)
+	if err != nil || frames == nil {
+		return nil, false, err
+	}
+	for hops := 0; hops <= defaultMaxAliasHops; hops++ {
+		val, target, ok, err := latestWrite(f, lf, frames, key)
+		if err != nil || target == "" {
+			return val, ok, err
+		}
+		key = target
+	}
+	return nil, false, nil
+}
+
+// logFrame locates a committed entry whose payload has not been read.
+type logFrame struct {
+	offset int64
+	size   uint32
+	crc    uint32
+}
+
+// latestIndexChunk is how much of a log logIndex.extend reads at a time.
+const latestIndexChunk = 1 << 20
+
+// maxLatestIndexes is how many logs ReadLatest keeps offset indexes for.
+const maxLatestIndexes = 8
+
+var latestIndexes = struct {
+	sync.Mutex
+	m map[string]*logIndex
+}{m: make(map[string]*logIndex)}
+
+// logIndex lists the entries replay would apply from a log file, as far
+// as it has been framed.
+type logIndex struct {
+	fi      os.FileInfo
+	lf      logFormat
+	frames  []logFrame
+	batch   []logFrame // framed members of a batch not yet committed
+	inBatch bool
+	off     int64 // where the next entry starts
+	stopped bool  // a malformed batch ends the log for replay
+	used    int64 // for evicting the least recently used index
+}
+
+var latestIndexClock int64
+
+// indexLog returns the format and committed entries of the log at logPath,
+// open as f, from the cached index if it still describes the file. frames
+// is nil for a file without a complete header.
+func indexLog(logPath string, f *os.File) (logFormat, []logFrame, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return logFormat{}, nil, err
+	}
+	latestIndexes.Lock()
+	defer latestIndexes.Unlock()
+	ix := latestIndexes.m[logPath]
+	if ix == nil || !ix.stillDescribes(f, fi) {
+		lf, ok, err := readHeader(f)
+		if err != nil || !ok {
+			return logFormat{}, nil, err
+		}
+		ix = &logIndex{lf: lf, off: lf.headerLen()}
+		latestIndexes.m[logPath] = ix
+		if len(latestIndexes.m) > maxLatestIndexes {
+			evictLatestIndex()
+		}
+	}
+	ix.fi = fi
+	latestIndexClock++
+	ix.used = latestIndexClock
+	if err := ix.extend(f, fi.Size()); err != nil {
+		delete(latestIndexes.m, logPath)
+		return logFormat{}, nil, err
+	}
+	return ix.lf, ix.frames[:len(ix.frames):len(ix.frames)], nil
+}
+
+func evictLatestIndex() {
+	var oldest string
+	for path, ix := range latestIndexes.m {
+		if oldest == "" || ix.used < latestIndexes.m[oldest].used {
+			oldest = path
+		}
+	}
+	delete(latestIndexes.m, oldest)
+}
+
+// stillDescribes reports whether ix, built earlier, is a prefix of the
+// file f: the same file, no shorter, with the last entry framed unchanged.
+func (ix *logIndex) stillDescribes(f *os.File, fi os.FileInfo) bool {
+	if !os.SameFile(ix.fi, fi) || fi.Size() < ix.off {
+		return false
+	}
+	last := ix.batch
+	if len(last) == 0 {
+		last = ix.frames
+	}
+	if len(last) == 0 {
+		return true
+	}
+	fr := last[len(last)-1]
+	var hdr [8]byte
+	if _, err := f.ReadAt(hdr[:], fr.offset); err != nil {
+		return false
+	}
+	return ix.lf.order().Uint32(hdr[0:4]) == fr.size && ix.lf.order().Uint32(hdr[4:8]) == fr.crc
+}
+
+// extend frames the entries of the size-byte log in r from ix.off on,
+// reading latestIndexChunk bytes at a time and only the headers and the
+// few payload bytes that say whether an entry is a pad or a batch marker.
+// Checksums are not verified; latestWrite checks them as it reads
+// payloads. A torn tail is left unframed until the file grows past it.
+func (ix *logIndex) extend(r io.ReaderAt, size int64) error {
+	lf := ix.lf
+	buf := make([]byte, latestIndexChunk)
+	var bufOff, bufLen int64
+	// header, type byte and a commit marker's count
+	const need = 8 + 5
+	for !ix.stopped && ix.off+8 <= size {
+		off := ix.off
+		if off+need > bufOff+bufLen && bufOff+bufLen < size {
+			n, err := r.ReadAt(buf, off)
+			if n < 8 {
+				if err == nil || err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return err
+			}
+			bufOff, bufLen = off, int64(n)
+		}
+		h := buf[off-bufOff : bufLen]
+		fr := logFrame{
+			offset: off,
+			size:   lf.order().Uint32(h[0:4]),
+			crc:    lf.order().Uint32(h[4:8]),
+		}
+		next := off + 8 + int64(fr.size)
+		if next > size {
+			// torn tail
+			return nil
+		}
+		ix.off = next
+		if fr.size == 0 || len(h) < 9 {
+			continue
+		}
+		switch EntryType(h[8]) {
+		case OpPad:
+			continue
+		case OpBatchBegin:
+			if ix.inBatch {
+				ix.stopped = true
+				return nil
+			}
+			ix.inBatch = true
+			ix.batch = ix.batch[:0]
+			continue
+		case OpBatchCommit:
+			if !ix.inBatch || fr.size < 5 || len(h) < need ||
+				int(lf.order().Uint32(h[9:13])) != len(ix.batch) {
+				ix.stopped = true
+				return nil
+			}
+			ix.frames = append(ix.frames, ix.batch...)
+			ix.batch = ix.batch[:0]
+			ix.inBatch = false
+			continue
+		}
+		if ix.inBatch {
+			ix.batch = append(ix.batch, fr)
+		} else {
+			ix.frames = append(ix.frames, fr)
+		}
+	}
+	return nil
+}
+
+// latestWrite finds key's last write among frames, reading their payloads
+// from r newest first. It returns the value, or the target if key was last
+// made an alias. An entry whose checksum does not match is skipped, and
+// what was gathered from newer entries, such as an expiry or the later
+// chunks of a value, is forgotten; see ReadLatest.
+func latestWrite(r io.ReaderAt, lf logFormat, frames []logFrame, key string) (val []byte, target string, ok bool, err error) {
+	var expiry time.Time
+	var parts [][]byte
+	var ref *[sha256.Size]byte
+	for i := len(frames) - 1; i >= 0; i-- {
+		fr := frames[i]
+		payload := make([]byte, fr.size)
+		if _, err := r.ReadAt(payload, fr.offset+8); err != nil {
+			return nil, "", false, err
+		}
+		if lf.checksum.Sum(payload) != fr.crc {
+			expiry, parts, ref = time.Time{}, nil, nil
+			continue
+		}
+		payload = transcodePayload(payload, lf.order(), binary.BigEndian)
+		typ := EntryType(payload[0])
+		if ref != nil {
+			// a ref was found; look further back for the blob it names
+			if typ == OpBlob {
+				sum, v, err := decodeBlob(payload)
+				if err != nil {
+					return nil, "", false, &CorruptionError{Offset: fr.offset, Err: err}
+				}
+				if sum == *ref {
+					return latestValue(v, expiry)
+				}
+			}
+			continue
+		}
+		switch typ {
+		case OpSet:
+			k, v, err := decodeSet(payload)
+			if err != nil {
+				return nil, "", false, &CorruptionError{Offset: fr.offset, Err: err}
+			}
+			if k == key {
+				return latestValue(v, expiry)
+			}
+		case OpChunk:
+			k, part, first, err := decodeChunk(payload)
+			if err != nil {
+				return nil, "", false, &CorruptionError{Offset: fr.offset, Err: err}
+			}
+			if k != key {
+				continue
+			}
+			parts = append(parts, part)
+			if first {
+				for l, r := 0, len(parts)-1; l < r; l, r = l+1, r-1 {
+					parts[l], parts[r] = parts[r], parts[l]
+				}
+				return latestValue(bytes.Join(parts, nil), expiry)
+			}
+		case OpRef:
+			k, sum, err := decodeRef(payload)
+			if err != nil {
+				return nil, "", false, &CorruptionError{Offset: fr.offset, Err: err}
+			}
+			if k == key {
+				ref = &sum
+			}
+		case OpDel:
+			k, err := decodeDel(payload)
+			if err != nil {
+				return nil, "", false, &CorruptionError{Offset: fr.offset, Err: err}
+			}
+			if k == key {
+				return nil, "", false, nil
+			}
+		case OpAlias:
+			alias, t, err := decodeAlias(payload)
+			if err != nil {
+				return nil, "", false, &CorruptionError{Offset: fr.offset, Err: err}
+			}
+			if alias == key {
+				return nil, t, false, nil
+			}
+		case OpExpire:
+			k, at, err := decodeExpire(payload)
+			if err != nil {
+				return nil, "", false, &CorruptionError{Offset: fr.offset, Err: err}
+			}
+			// an expiry follows the write it applies to, so it is seen first
+			if k == key && expiry.IsZero() {
+				expiry = at
+			}
+		}
+	}
+	if ref != nil {
+		return nil, "", false, fmt.Errorf("%w: ref to unknown blob", ErrMalformedEntry)
+	}
+	return nil, "", false, nil
+}
+
+// latestValue is the result for a value found by latestWrite whose write
+// was followed by an expiry at expiry, or none if it is zero.
+func latestValue(v []byte, expiry time.Time) ([]byte, string, bool, error) {
+	if !expiry.IsZero() && !time.Now().Before(expiry) {
+		return nil, "", false, nil
+	}
+	return append([]byte(nil), v...), "", true, nil
+}
@@ -0,0 +1,235 @@
+package kv
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReadLatest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	k, err := Create(path, WithChunkSize(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.Set("a", []byte("1"))
+	k.Set("a", []byte("2"))
+	k.Set("gone", []byte("x"))
+	k.Del("gone")
+	k.Set("big", bytes.Repeat([]byte("b"), 30))
+	k.SetWithTTL("old", []byte("x"), -time.Second)
+	k.SetWithTTL("fresh", []byte("y"), time.Hour)
+	k.SetAlias("link", "a")
+	k.Close()
+
+	for _, tc := range []struct {
+		key  string
+		want string
+		ok   bool
+	}{
+		{"a", "2", true},
+		{"gone", "", false},
+		{"big", string(bytes.Repeat([]byte("b"), 30)), true},
+		{"old", "", false},
+		{"fresh", "y", true},
+		{"link", "2", true},
+		{"missing", "", false},
+	} {
+		v, ok, err := ReadLatest(path, tc.key)
+		if err != nil || ok != tc.ok || string(v) != tc.want {
+			t.Errorf("ReadLatest(%q) = %q, %v, %v; want %q, %v", tc.key, v, ok, err, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestReadLatestSkipsUncommittedBatchAndTornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	k, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.Set("a", []byte("1"))
+	var b Batch
+	b.Set("a", []byte("2"))
+	b.Set("b", []byte("2"))
+	k.WriteBatch(&b)
+	k.Close()
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// drop the commit marker, then leave half an entry after it
+	commit := int64(8 + len(buildBatchCommitPayload(2)))
+	if err := os.Truncate(path, fi.Size()-commit); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 0, 9, 1, 2})
+	f.Close()
+
+	if v, ok, err := ReadLatest(path, "a"); err != nil || !ok || string(v) != "1" {
+		t.Errorf("ReadLatest(a) = %q, %v, %v; want the write before the batch", v, ok, err)
+	}
+	if _, ok, err := ReadLatest(path, "b"); err != nil || ok {
+		t.Errorf("ReadLatest(b) = %v, %v; want the uncommitted batch ignored", ok, err)
+	}
+}
+
+// countingReader counts the reads made through it and the bytes read.
+type countingReader struct {
+	r     io.ReaderAt
+	n     atomic.Int64
+	calls atomic.Int64
+}
+
+func (c *countingReader) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	c.n.Add(int64(n))
+	c.calls.Add(1)
+	return n, err
+}
+
+func TestReadLatestIndexReads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	k, err := Create(path, WithNoSync())
+	if err != nil {
+		t.Fatal(err)
+	}
+	val := bytes.Repeat([]byte("v"), 100)
+	for i := 0; i < 20000; i++ {
+		k.Set(fmt.Sprintf("k%d", i), val)
+	}
+	k.Set("target", []byte("found"))
+	k.Sync()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lf, _, err := readHeader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// framing reads in large chunks, not once per entry
+	cr := &countingReader{r: f}
+	ix := &logIndex{lf: lf, off: lf.headerLen(), fi: fi}
+	if err := ix.extend(cr, fi.Size()); err != nil {
+		t.Fatal(err)
+	}
+	if len(ix.frames) != 20001 {
+		t.Fatalf("framed %d entries, want 20001", len(ix.frames))
+	}
+	if calls, most := cr.calls.Load(), fi.Size()/latestIndexChunk+2; calls > most {
+		t.Errorf("framing %d bytes took %d reads, want at most %d", fi.Size(), calls, most)
+	}
+	// and payloads are read newest first, only up to the one sought
+	cr.n.Store(0)
+	v, _, ok, err := latestWrite(cr, lf, ix.frames, "target")
+	if err != nil || !ok || string(v) != "found" {
+		t.Fatalf("latestWrite(target) = %q, %v, %v", v, ok, err)
+	}
+	if read := cr.n.Load(); read > 100 {
+		t.Errorf("read %d bytes of payload to find the newest key", read)
+	}
+
+	// once the log grows, only the new entries are framed
+	k.Set("later", []byte("x"))
+	k.Sync()
+	fi, _ = f.Stat()
+	if !ix.stillDescribes(f, fi) {
+		t.Fatal("the index no longer describes the appended log")
+	}
+	cr.n.Store(0)
+	if err := ix.extend(cr, fi.Size()); err != nil {
+		t.Fatal(err)
+	}
+	if read := cr.n.Load(); read > fi.Size()-ix.frames[len(ix.frames)-2].offset {
+		t.Errorf("extending the index read %d bytes", read)
+	}
+	if len(ix.frames) != 20002 {
+		t.Errorf("framed %d entries after an append, want 20002", len(ix.frames))
+	}
+	k.Close()
+}
+
+func TestReadLatestFollowsRewrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	k, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	check := func(want string) {
+		t.Helper()
+		if v, ok, err := ReadLatest(path, "a"); err != nil || !ok || string(v) != want {
+			t.Errorf("ReadLatest(a) = %q, %v, %v; want %q", v, ok, err, want)
+		}
+	}
+	k.Set("a", []byte("1"))
+	check("1")
+	k.Set("a", []byte("2")) // appended past the cached index
+	check("2")
+	k.Set("b", nil)
+	if err := k.Compact(); err != nil { // a new file
+		t.Fatal(err)
+	}
+	k.Set("a", []byte("3"))
+	check("3")
+	if err := k.Reset(); err != nil { // cut back and rewritten
+		t.Fatal(err)
+	}
+	k.Set("a", []byte("4"))
+	check("4")
+}
+
+func TestReadLatestChecksumMismatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	k, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.Set("a", []byte("1"))
+	off, _ := k.store.Size(path)
+	k.Set("b", []byte("2"))
+	k.Set("a", []byte("3"))
+	k.Close()
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a damaged newest write is skipped for the one before it
+	raw[len(raw)-1] ^= 0xff
+	if err := os.WriteFile(path, raw, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok, err := ReadLatest(path, "a"); err != nil || !ok || string(v) != "1" {
+		t.Errorf("ReadLatest(a) = %q, %v, %v; want the value before the damaged entry", v, ok, err)
+	}
+
+	// unlike replay, damage older than the write found does not hide it
+	raw[len(raw)-1] ^= 0xff
+	raw[off+8] ^= 0xff
+	if err := os.WriteFile(path, raw, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok, err := ReadLatest(path, "a"); err != nil || !ok || string(v) != "3" {
+		t.Errorf("ReadLatest(a) = %q, %v, %v; want the write after the damage", v, ok, err)
+	}
+	if _, ok, _ := ReadLatest(path, "b"); ok {
+		t.Error("ReadLatest returned the damaged entry")
+	}
+}
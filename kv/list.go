@@ -0,0 +1,123 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+)
+
+// A list value is listMagic followed by each element as
+// [4 bytes length][element bytes]. It is stored like any other value, so it
+// is logged, replayed and compacted the same way.
+const listMagic = "\x00GDL"
+
+// encodeListElem appends the encoding of elem to dst.
+func encodeListElem(dst, elem []byte) []byte {
+	dst = binary.BigEndian.AppendUint32(dst, uint32(len(elem)))
+	return append(dst, elem...)
+}
+
+// decodeList splits a list value into its elements, which alias val.
+func decodeList(val []byte) ([][]byte, error) {
+	if !bytes.HasPrefix(val, []byte(listMagic)) {
+		return nil, ErrNotList
+	}
+	var elems [][]byte
+	for off := len(listMagic); off < len(val); {
+		if off+4 > len(val) {
+			return nil, ErrNotList
+		}
+		n := int(binary.BigEndian.Uint32(val[off : off+4]))
+		off += 4
+		if off+n > len(val) {
+			return nil, ErrNotList
+		}
+		elems = append(elems, val[off:off+n])
+		off += n
+	}
+	return elems, nil
+}
+
+// list returns the elements of the list at key, none if the key is absent.
+// Callers must hold k.mu.
+func (k *KV) list(key string) ([][]byte, error) {
+	if k.closed {
+		return nil, ErrClosed
+	}
+	if !k.live(key) {
+		return nil, nil
+	}
+	return decodeList(k.data[key])
+}
+
+// ListPush appends value to the list stored at key, creating the list if
+// the key is absent. It fails with ErrNotList if key holds a value not
+// written by ListPush.
+//
+// The list is one value, so every push logs the whole list again: pushing
+// n elements writes O(n²) bytes until Compact. Keep lists short or use
+// separate keys (see Key) for long ones.
+func (k *KV) ListPush(key string, value []byte) error {
+	release, err := k.admit()
+	if err != nil {
+		return err
+	}
+	defer release()
+	if err := k.throttle(context.Background(), SetEntrySize(key, value)); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.checkWritable(); err != nil {
+		return err
+	}
+	cur := []byte(listMagic)
+	if k.live(key) {
+		cur = k.data[key]
+		if _, err := decodeList(cur); err != nil {
+			return err
+		}
+	}
+	next := make([]byte, 0, len(cur)+4+len(value))
+	next = encodeListElem(append(next, cur...), value)
+	return k.set(key, next)
+}
+
+// ListLen returns the number of elements in the list at key, zero if the
+// key is absent.
+func (k *KV) ListLen(key string) (int, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	elems, err := k.list(key)
+	return len(elems), err
+}
+
+// ListRange returns copies of the elements of the list at key from start to
+// stop inclusive. Negative indexes count from the end, so -1 is the last
+// element and ListRange(key, 0, -1) returns the whole list. Out-of-range
+// indexes are clamped; an empty range or absent key returns nothing.
+func (k *KV) ListRange(key string, start, stop int) ([][]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	elems, err := k.list(key)
+	if err != nil {
+		return nil, err
+	}
+	n := len(elems)
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	start = max(start, 0)
+	stop = min(stop, n-1)
+	if start > stop {
+		return nil, nil
+	}
+	out := make([][]byte, 0, stop-start+1)
+	for _, e := range elems[start : stop+1] {
+		out = append(out, append([]byte(nil), e...))
+	}
+	return out, nil
+}
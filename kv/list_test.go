@@ -0,0 +1,72 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	k, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	if n, err := k.ListLen("l"); err != nil || n != 0 {
+		t.Errorf("ListLen of an absent key = %d, %v", n, err)
+	}
+	for _, v := range []string{"a", "b", "", "d", "e"} {
+		if err := k.ListPush("l", []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func() {
+		t.Helper()
+		if n, err := k.ListLen("l"); err != nil || n != 5 {
+			t.Errorf("ListLen = %d, %v; want 5", n, err)
+		}
+		for _, tc := range []struct {
+			start, stop int
+			want        string
+		}{
+			{0, -1, "[a b  d e]"},
+			{1, 2, "[b ]"},
+			{-2, -1, "[d e]"},
+			{-100, 0, "[a]"},
+			{3, 100, "[d e]"},
+			{-1, -2, "[]"},
+			{5, 9, "[]"},
+		} {
+			elems, err := k.ListRange("l", tc.start, tc.stop)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fmt.Sprintf("%s", elems); got != tc.want {
+				t.Errorf("ListRange(%d, %d) = %s, want %s", tc.start, tc.stop, got, tc.want)
+			}
+		}
+	}
+	check()
+
+	// the list is an ordinary logged value
+	k.Close()
+	k, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	check()
+
+	k.Set("plain", []byte("not a list"))
+	if err := k.ListPush("plain", []byte("x")); !errors.Is(err, ErrNotList) {
+		t.Errorf("ListPush onto a plain value = %v, want ErrNotList", err)
+	}
+	if _, err := k.ListRange("plain", 0, -1); !errors.Is(err, ErrNotList) {
+		t.Errorf("ListRange of a plain value = %v, want ErrNotList", err)
+	}
+	if v, _ := k.Get("plain"); string(v) != "not a list" {
+		t.Errorf("failed ListPush changed the value to %q", v)
+	}
+}
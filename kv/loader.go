@@ -0,0 +1,62 @@
+package kv
+
+// Loader fetches the value of a key missing from the KV, for WithLoader.
+// found is false if the source does not have the key either.
+type Loader func(key string) (value []byte, found bool, err error)
+
+// WithLoader makes the KV a read-through cache: when Get misses, load is
+// called for the key and a value it finds is stored with Set and returned.
+// Concurrent misses on the same key share a single call. A miss at the
+// source or an error from load is reported by Get as a miss and nothing is
+// stored, so the next Get calls load again. A value that cannot be stored,
+// for example on a standby, is still returned.
+func WithLoader(load Loader) Option {
+	return func(o *options) {
+		o.loader = load
+	}
+}
+
+// loadCall is a load in progress; callers that miss on the same key wait
+// for it instead of calling the Loader themselves.
+type loadCall struct {
+	done  chan struct{}
+	value []byte
+	found bool
+}
+
+// load runs the Loader for key, or waits for a run already in progress,
+// and returns a copy of the result.
+func (k *KV) load(key string) ([]byte, bool) {
+	k.loadMu.Lock()
+	if c, ok := k.loads[key]; ok {
+		k.loadMu.Unlock()
+		<-c.done
+		return append([]byte(nil), c.value...), c.found
+	}
+	c := &loadCall{done: make(chan struct{})}
+	if k.loads == nil {
+		k.loads = make(map[string]*loadCall)
+	}
+	k.loads[key] = c
+	k.loadMu.Unlock()
+
+	defer func() {
+		k.loadMu.Lock()
+		delete(k.loads, key)
+		k.loadMu.Unlock()
+		close(c.done)
+	}()
+	// a load that finished just before this one started may have stored it
+	val, ok, closed := k.get(key)
+	if ok || closed {
+		c.value, c.found = val, ok
+		return append([]byte(nil), val...), ok
+	}
+	val, found, err := k.opts.loader(key)
+	if err != nil || !found {
+		return nil, false
+	}
+	c.value, c.found = append([]byte(nil), val...), true
+	_ = k.Set(key, val)
+	return append([]byte(nil), val...), true
+}
@@ -0,0 +1,83 @@
+package kv
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoaderCoalescesMisses(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	load := func(key string) ([]byte, bool, error) {
+		calls.Add(1)
+		<-release
+		return []byte("loaded " + key), true, nil
+	}
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"), WithLoader(load))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+
+	var wg sync.WaitGroup
+	results := make([]string, 20)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, _ := k.Get("x")
+			results[i] = string(v)
+		}()
+	}
+	for calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond) // let the other misses join the load
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("the loader ran %d times, want once", n)
+	}
+	for i, v := range results {
+		if v != "loaded x" {
+			t.Errorf("Get %d = %q, want the loaded value", i, v)
+		}
+	}
+	// the value was stored, so later Gets are hits
+	if v, _ := k.Get("x"); string(v) != "loaded x" || calls.Load() != 1 {
+		t.Errorf("Get after the load = %q with %d loader calls", v, calls.Load())
+	}
+}
+
+func TestLoaderMisses(t *testing.T) {
+	var calls int
+	var result error
+	load := func(key string) ([]byte, bool, error) {
+		calls++
+		return nil, false, result
+	}
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"), WithLoader(load))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+
+	// neither a miss at the source nor an error is stored
+	for _, err := range []error{nil, errors.New("source down"), nil} {
+		result = err
+		if v, ok := k.Get("x"); ok {
+			t.Errorf("Get(x) = %q with the loader returning %v", v, err)
+		}
+	}
+	if calls != 3 {
+		t.Errorf("the loader ran %d times, want once per Get", calls)
+	}
+	if n := k.Stats().Keys; n != 0 {
+		t.Errorf("%d keys stored after misses", n)
+	}
+}
@@ -1,11 +1,16 @@
 package kv
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"hash/crc32"
 	"io"
 	"os"
+	"syscall"
+	"time"
 )
 
 // EntryType stored in the first payload byte
@@ -14,29 +19,78 @@ type EntryType uint8
 const (
 	OpSet EntryType = 1
 	OpDel EntryType = 2
+	// OpBatchBegin and OpBatchCommit bracket the entries of a WriteBatch.
+	OpBatchBegin  EntryType = 3
+	OpBatchCommit EntryType = 4
+	// OpChunk carries one piece of a value too large for a single entry.
+	OpChunk EntryType = 5
+	// OpExpire sets the expiry time of the key written just before it.
+	OpExpire EntryType = 6
+	// OpPad fills space up to a block boundary and is skipped on replay.
+	OpPad EntryType = 7
+	// OpAlias makes one key resolve to another, see SetAlias.
+	OpAlias EntryType = 8
+	// OpBlob stores a value shared by several keys once, and OpRef sets a
+	// key to a blob's value; see WithDedupValues.
+	OpBlob EntryType = 9
+	OpRef  EntryType = 10
 )
 
-// writeLogEntry writes: [4 bytes length][4 bytes crc32][payload bytes]
-// It fsyncs the file after write to make the append durable.
-func writeLogEntry(f *os.File, payload []byte) error {
-	// build header
+// appendLogEntry appends the frame
+// [4 bytes length][4 bytes crc32][payload bytes] to dst, in lf's byte
+// order.
+func appendLogEntry(dst []byte, lf logFormat, payload []byte) []byte {
+	order := lf.order()
+	payload = transcodePayload(payload, binary.BigEndian, order)
 	var hdr [8]byte
-	binary.BigEndian.PutUint32(hdr[0:4], uint32(len(payload)))
-	crc := crc32.ChecksumIEEE(payload)
-	binary.BigEndian.PutUint32(hdr[4:8], crc)
+	order.PutUint32(hdr[0:4], uint32(len(payload)))
+	order.PutUint32(hdr[4:8], crc32.Checksum(payload, lf.checksum.table()))
+	dst = append(dst, hdr[:]...)
+	return append(dst, payload...)
+}
 
-	// write header + payload
-	if _, err := f.Write(hdr[:]); err != nil {
-		return err
+// appendEntries appends the frames for payloads as one unit to dst: a lone
+// entry as-is, several wrapped in batch markers so replay applies all or
+// none.
+func appendEntries(dst []byte, lf logFormat, payloads [][]byte) []byte {
+	if len(payloads) > 1 {
+		dst = appendLogEntry(dst, lf, buildBatchBeginPayload())
 	}
-	if _, err := f.Write(payload); err != nil {
-		return err
+	for _, payload := range payloads {
+		dst = appendLogEntry(dst, lf, payload)
 	}
-	// durable write
-	if err := f.Sync(); err != nil {
-		return err
+	if len(payloads) > 1 {
+		dst = appendLogEntry(dst, lf, buildBatchCommitPayload(len(payloads)))
 	}
-	return nil
+	return dst
+}
+
+// appendPadding appends to dst a padding entry taking a log that ends at off
+// up to the next multiple of align. A gap too small for an entry frame is
+// widened by a whole block.
+func appendPadding(dst []byte, lf logFormat, off int64, align int) []byte {
+	if align <= 0 || off%int64(align) == 0 {
+		return dst
+	}
+	gap := int(int64(align) - off%int64(align))
+	for gap < 8+1 {
+		gap += align
+	}
+	payload := make([]byte, gap-8)
+	payload[0] = byte(OpPad)
+	return appendLogEntry(dst, lf, payload)
+}
+
+// SetEntrySize returns how many bytes Set(key, value) appends to the log,
+// frame header included. Values split by WithChunkSize take more.
+func SetEntrySize(key string, value []byte) int {
+	return 8 + 1 + 4 + len(key) + 4 + len(value)
+}
+
+// DelEntrySize returns how many bytes Del(key) appends to the log, frame
+// header included.
+func DelEntrySize(key string) int {
+	return 8 + 1 + 4 + len(key)
 }
 
 func buildSetPayload(key, value []byte) []byte {
@@ -49,6 +103,37 @@ func buildSetPayload(key, value []byte) []byte {
 	return buf.Bytes()
 }
 
+// buildSetPayloads returns the payloads that set key to value: one set
+// entry, or chunk entries when chunkSize > 0 and the value is longer.
+func buildSetPayloads(key string, value []byte, chunkSize int) [][]byte {
+	if chunkSize <= 0 || len(value) <= chunkSize {
+		return [][]byte{buildSetPayload([]byte(key), value)}
+	}
+	var payloads [][]byte
+	for off := 0; off < len(value); off += chunkSize {
+		part := value[off:min(off+chunkSize, len(value))]
+		payloads = append(payloads, buildChunkPayload([]byte(key), part, off == 0))
+	}
+	return payloads
+}
+
+// buildChunkPayload encodes [type][first flag][key len][key][part len][part].
+// A chunk with the first flag starts the value; the rest append to it.
+func buildChunkPayload(key, part []byte, first bool) []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteByte(byte(OpChunk))
+	if first {
+		buf.WriteByte(1)
+	} else {
+		buf.WriteByte(0)
+	}
+	_ = binary.Write(buf, binary.BigEndian, uint32(len(key)))
+	buf.Write(key)
+	_ = binary.Write(buf, binary.BigEndian, uint32(len(part)))
+	buf.Write(part)
+	return buf.Bytes()
+}
+
 func buildDelPayload(key []byte) []byte {
 	buf := &bytes.Buffer{}
 	buf.WriteByte(byte(OpDel))
@@ -57,32 +142,203 @@ func buildDelPayload(key []byte) []byte {
 	return buf.Bytes()
 }
 
-// readLog reads entries until a truncated/corrupted entry is encountered.
-// It returns a slice of payloads (each payload begins with the entry type byte).
-func readLog(f *os.File) ([][]byte, error) {
-	var results [][]byte
+func buildBatchBeginPayload() []byte {
+	return []byte{byte(OpBatchBegin)}
+}
+
+// buildBatchCommitPayload records how many entries the batch holds so replay
+// can tell a complete batch from a damaged one.
+func buildBatchCommitPayload(count int) []byte {
+	buf := make([]byte, 5)
+	buf[0] = byte(OpBatchCommit)
+	binary.BigEndian.PutUint32(buf[1:5], uint32(count))
+	return buf
+}
+
+// decodeSet parses a set payload; the returned value aliases payload.
+func decodeSet(payload []byte) (string, []byte, error) {
+	off := 1
+	if off+4 > len(payload) {
+		return "", nil, fmt.Errorf("%w: set entry", ErrMalformedEntry)
+	}
+	klen := int(binary.BigEndian.Uint32(payload[off : off+4]))
+	off += 4
+	if off+klen > len(payload) {
+		return "", nil, fmt.Errorf("%w: set entry key", ErrMalformedEntry)
+	}
+	key := string(payload[off : off+klen])
+	off += klen
+
+	if off+4 > len(payload) {
+		return "", nil, fmt.Errorf("%w: set entry value length", ErrMalformedEntry)
+	}
+	vlen := int(binary.BigEndian.Uint32(payload[off : off+4]))
+	off += 4
+	if off+vlen > len(payload) {
+		return "", nil, fmt.Errorf("%w: set entry value", ErrMalformedEntry)
+	}
+	return key, payload[off : off+vlen], nil
+}
+
+// decodeChunk parses a chunk payload; the returned part aliases payload.
+func decodeChunk(payload []byte) (key string, part []byte, first bool, err error) {
+	if len(payload) < 2 {
+		return "", nil, false, fmt.Errorf("%w: chunk entry", ErrMalformedEntry)
+	}
+	// after the flag byte the layout matches a set entry's
+	key, part, err = decodeSet(payload[1:])
+	if err != nil {
+		return "", nil, false, fmt.Errorf("%w: chunk entry", ErrMalformedEntry)
+	}
+	return key, part, payload[1] == 1, nil
+}
+
+func decodeDel(payload []byte) (string, error) {
+	off := 1
+	if off+4 > len(payload) {
+		return "", fmt.Errorf("%w: del entry", ErrMalformedEntry)
+	}
+	klen := int(binary.BigEndian.Uint32(payload[off : off+4]))
+	off += 4
+	if off+klen > len(payload) {
+		return "", fmt.Errorf("%w: del entry key", ErrMalformedEntry)
+	}
+	return string(payload[off : off+klen]), nil
+}
+
+// logEntry is a payload read back from the log together with the file
+// offset of its frame. groupStart marks the first entry of a write: a lone
+// entry, or the first member of a batch.
+type logEntry struct {
+	offset     int64
+	payload    []byte
+	groupStart bool
+}
+
+// readLog reads entries from r, which starts at file offset off, until a truncated/corrupted entry is encountered.
+// It returns the entries in log order (each payload begins with the entry type byte).
+// Entries inside a batch are only returned once their commit marker has been
+// read, and the markers themselves are dropped. end is the file offset just
+// past the last entry that was returned or committed; anything after it is a
+// torn tail or an unterminated batch, and tail is a *CorruptionError saying
+// which (nil when the log ends cleanly). With verify false the CRC comparison
+// is skipped and entries are framed by their declared length alone.
+func readLog(r io.Reader, off int64, lf logFormat, verify bool) (results []logEntry, end int64, tail error, err error) {
+	br := bufio.NewReader(r)
+	end = off
+	var batch []logEntry
+	batchOff := int64(0)
+	inBatch := false
+	stop := func(at int64, why error) ([]logEntry, int64, error, error) {
+		return results, end, &CorruptionError{Offset: at, Err: why}, nil
+	}
 	for {
 		var hdr [8]byte
-		if _, err := io.ReadFull(f, hdr[:]); err != nil {
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
 			// truncated header or EOF -> stop replay gracefully
-			if err == io.EOF || err == io.ErrUnexpectedEOF {
-				return results, nil
+			if err == io.EOF {
+				if inBatch {
+					return stop(batchOff, ErrUnterminatedBatch)
+				}
+				return results, end, nil, nil
 			}
-			return results, err
+			if err == io.ErrUnexpectedEOF {
+				return stop(off, ErrTruncatedEntry)
+			}
+			return results, end, nil, err
 		}
-		size := binary.BigEndian.Uint32(hdr[0:4])
-		expectedCrc := binary.BigEndian.Uint32(hdr[4:8])
+		size := lf.order().Uint32(hdr[0:4])
+		expectedCrc := lf.order().Uint32(hdr[4:8])
 
 		payload := make([]byte, size)
-		if _, err := io.ReadFull(f, payload); err != nil {
+		if _, err := io.ReadFull(br, payload); err != nil {
 			// truncated payload -> stop replay
-			return results, nil
+			return stop(off, ErrTruncatedEntry)
 		}
-		crc := crc32.ChecksumIEEE(payload)
-		if crc != expectedCrc {
+		if verify && crc32.Checksum(payload, lf.checksum.table()) != expectedCrc {
 			// checksum mismatch -> stop replay
-			return results, nil
+			return stop(off, ErrChecksumMismatch)
+		}
+		payload = transcodePayload(payload, lf.order(), binary.BigEndian)
+		entry := logEntry{offset: off, payload: payload}
+		off += 8 + int64(size)
+
+		if len(payload) > 0 {
+			switch EntryType(payload[0]) {
+			case OpPad:
+				if !inBatch {
+					end = off
+				}
+				continue
+			case OpBatchBegin:
+				if inBatch {
+					// a batch that never committed -> stop replay
+					return stop(batchOff, ErrUnterminatedBatch)
+				}
+				inBatch = true
+				batchOff = entry.offset
+				batch = batch[:0]
+				continue
+			case OpBatchCommit:
+				if !inBatch || len(payload) < 5 ||
+					int(binary.BigEndian.Uint32(payload[1:5])) != len(batch) {
+					// commit that does not match its batch -> stop replay
+					return stop(entry.offset, ErrMalformedEntry)
+				}
+				results = append(results, batch...)
+				inBatch = false
+				end = off
+				continue
+			}
 		}
-		results = append(results, payload)
+		if inBatch {
+			entry.groupStart = len(batch) == 0
+			batch = append(batch, entry)
+			continue
+		}
+		entry.groupStart = true
+		results = append(results, entry)
+		end = off
+	}
+}
+
+// syncDir fsyncs a directory so renames and creates inside it are durable.
+// It is a variable so tests can observe the calls.
+var syncDir = func(dir string) error {
+	df, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := df.Sync(); err != nil {
+		df.Close()
+		return err
+	}
+	return df.Close()
+}
+
+// syncWithRetry calls sync, retrying up to retries more times with doubling
+// backoff while it fails with an interrupted or temporarily unavailable
+// error. Anything else, such as ENOSPC or EIO, is returned immediately.
+func syncWithRetry(sync func() error, retries int, backoff time.Duration) error {
+	for attempt := 0; ; attempt++ {
+		err := sync()
+		if err == nil || attempt >= retries || !retryableSyncErr(err) {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func retryableSyncErr(err error) bool {
+	return errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.EAGAIN)
+}
+
+// noSpace wraps err in ErrNoSpace if it says the disk or quota is full,
+// keeping the original error matchable too.
+func noSpace(err error) error {
+	if errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT) {
+		return fmt.Errorf("%w: %w", ErrNoSpace, err)
 	}
+	return err
 }
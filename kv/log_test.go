@@ -0,0 +1,263 @@
+package kv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestEntrySizeMatchesFileGrowth(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	k, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	size := func() int64 {
+		t.Helper()
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fi.Size()
+	}
+	for _, tc := range []struct{ key, value string }{
+		{"a", "1"},
+		{"longer key", "a somewhat longer value"},
+		{"empty", ""},
+	} {
+		before := size()
+		k.Set(tc.key, []byte(tc.value))
+		if got, want := size()-before, int64(SetEntrySize(tc.key, []byte(tc.value))); got != want {
+			t.Errorf("Set(%q) grew the log by %d, SetEntrySize says %d", tc.key, got, want)
+		}
+		before = size()
+		k.Del(tc.key)
+		if got, want := size()-before, int64(DelEntrySize(tc.key)); got != want {
+			t.Errorf("Del(%q) grew the log by %d, DelEntrySize says %d", tc.key, got, want)
+		}
+	}
+}
+
+func TestChunkedValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	k, err := Create(path, WithChunkSize(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	big := make([]byte, 1050)
+	for i := range big {
+		big[i] = byte(i)
+	}
+	k.Set("big", []byte("small first"))
+	k.Set("big", big)
+	if v, _ := k.Get("big"); !bytes.Equal(v, big) {
+		t.Error("Get after a chunked Set differs")
+	}
+	k.Close()
+
+	// chunked logs open without the option too
+	k, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := k.Get("big"); !bytes.Equal(v, big) {
+		t.Error("reassembled value differs after reopen")
+	}
+	k.Close()
+
+	// a crash midway through the chunks loses the whole value
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, fi.Size()-500); err != nil {
+		t.Fatal(err)
+	}
+	k, err = Open(path, WithChunkSize(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	if v, _ := k.Get("big"); string(v) != "small first" {
+		t.Errorf("Get after a torn chunked write = %q, want the previous value", v)
+	}
+	if !k.OpenInfo().TruncatedTail {
+		t.Error("torn chunks not reported as a truncated tail")
+	}
+}
+
+// flakySyncStorage fails the next failures Syncs with err.
+type flakySyncStorage struct {
+	Storage
+	err      error
+	failures int
+	calls    int
+}
+
+func (s *flakySyncStorage) Sync(name string) error {
+	s.calls++
+	if s.failures > 0 {
+		s.failures--
+		return s.err
+	}
+	return s.Storage.Sync(name)
+}
+
+func TestSyncRetry(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		err       error
+		failures  int
+		wantErr   bool
+		wantCalls int
+	}{
+		{"transient", syscall.EINTR, 2, false, 3},
+		{"exhausted", syscall.EAGAIN, 5, true, 3},
+		{"permanent", syscall.ENOSPC, 1, true, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &flakySyncStorage{Storage: NewMemoryStorage()}
+			k, err := Create("a.log", WithStorage(s), WithSyncRetry(2, time.Microsecond))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer k.Close()
+			s.err, s.failures, s.calls = tc.err, tc.failures, 0
+			err = k.Set("a", []byte("1"))
+			if (err != nil) != tc.wantErr || (err != nil && !errors.Is(err, tc.err)) {
+				t.Errorf("Set = %v, want error %v: %v", err, tc.wantErr, tc.err)
+			}
+			if s.calls != tc.wantCalls {
+				t.Errorf("Sync called %d times, want %d", s.calls, tc.wantCalls)
+			}
+		})
+	}
+}
+
+func TestCreateSyncsDirectory(t *testing.T) {
+	var synced []string
+	orig := syncDir
+	syncDir = func(dir string) error {
+		synced = append(synced, dir)
+		return orig(dir)
+	}
+	defer func() { syncDir = orig }()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.log")
+	k, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.Close()
+	if len(synced) == 0 || synced[0] != dir {
+		t.Fatalf("Create synced %q, want %q", synced, dir)
+	}
+
+	// reopening an existing log creates no directory entry
+	synced = nil
+	k, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.Close()
+	if len(synced) != 0 {
+		t.Errorf("Open synced %q", synced)
+	}
+
+	// a failed directory sync fails the create
+	syncDir = func(string) error { return errInjected }
+	if _, err := Create(filepath.Join(dir, "b.log")); !errors.Is(err, errInjected) {
+		t.Errorf("Create with a failing directory sync = %v", err)
+	}
+}
+
+func TestBlockAlignment(t *testing.T) {
+	const block = 512
+	path := filepath.Join(t.TempDir(), "a.log")
+	k, err := Create(path, WithBlockAlignment(block))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b Batch
+	b.Set("x", bytes.Repeat([]byte("x"), 700))
+	b.Del("y")
+	writes := []func() error{
+		func() error { return k.Set("a", []byte("1")) },
+		func() error { return k.Set("b", bytes.Repeat([]byte("b"), block-20)) },
+		func() error { return k.WriteBatch(&b) },
+		func() error { return k.Del("a") },
+		func() error { return k.Set("c", []byte("3")) },
+	}
+	var sizes []int64
+	for i, w := range writes {
+		if err := w(); err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Size()%block != 0 {
+			t.Errorf("write %d ends at %d, not on a block boundary", i, fi.Size())
+		}
+		sizes = append(sizes, fi.Size())
+	}
+	k.WalkLog(func(off int64, typ EntryType, key string, _ []byte) error {
+		if typ == OpPad {
+			t.Errorf("WalkLog reported padding at %d", off)
+		}
+		return nil
+	})
+	k.Close()
+
+	// a torn last write leaves the earlier ones and their padding intact
+	if err := os.Truncate(path, sizes[3]+10); err != nil {
+		t.Fatal(err)
+	}
+	k, err = Open(path, WithBlockAlignment(block))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	if got := fmt.Sprint(k.Keys()); got != "[b x]" {
+		t.Errorf("Keys after replay = %s, want [b x]", got)
+	}
+	if v, _ := k.Get("x"); len(v) != 700 {
+		t.Errorf("x has %d bytes, want 700", len(v))
+	}
+	if info := k.OpenInfo(); !info.TruncatedTail {
+		t.Error("torn write not reported")
+	}
+}
+
+func TestSyncEveryCadence(t *testing.T) {
+	s := &flakySyncStorage{Storage: NewMemoryStorage()}
+	k, err := Create("a.log", WithStorage(s), WithSyncEvery(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.calls = 0
+	for i := 1; i <= 7; i++ {
+		k.Set("k", []byte{byte(i)})
+		if want := i / 3; s.calls != want {
+			t.Errorf("after %d writes: %d syncs, want %d", i, s.calls, want)
+		}
+	}
+	if err := k.Sync(); err != nil || s.calls != 3 {
+		t.Errorf("Sync = %v with %d syncs, want the remainder flushed (3)", err, s.calls)
+	}
+	if k.Sync(); s.calls != 3 {
+		t.Error("Sync with nothing outstanding synced again")
+	}
+	k.Set("k", nil)
+	if err := k.Close(); err != nil || s.calls != 4 {
+		t.Errorf("Close = %v with %d syncs, want the last write flushed (4)", err, s.calls)
+	}
+}
@@ -0,0 +1,181 @@
+package kv
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// Every durable write (a Set, Del, WriteBatch, SetWithTTL, Reset, ...) is
+// assigned the next log sequence number. LSNs are not stored per entry:
+// the log header records the LSN before its first write and replay counts
+// writes from there. Compact and Checkpoint set that base so the latest LSN
+// survives a rewrite, but keys carried over are renumbered in the rewritten
+// file, so after reopening GetWithLSN can report a smaller LSN than before
+// for a key that has not changed. Legacy headerless logs restart numbering
+// at zero when compacted.
+
+// LastLSN returns the LSN of the most recent write, or zero if there has
+// been none. It increases by one with each durable write and is preserved
+// across reopen. On a standby it tracks the writes applied so far.
+func (k *KV) LastLSN() uint64 {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.lsn
+}
+
+// GetWithLSN returns a copy of key's value and the LSN of the write that
+// set it.
+func (k *KV) GetWithLSN(key string) (value []byte, lsn uint64, ok bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.closed {
+		return nil, 0, false
+	}
+	if key, ok = k.resolveLive(key); !ok {
+		return nil, 0, false
+	}
+	return append([]byte(nil), k.data[key]...), k.meta[key].lsn, true
+}
+
+// GetWithToken is GetWithLSN for optimistic locking: token identifies the
+// version of key that was read and can be handed to SetWithToken later. It
+// is zero for a missing key. Unlike GetWithLSN it does not follow aliases:
+// SetWithToken writes key itself, replacing an alias, so an alias reads as
+// missing with token zero.
+func (k *KV) GetWithToken(key string) (value []byte, token uint64, ok bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.closed || !k.live(key) {
+		return nil, 0, false
+	}
+	return append([]byte(nil), k.data[key]...), k.meta[key].lsn, true
+}
+
+// SetWithToken sets key to value only if the key's LSN still equals token,
+// that is if nothing has written it since GetWithToken returned token. A
+// token of zero means the key must not exist. The result reports whether
+// the write happened. A Compact renumbers keys on the next open, so a token
+// taken before then is rejected even if the key is unchanged; callers
+// should read again and retry.
+func (k *KV) SetWithToken(key string, value []byte, token uint64) (bool, error) {
+	release, err := k.admit()
+	if err != nil {
+		return false, err
+	}
+	defer release()
+	if err := k.throttle(context.Background(), SetEntrySize(key, value)); err != nil {
+		return false, err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.checkWritable(); err != nil {
+		return false, err
+	}
+	var current uint64
+	if k.live(key) {
+		current = k.meta[key].lsn
+	}
+	if current != token {
+		return false, nil
+	}
+	if err := k.set(key, value); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// applyEntry applies one replayed entry, advancing the LSN at the start of
+// each write. Callers must hold k.mu for writing.
+func (k *KV) applyEntry(e logEntry) error {
+	if e.groupStart {
+		k.lsn++
+	}
+	if len(e.payload) > 0 && EntryType(e.payload[0]) == OpDel {
+		k.tombstones++
+	}
+	return k.apply(e.payload)
+}
+
+// rewriteBaseLSN is the header base LSN for a file that rewrites the
+// current state as n writes, chosen so replaying it ends at the current
+// LSN. Callers must hold k.mu.
+func (k *KV) rewriteBaseLSN(n int) uint64 {
+	if uint64(n) > k.lsn {
+		return 0
+	}
+	return k.lsn - uint64(n)
+}
+
+// ChangedSince returns, sorted, the live keys whose latest write has an LSN
+// greater than lsn, so a client that remembers LastLSN can pull just the
+// keys set since. Deleted keys are not reported. After a reopen following
+// Compact or Checkpoint, renumbered keys may be reported even though they
+// did not change, so treat the result as a superset.
+func (k *KV) ChangedSince(lsn uint64) []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.closed {
+		return nil
+	}
+	var keys []string
+	for key, m := range k.meta {
+		if m.lsn > lsn && !k.expired(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WaitDurable blocks until the write with LSN lsn, and every write before
+// it, has been fsynced, or ctx is done. Writes are durable when they return
+// unless WithSyncEvery or WithNoSync defers the fsync; then a caller can
+// take LastLSN (or GetWithLSN) after writing and wait here before
+// acknowledging. It returns ErrClosed if the KV is closed first; Close
+// syncs whatever is outstanding, so a write already durable by then still
+// returns nil.
+func (k *KV) WaitDurable(lsn uint64, ctx context.Context) error {
+	for {
+		k.mu.RLock()
+		durable, ch, closed := k.durableLSN, k.durableCh, k.closed
+		k.mu.RUnlock()
+		if durable >= lsn {
+			return nil
+		}
+		if closed {
+			return ErrClosed
+		}
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// DurabilityLag returns how long ago the log was last fsynced and how many
+// writes have been appended since, or zero and zero when every write is
+// durable, as is always the case without WithSyncEvery or WithNoSync. A
+// lag that keeps growing means writes are piling up unsynced.
+func (k *KV) DurabilityLag() (lag time.Duration, unsynced int) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.unsynced == 0 {
+		return 0, 0
+	}
+	return k.opts.clock.Now().Sub(k.syncedAt), k.unsynced
+}
+
+// markDurable records that every write so far is synced and wakes
+// WaitDurable callers. Callers must hold k.mu for writing.
+func (k *KV) markDurable() {
+	k.unsynced = 0
+	k.syncedAt = k.opts.clock.Now()
+	if k.durableLSN == k.lsn {
+		return
+	}
+	k.durableLSN = k.lsn
+	close(k.durableCh)
+	k.durableCh = make(chan struct{})
+}
@@ -0,0 +1,316 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLSNs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	k, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	if n := k.LastLSN(); n != 0 {
+		t.Errorf("LastLSN of a new database = %d", n)
+	}
+	var b Batch
+	b.Set("c", nil)
+	b.Set("d", nil)
+	writes := []func() error{
+		func() error { return k.Set("a", []byte("1")) },
+		func() error { return k.Set("b", []byte("2")) },
+		func() error { return k.Del("b") },
+		func() error { return k.WriteBatch(&b) },
+		func() error { return k.SetWithTTL("e", nil, time.Hour) },
+		func() error { return k.Set("a", []byte("3")) },
+	}
+	for i, w := range writes {
+		if err := w(); err != nil {
+			t.Fatal(err)
+		}
+		if n := k.LastLSN(); n != uint64(i+1) {
+			t.Errorf("LastLSN after write %d = %d, want %d", i+1, n, i+1)
+		}
+	}
+	want := map[string]uint64{"a": 6, "c": 4, "d": 4, "e": 5}
+	check := func() {
+		t.Helper()
+		for key, lsn := range want {
+			if _, got, ok := k.GetWithLSN(key); !ok || got != lsn {
+				t.Errorf("GetWithLSN(%s) = %d, %v; want %d", key, got, ok, lsn)
+			}
+		}
+		if _, _, ok := k.GetWithLSN("b"); ok {
+			t.Error("GetWithLSN found a deleted key")
+		}
+	}
+	check()
+
+	k.Close()
+	k, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	if n := k.LastLSN(); n != 6 {
+		t.Errorf("LastLSN after reopen = %d, want 6", n)
+	}
+	check()
+	k.Set("f", nil)
+	if _, lsn, _ := k.GetWithLSN("f"); lsn != 7 {
+		t.Errorf("first write after reopen got LSN %d, want 7", lsn)
+	}
+}
+
+func TestChangedSince(t *testing.T) {
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	for _, key := range []string{"a", "b", "c", "d"} {
+		k.Set(key, []byte("old"))
+	}
+	mark := k.LastLSN()
+	if got := k.ChangedSince(mark); len(got) != 0 {
+		t.Errorf("ChangedSince the last LSN = %q", got)
+	}
+
+	k.Set("c", []byte("new"))
+	k.Set("a", []byte("new"))
+	k.Set("e", []byte("new"))
+	k.Del("b")
+	k.SetWithTTL("d", []byte("gone"), -time.Second)
+	if got := fmt.Sprint(k.ChangedSince(mark)); got != "[a c e]" {
+		t.Errorf("ChangedSince = %s, want [a c e]", got)
+	}
+	if got := fmt.Sprint(k.ChangedSince(0)); got != "[a c e]" {
+		t.Errorf("ChangedSince(0) = %s, want every live key", got)
+	}
+}
+
+func TestWaitDurable(t *testing.T) {
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"), WithSyncEvery(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	k.Set("a", []byte("1"))
+	lsn := k.LastLSN()
+	if lsn != 1 {
+		t.Fatalf("LastLSN = %d, want 1", lsn)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := k.WaitDurable(lsn, ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("WaitDurable before a sync = %v, want the deadline", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- k.WaitDurable(lsn, context.Background()) }()
+	select {
+	case err := <-done:
+		t.Fatalf("WaitDurable returned %v before the sync", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if err := k.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("WaitDurable after the sync = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("WaitDurable still blocked after the sync")
+	}
+	if err := k.WaitDurable(lsn, context.Background()); err != nil {
+		t.Errorf("WaitDurable for a synced LSN = %v", err)
+	}
+
+	// Close syncs what is outstanding, releasing waiters with nil
+	k.Set("b", []byte("2"))
+	go func() { done <- k.WaitDurable(k.LastLSN(), context.Background()) }()
+	time.Sleep(10 * time.Millisecond)
+	k.Close()
+	if err := <-done; err != nil {
+		t.Errorf("WaitDurable across Close = %v, want nil", err)
+	}
+	if err := k.WaitDurable(lsn+5, context.Background()); !errors.Is(err, ErrClosed) {
+		t.Errorf("WaitDurable for a future LSN after Close = %v, want ErrClosed", err)
+	}
+}
+
+func TestDurabilityLag(t *testing.T) {
+	clock := newFakeClock()
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"), WithClock(clock), WithSyncEvery(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	check := func(wantLag time.Duration, wantUnsynced int) {
+		t.Helper()
+		if lag, n := k.DurabilityLag(); lag != wantLag || n != wantUnsynced {
+			t.Errorf("DurabilityLag = %v, %d; want %v, %d", lag, n, wantLag, wantUnsynced)
+		}
+	}
+	check(0, 0)
+	k.Set("a", nil)
+	check(0, 1)
+	clock.Advance(5 * time.Second)
+	check(5*time.Second, 1)
+	k.Set("b", nil)
+	clock.Advance(2 * time.Second)
+	check(7*time.Second, 2)
+	k.Set("c", nil) // the third write syncs
+	check(0, 0)
+
+	k.Set("d", nil)
+	clock.Advance(time.Second)
+	check(time.Second, 1)
+	if err := k.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	check(0, 0)
+
+	// every write is durable without WithSyncEvery
+	k2, err := Create(filepath.Join(t.TempDir(), "b.log"), WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k2.Close()
+	k2.Set("a", nil)
+	clock.Advance(time.Minute)
+	if lag, n := k2.DurabilityLag(); lag != 0 || n != 0 {
+		t.Errorf("DurabilityLag with a sync per write = %v, %d", lag, n)
+	}
+}
+
+func TestSetWithToken(t *testing.T) {
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+
+	// token zero creates a missing key, once
+	if _, token, ok := k.GetWithToken("a"); ok || token != 0 {
+		t.Errorf("GetWithToken of a missing key = %d, %v", token, ok)
+	}
+	if done, err := k.SetWithToken("a", []byte("1"), 0); err != nil || !done {
+		t.Fatalf("SetWithToken creating a = %v, %v", done, err)
+	}
+	if done, _ := k.SetWithToken("a", []byte("x"), 0); done {
+		t.Error("token zero overwrote an existing key")
+	}
+
+	v, token, ok := k.GetWithToken("a")
+	if !ok || string(v) != "1" || token == 0 {
+		t.Fatalf("GetWithToken(a) = %q, %d, %v", v, token, ok)
+	}
+	// a fresh token succeeds and moves the token on
+	if done, err := k.SetWithToken("a", []byte("2"), token); err != nil || !done {
+		t.Fatalf("SetWithToken with a fresh token = %v, %v", done, err)
+	}
+	// the old token is now stale
+	if done, _ := k.SetWithToken("a", []byte("3"), token); done {
+		t.Error("SetWithToken accepted a stale token")
+	}
+	_, token, _ = k.GetWithToken("a")
+
+	// so is a token taken before any other write of the key, even one
+	// rewriting the same value
+	k.Set("a", []byte("2"))
+	if done, _ := k.SetWithToken("a", []byte("3"), token); done {
+		t.Error("SetWithToken accepted a token from before a Set")
+	}
+	_, token, _ = k.GetWithToken("a")
+	k.Del("a")
+	if done, _ := k.SetWithToken("a", []byte("3"), token); done {
+		t.Error("SetWithToken accepted a token from before a Del")
+	}
+	if v, _ := k.Get("a"); v != nil {
+		t.Errorf("Get(a) = %q after rejected writes, want missing", v)
+	}
+
+	// writes to other keys leave the token valid
+	k.Set("a", []byte("4"))
+	_, token, _ = k.GetWithToken("a")
+	k.Set("b", []byte("x"))
+	if done, err := k.SetWithToken("a", []byte("5"), token); err != nil || !done {
+		t.Errorf("SetWithToken after an unrelated write = %v, %v", done, err)
+	}
+}
+
+// jumpClock is a Clock that, once armed, jumps ahead right after the next
+// reading, as the wall clock might in the middle of a rewrite.
+type jumpClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	armed bool
+}
+
+func (c *jumpClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now
+	if c.armed {
+		c.armed = false
+		c.now = c.now.Add(time.Hour)
+	}
+	return now
+}
+
+func (c *jumpClock) arm() {
+	c.mu.Lock()
+	c.armed = true
+	c.mu.Unlock()
+}
+
+func TestRewriteLSNWhenKeyExpiresDuring(t *testing.T) {
+	for _, throttled := range []bool{false, true} {
+		t.Run(fmt.Sprintf("throttled=%v", throttled), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "a.log")
+			clock := &jumpClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+			opts := []Option{WithClock(clock)}
+			if throttled {
+				opts = append(opts, WithCompactionThrottle(1<<30))
+			}
+			k, err := Create(path, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			k.Set("a", []byte("1"))
+			k.SetWithTTL("b", []byte("2"), time.Minute)
+			k.Set("c", []byte("3"))
+			want := k.LastLSN()
+
+			// b is live at the first reading and expired at any later one
+			clock.arm()
+			if err := k.Compact(); err != nil {
+				t.Fatal(err)
+			}
+			if got := k.LastLSN(); got != want {
+				t.Errorf("LastLSN after Compact = %d, want %d", got, want)
+			}
+			k.Close()
+
+			reopened, err := Open(path, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reopened.Close()
+			if got := reopened.LastLSN(); got != want {
+				t.Errorf("LastLSN after reopening = %d, want %d", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package kv
+
+// keyOverhead is a rough count of the bytes the KV spends per live key on
+// top of the key and value themselves: map entries, the write-order list
+// element and the key's metadata.
+const keyOverhead = 160
+
+// MemoryEstimate returns an estimate of the bytes held in memory for the
+// data set: the live keys and values plus a fixed overhead per key. It is
+// meant for spotting growth, not exact accounting.
+func (k *KV) MemoryEstimate() int64 {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.keyBytes + k.valueBytes + int64(len(k.data))*keyOverhead
+}
+
+// reportMemory is run by the WithMemoryReporter worker.
+func (k *KV) reportMemory() {
+	k.opts.memReport(k.MemoryEstimate())
+}
@@ -0,0 +1,57 @@
+package kv
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMemoryReporter(t *testing.T) {
+	clock := newFakeClock()
+	reports := make(chan int64, 10)
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"), WithClock(clock),
+		WithMemoryReporter(time.Minute, func(n int64) { reports <- n }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	clock.waitTickers(t, 1)
+	next := func() int64 {
+		t.Helper()
+		clock.Advance(time.Minute)
+		select {
+		case n := <-reports:
+			return n
+		case <-time.After(5 * time.Second):
+			t.Fatal("the reporter did not fire")
+			return 0
+		}
+	}
+
+	empty := next()
+	for i := 0; i < 100; i++ {
+		k.Set(fmt.Sprintf("key%03d", i), make([]byte, 100))
+	}
+	full := next()
+	if want := empty + 100*(6+100); full < want {
+		t.Errorf("reported %d bytes after 100 inserts, want at least %d", full, want)
+	}
+	if full != k.MemoryEstimate() {
+		t.Errorf("reported %d, MemoryEstimate = %d", full, k.MemoryEstimate())
+	}
+	for i := 0; i < 50; i++ {
+		k.Del(fmt.Sprintf("key%03d", i))
+	}
+	if n := next(); n >= full {
+		t.Errorf("reported %d bytes after deleting half, was %d", n, full)
+	}
+
+	k.Close()
+	clock.Advance(time.Minute)
+	select {
+	case n := <-reports:
+		t.Errorf("reported %d after Close", n)
+	default:
+	}
+}
@@ -0,0 +1,97 @@
+package kv
+
+import (
+	"crypto/sha256"
+	"sort"
+)
+
+// MerkleNode is a subtree of the hash tree built by MerkleRoot: the keys
+// starting with Prefix, and their hash.
+type MerkleNode struct {
+	Prefix string
+	Hash   [32]byte
+}
+
+// MerkleRoot returns the hash of every live key starting with prefix and
+// its value, as a tree with one level per key byte: a node covers the keys
+// sharing its prefix and hashes the value of the key equal to the prefix,
+// if there is one, and then each child's next byte and hash in byte order.
+// Two databases holding the same keys and values under prefix get the same
+// root, whatever their history or WithKeyComparator. To reconcile, compare
+// roots and, where they differ, walk down with MerkleChildren, fetching
+// only the subtrees that differ; when every child matches but the root
+// does not, the key equal to the prefix is what differs. Each call reads
+// the keys under prefix under the read lock.
+func (k *KV) MerkleRoot(prefix string) [32]byte {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.merkleHash(k.merkleKeys(prefix), len(prefix))
+}
+
+// MerkleChildren returns the child nodes of prefix in the tree described
+// at MerkleRoot, in byte order: one per distinct byte following prefix
+// among the live keys, with its hash.
+func (k *KV) MerkleChildren(prefix string) []MerkleNode {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	keys := k.merkleKeys(prefix)
+	var nodes []MerkleNode
+	for i := 0; i < len(keys); {
+		if len(keys[i]) == len(prefix) {
+			i++
+			continue
+		}
+		j := childEnd(keys, i, len(prefix))
+		nodes = append(nodes, MerkleNode{
+			Prefix: keys[i][:len(prefix)+1],
+			Hash:   k.merkleHash(keys[i:j], len(prefix)+1),
+		})
+		i = j
+	}
+	return nodes
+}
+
+// merkleKeys returns the live keys starting with prefix in byte order.
+// Callers must hold k.mu.
+func (k *KV) merkleKeys(prefix string) []string {
+	keys := k.prefixKeys(prefix)
+	if k.opts.keyCmp != nil {
+		sort.Strings(keys)
+	}
+	return keys
+}
+
+// merkleHash hashes the node for keys, which share their first depth bytes
+// and are sorted. Callers must hold k.mu.
+func (k *KV) merkleHash(keys []string, depth int) [32]byte {
+	h := sha256.New()
+	i := 0
+	// the key ending at this node, if any, sorts first
+	if len(keys) > 0 && len(keys[0]) == depth {
+		sum := sha256.Sum256(k.data[keys[0]])
+		h.Write([]byte{0})
+		h.Write(sum[:])
+		i = 1
+	}
+	for i < len(keys) {
+		j := childEnd(keys, i, depth)
+		child := k.merkleHash(keys[i:j], depth+1)
+		h.Write([]byte{1, keys[i][depth]})
+		h.Write(child[:])
+		i = j
+	}
+	var sum [32]byte
+	h.Sum(sum[:0])
+	return sum
+}
+
+// childEnd returns the end of the run of keys from i on that share byte
+// depth with keys[i].
+func childEnd(keys []string, i, depth int) int {
+	c := keys[i][depth]
+	j := i + 1
+	for j < len(keys) && keys[j][depth] == c {
+		j++
+	}
+	return j
+}
@@ -0,0 +1,93 @@
+package kv
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// merkleDiff walks the trees of a and b under prefix and returns the
+// prefixes of the deepest nodes that differ, and how many nodes it fetched.
+func merkleDiff(a, b *KV, prefix string) (diff []string, fetched int) {
+	if a.MerkleRoot(prefix) == b.MerkleRoot(prefix) {
+		return nil, 0
+	}
+	ca, cb := a.MerkleChildren(prefix), b.MerkleChildren(prefix)
+	fetched = len(ca) + len(cb)
+	hashes := make(map[string][2][32]byte)
+	for _, n := range ca {
+		h := hashes[n.Prefix]
+		h[0] = n.Hash
+		hashes[n.Prefix] = h
+	}
+	for _, n := range cb {
+		h := hashes[n.Prefix]
+		h[1] = n.Hash
+		hashes[n.Prefix] = h
+	}
+	childDiffers := false
+	for p, h := range hashes {
+		if h[0] != h[1] {
+			childDiffers = true
+			d, f := merkleDiff(a, b, p)
+			diff, fetched = append(diff, d...), fetched+f
+		}
+	}
+	if !childDiffers {
+		// the key equal to prefix is what differs
+		diff = append(diff, prefix)
+	}
+	return diff, fetched
+}
+
+func TestMerkleDiff(t *testing.T) {
+	dir := t.TempDir()
+	a, err := Create(filepath.Join(dir, "a.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := Create(filepath.Join(dir, "b.log"), WithKeyComparator(func(x, y string) int { return strings.Compare(y, x) }))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	for i := 0; i < 200; i++ {
+		a.Set(fmt.Sprintf("user:%03d", i), []byte(fmt.Sprint(i)))
+	}
+	// same contents, different history and order
+	for i := 199; i >= 0; i-- {
+		b.Set(fmt.Sprintf("user:%03d", i), []byte("old"))
+		b.Set(fmt.Sprintf("user:%03d", i), []byte(fmt.Sprint(i)))
+	}
+	b.Set("gone", nil)
+	b.Del("gone")
+	if a.MerkleRoot("") != b.MerkleRoot("") {
+		t.Fatal("equal contents have different roots")
+	}
+
+	b.Set("user:042", []byte("changed"))
+	if a.MerkleRoot("") == b.MerkleRoot("") {
+		t.Fatal("changing a key left the root unchanged")
+	}
+	if a.MerkleRoot("user:1") != b.MerkleRoot("user:1") {
+		t.Error("an unchanged subtree's root changed")
+	}
+	diff, fetched := merkleDiff(a, b, "")
+	if fmt.Sprint(diff) != "[user:042]" {
+		t.Errorf("diff = %v, want [user:042]", diff)
+	}
+	// a handful of nodes per level rather than all 200 keys
+	if fetched >= 200 {
+		t.Errorf("the diff fetched %d nodes", fetched)
+	}
+
+	// a key that is a prefix of others is found too
+	a.Set("user:04", []byte("x"))
+	b.Set("user:04", []byte("y"))
+	b.Set("user:042", []byte("42"))
+	if diff, _ := merkleDiff(a, b, ""); fmt.Sprint(diff) != "[user:04]" {
+		t.Errorf("diff = %v, want [user:04]", diff)
+	}
+}
@@ -0,0 +1,133 @@
+package kv
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+)
+
+// mirrorCopyChunk is how much of the log is read at a time when copying or
+// comparing it with the mirror.
+const mirrorCopyChunk = 64 << 10
+
+// WithMirror appends every write to a second log at path as well, a byte
+// for byte copy of the log that can sit on another disk. Both files are
+// fsynced before a write returns, so each write costs two fsyncs. When
+// NewKV finds a damaged tail in the log, it restores the entries past the
+// damage from the mirror if the mirror holds them intact
+// (OpenInfo.MirrorRecoveredBytes), and it rebuilds a missing or diverged
+// mirror from the log. path is named within the KV's Storage.
+func WithMirror(path string) Option {
+	return func(o *options) {
+		o.mirror = path
+	}
+}
+
+// truncateLog cuts the log, and the mirror if there is one, back to size
+// after a failed write. Errors are ignored: the write has already failed.
+func (k *KV) truncateLog(size int64) {
+	_ = k.store.Truncate(k.logPath, size)
+	if k.opts.mirror != "" {
+		_ = k.store.Truncate(k.opts.mirror, size)
+	}
+}
+
+// recoverFromMirror replaces whatever follows end in the log with the valid
+// entries the mirror holds past end, provided the mirror's first end bytes
+// match the log's. It returns how many bytes it restored.
+func (k *KV) recoverFromMirror(end int64) (int64, error) {
+	mirror := k.opts.mirror
+	r, err := sectionFrom(k.store, mirror, end)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	_, mirrorEnd, _, err := readLog(r, end, k.format, true)
+	if err != nil || mirrorEnd <= end {
+		return 0, err
+	}
+	same, err := k.sameBytes(mirror, end)
+	if err != nil || !same {
+		return 0, err
+	}
+	buf := make([]byte, mirrorEnd-end)
+	if _, err := k.store.ReadAt(mirror, buf, end); err != nil {
+		return 0, err
+	}
+	if err := k.store.Truncate(k.logPath, end); err != nil {
+		return 0, err
+	}
+	if err := k.store.Append(k.logPath, buf); err != nil {
+		return 0, err
+	}
+	if err := k.store.Sync(k.logPath); err != nil {
+		return 0, err
+	}
+	return int64(len(buf)), nil
+}
+
+// sameBytes reports whether the first n bytes of the named file match the
+// log's.
+func (k *KV) sameBytes(name string, n int64) (bool, error) {
+	a := make([]byte, mirrorCopyChunk)
+	b := make([]byte, mirrorCopyChunk)
+	for off := int64(0); off < n; off += mirrorCopyChunk {
+		m := min(n-off, mirrorCopyChunk)
+		if _, err := k.store.ReadAt(k.logPath, a[:m], off); err != nil {
+			return false, err
+		}
+		if _, err := k.store.ReadAt(name, b[:m], off); err != nil {
+			if err == io.EOF {
+				return false, nil
+			}
+			return false, err
+		}
+		if !bytes.Equal(a[:m], b[:m]) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// rebuildMirror replaces the mirror, if there is one, with a durable copy
+// of the log. It is called wherever the log is replaced or rewritten other
+// than by writeEntries. Callers must hold k.mu for writing.
+func (k *KV) rebuildMirror() error {
+	mirror := k.opts.mirror
+	if mirror == "" {
+		return nil
+	}
+	size, err := k.store.Size(k.logPath)
+	if err != nil {
+		return err
+	}
+	tmpName := mirror + ".tmp"
+	if err := k.store.Remove(tmpName); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	buf := make([]byte, mirrorCopyChunk)
+	for off := int64(0); off < size; off += mirrorCopyChunk {
+		m := min(size-off, mirrorCopyChunk)
+		if _, err := k.store.ReadAt(k.logPath, buf[:m], off); err != nil {
+			_ = k.store.Remove(tmpName)
+			return err
+		}
+		if err := k.store.Append(tmpName, buf[:m]); err != nil {
+			_ = k.store.Remove(tmpName)
+			return err
+		}
+	}
+	if size == 0 {
+		if err := k.store.Append(tmpName, nil); err != nil {
+			return err
+		}
+	}
+	if err := k.store.Sync(tmpName); err != nil {
+		_ = k.store.Remove(tmpName)
+		return err
+	}
+	return k.store.Rename(tmpName, mirror)
+}
@@ -0,0 +1,92 @@
+package kv
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMirrorRecoversDamagedTail(t *testing.T) {
+	s := &flakySyncStorage{Storage: NewMemoryStorage()}
+	k, err := Create("a.log", WithStorage(s), WithMirror("b.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.Set("a", []byte("1"))
+	off, _ := s.Size("a.log")
+	s.calls = 0
+	k.Set("b", []byte("2"))
+	if s.calls != 2 {
+		t.Errorf("a write synced %d times, want both files synced", s.calls)
+	}
+	k.Set("c", []byte("3"))
+	k.Close()
+	if !bytes.Equal(readAll(t, s, "a.log"), readAll(t, s, "b.log")) {
+		t.Fatal("the mirror is not a copy of the log")
+	}
+	size, _ := s.Size("a.log")
+
+	// damage b's entry in the log: b and c are restored from the mirror
+	flipByte(t, s.Storage, "a.log", off+8)
+	k, err = Open("a.log", WithStorage(s), WithMirror("b.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := k.OpenInfo()
+	if info.MirrorRecoveredBytes != size-off || info.TailError != nil || info.TruncatedTail {
+		t.Errorf("OpenInfo = %+v, want %d bytes recovered and no tail", info, size-off)
+	}
+	for key, want := range map[string]string{"a": "1", "b": "2", "c": "3"} {
+		if v, _ := k.Get(key); string(v) != want {
+			t.Errorf("Get(%s) = %q, want %q", key, v, want)
+		}
+	}
+	k.Close()
+	if !bytes.Equal(readAll(t, s, "a.log"), readAll(t, s, "b.log")) {
+		t.Error("the repaired log differs from the mirror")
+	}
+
+	// damage in both copies cannot be repaired: the tail is cut from both
+	flipByte(t, s.Storage, "a.log", off+8)
+	flipByte(t, s.Storage, "b.log", off+8)
+	k, err = Open("a.log", WithStorage(s), WithMirror("b.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info := k.OpenInfo(); info.MirrorRecoveredBytes != 0 || !info.TruncatedTail {
+		t.Errorf("OpenInfo = %+v, want the tail cut and nothing recovered", info)
+	}
+	if _, ok := k.Get("b"); ok {
+		t.Error("b survived damage to both copies")
+	}
+	k.Close()
+	if got := readAll(t, s, "b.log"); !bytes.Equal(got, readAll(t, s, "a.log")) || int64(len(got)) != off {
+		t.Errorf("mirror is %d bytes, want it cut back to the log's %d", len(got), off)
+	}
+}
+
+func TestMirrorRebuiltWhenMissing(t *testing.T) {
+	s := NewMemoryStorage()
+	k, err := Create("a.log", WithStorage(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.Set("a", []byte("1"))
+	k.Close()
+
+	k, err = Open("a.log", WithStorage(s), WithMirror("b.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.Set("b", []byte("2"))
+	if !bytes.Equal(readAll(t, s, "a.log"), readAll(t, s, "b.log")) {
+		t.Error("the new mirror is not a copy of the log")
+	}
+	k.Del("a")
+	if err := k.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	k.Close()
+	if !bytes.Equal(readAll(t, s, "a.log"), readAll(t, s, "b.log")) {
+		t.Error("the mirror was not rewritten with the compacted log")
+	}
+}
@@ -0,0 +1,58 @@
+package kv
+
+import "strings"
+
+// KeySeparator joins the parts of a composite key built with Key.
+const KeySeparator = ':'
+
+const keyEscape = '\\'
+
+// Key joins parts into a composite key such as "user:123:profile". A
+// separator or backslash inside a part is escaped with a backslash, so
+// different part lists never produce the same key and SplitKey recovers
+// the parts exactly.
+func Key(parts ...string) string {
+	var b strings.Builder
+	for i, p := range parts {
+		if i > 0 {
+			b.WriteByte(KeySeparator)
+		}
+		for j := 0; j < len(p); j++ {
+			if p[j] == KeySeparator || p[j] == keyEscape {
+				b.WriteByte(keyEscape)
+			}
+			b.WriteByte(p[j])
+		}
+	}
+	return b.String()
+}
+
+// SplitKey splits a key built with Key back into its parts.
+func SplitKey(key string) []string {
+	var parts []string
+	var cur strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		switch {
+		case c == keyEscape && i+1 < len(key):
+			i++
+			cur.WriteByte(key[i])
+		case c == KeySeparator:
+			parts = append(parts, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	return append(parts, cur.String())
+}
+
+// ScanNamespace returns, in sorted order, the keys nested under the
+// composite key formed by parts, e.g. ScanNamespace("user", "123") matches
+// "user:123:profile" but not "user:1234:profile".
+func (k *KV) ScanNamespace(parts ...string) []string {
+	if len(parts) == 0 {
+		return k.Keys()
+	}
+	return k.ScanPrefix(Key(parts...) + string(KeySeparator))
+}
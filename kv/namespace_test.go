@@ -0,0 +1,55 @@
+package kv
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestKeyRoundTrip(t *testing.T) {
+	for _, parts := range [][]string{
+		{"user", "123", "profile"},
+		{"a:b", "c"},
+		{"a", "b:c"},
+		{"a\\", ":b"},
+		{"", "x", ""},
+		{"\\:\\", "::"},
+		{"only"},
+	} {
+		key := Key(parts...)
+		if got := SplitKey(key); !reflect.DeepEqual(got, parts) {
+			t.Errorf("SplitKey(Key(%q)) = %q via %q", parts, got, key)
+		}
+	}
+	if Key("a:b", "c") == Key("a", "b:c") {
+		t.Error("parts containing the separator collide")
+	}
+	if got := Key("user", "123", "profile"); got != "user:123:profile" {
+		t.Errorf("Key = %q, want user:123:profile", got)
+	}
+}
+
+func TestScanNamespace(t *testing.T) {
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	for _, parts := range [][]string{
+		{"user", "123", "profile"},
+		{"user", "123", "email"},
+		{"user", "1234", "profile"},
+		{"user", "123:x", "profile"},
+		{"user", "123"},
+	} {
+		k.Set(Key(parts...), nil)
+	}
+	got := fmt.Sprint(k.ScanNamespace("user", "123"))
+	if want := "[user:123:email user:123:profile]"; got != want {
+		t.Errorf("ScanNamespace = %s, want %s", got, want)
+	}
+	if got := k.ScanNamespace("user", "123:x"); len(got) != 1 || got[0] != Key("user", "123:x", "profile") {
+		t.Errorf("ScanNamespace with an escaped part = %q", got)
+	}
+}
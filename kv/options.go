@@ -0,0 +1,343 @@
+package kv
+
+import (
+	"encoding/binary"
+	"strings"
+	"time"
+)
+
+// Option configures a KV opened with NewKV.
+type Option func(*options)
+
+type options struct {
+	checksum           Checksum
+	skipRedundantWrite bool
+	verifyChecksums    bool
+	replayFilter       func(key string) bool
+	chunkSize          int
+	orderedIndex       bool
+	keyCmp             func(a, b string) int
+	clock              Clock
+	syncRetries        int
+	syncBackoff        time.Duration
+	writeRate          int
+	blockAlign         int
+	compactEvery       time.Duration
+	compactFrom        time.Duration
+	compactTo          time.Duration
+	compactRate        int
+	tombstoneCount     int
+	tombstoneRatio     float64
+	memReportEvery     time.Duration
+	memReport          func(bytes int64)
+	sweepEvery         time.Duration
+	idPrealloc         int
+	storage            Storage
+	deleteUndo         int
+	syncEvery          int
+	noSync             bool
+	compactOnOpen      int64
+	maxInflight        int
+	maxAliasHops       int
+	dedupValues        bool
+	interceptor        WriteInterceptor
+	mirror             string
+	reverseIndex       bool
+	loader             Loader
+	blockWhenPaused    bool
+	byteOrder          binary.ByteOrder
+}
+
+func defaultOptions() options {
+	return options{
+		checksum:        ChecksumIEEE,
+		byteOrder:       binary.BigEndian,
+		verifyChecksums: true,
+		clock:           realClock{},
+		syncRetries:     3,
+		syncBackoff:     time.Millisecond,
+		maxAliasHops:    defaultMaxAliasHops,
+	}
+}
+
+// WithChecksum selects the CRC32 polynomial used when a new log file is
+// created. Existing files keep the polynomial recorded in their header.
+func WithChecksum(c Checksum) Option {
+	return func(o *options) {
+		o.checksum = c
+	}
+}
+
+// WithSkipRedundantWrites makes Set return without appending anything when
+// the key already holds an identical value, saving log growth and an fsync
+// for idempotent writers.
+func WithSkipRedundantWrites() Option {
+	return func(o *options) {
+		o.skipRedundantWrite = true
+	}
+}
+
+// WithVerifyChecksums controls whether replay recomputes each entry's CRC
+// (the default). Turning it off speeds up opening large logs on media whose
+// integrity is already trusted, but a corrupted entry is then applied as-is
+// and a damaged length field can misframe everything after it.
+func WithVerifyChecksums(verify bool) Option {
+	return func(o *options) {
+		o.verifyChecksums = verify
+	}
+}
+
+// WithReplayFilter makes NewKV load only keys for which keep returns true,
+// so a process serving a subset of a shared log holds just that subset in
+// memory. Writes still go to the full log. Because the in-memory view is
+// partial, Compact and Checkpoint refuse to run with ErrReplayFiltered.
+func WithReplayFilter(keep func(key string) bool) Option {
+	return func(o *options) {
+		o.replayFilter = keep
+	}
+}
+
+// WithChunkSize splits values longer than size bytes into chunk entries of
+// at most size bytes, written as one atomic group and reassembled on replay.
+// This lifts the 4 GiB limit of a single entry's length field and keeps
+// individual entries small. Zero (the default) disables chunking. Logs with
+// chunked values can be opened with or without this option.
+func WithChunkSize(size int) Option {
+	return func(o *options) {
+		o.chunkSize = size
+	}
+}
+
+// WithOrderedIndex keeps keys sorted alongside the map so Keys, Scan and
+// ScanPrefix return without sorting on every call. Point lookups still use
+// the map. It costs extra memory per key and O(log n) work whenever a key
+// is added or removed, on replay as well as on writes, so it suits
+// scan-heavy workloads.
+func WithOrderedIndex() Option {
+	return func(o *options) {
+		o.orderedIndex = true
+	}
+}
+
+// WithKeyComparator orders keys by cmp, which returns a negative number,
+// zero or a positive number as a sorts before, equal to or after b, instead
+// of by their bytes. Keys, Scan, ScanPrefix, ScanFunc, QueryJSON and read
+// transactions return keys in that order, the ordered index keeps it, and
+// the bounds of Scan and CountRange are compared with cmp. Prefixes still
+// match on bytes, so with a custom order prefix scans look at every key.
+// Point reads such as Get are unaffected, as are StateHash and the order
+// Compact writes keys in. cmp must be a consistent total order and must be
+// the same every time the database is opened.
+func WithKeyComparator(cmp func(a, b string) int) Option {
+	return func(o *options) {
+		o.keyCmp = cmp
+	}
+}
+
+// keyCompare returns the comparator keys are ordered by.
+func (o options) keyCompare() func(a, b string) int {
+	if o.keyCmp != nil {
+		return o.keyCmp
+	}
+	return strings.Compare
+}
+
+// WithClock replaces the wall clock used for timestamps and durations.
+func WithClock(c Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+// WithSyncRetry sets how many times a failed fsync after a write is retried
+// and the initial backoff, which doubles per attempt. Only transient errors
+// (EINTR, EAGAIN) are retried; others such as ENOSPC fail at once. The
+// default is 3 retries starting at 1ms.
+func WithSyncRetry(retries int, backoff time.Duration) Option {
+	return func(o *options) {
+		o.syncRetries = retries
+		o.syncBackoff = backoff
+	}
+}
+
+// WithWriteRateLimit caps the rate at which Set, Del, WriteBatch and the
+// other writes append to the log at about bytesPerSec, smoothing bursts so
+// they do not saturate the disk. Writes over the limit block before taking
+// the write lock; the Context variants (SetContext and so on) can abort the
+// wait.
+func WithWriteRateLimit(bytesPerSec int) Option {
+	return func(o *options) {
+		o.writeRate = bytesPerSec
+	}
+}
+
+// WithBlockAlignment pads the log so every write (a single entry, or all the
+// entries of a batch or TTL set) starts and ends on a multiple of size
+// bytes. Set it to the device's sector or block size so a torn write only
+// ever damages the incomplete write itself, never the tail of the one
+// before it. Padding entries are skipped on replay.
+//
+// The cost is space: each write takes up a whole number of blocks, so with
+// 4096-byte blocks a 50-byte set occupies 4096 bytes of log until the next
+// Compact, which writes its output unpadded.
+func WithBlockAlignment(size int) Option {
+	return func(o *options) {
+		o.blockAlign = size
+	}
+}
+
+// WithScheduledCompaction runs Compact every interval in the background
+// until Close, skipping a tick when a compaction is already in progress.
+// Combine it with WithCompactionWindow to confine it to off-peak hours. The
+// interval is measured by the Clock if it implements Ticker.
+func WithScheduledCompaction(interval time.Duration) Option {
+	return func(o *options) {
+		o.compactEvery = interval
+	}
+}
+
+// WithCompactionWindow limits WithScheduledCompaction to the part of the
+// day between from and to, both offsets from midnight in the Clock's
+// location. A window with from after to wraps past midnight, so 22h to 4h
+// covers the night.
+func WithCompactionWindow(from, to time.Duration) Option {
+	return func(o *options) {
+		o.compactFrom = from
+		o.compactTo = to
+	}
+}
+
+// WithCompactionThrottle caps the rate at which Compact and ExportSubset
+// write the new file at about bytesPerSec, so a compaction does not
+// saturate a disk shared with other work; CompactTo takes it among its own
+// options. A throttled Compact writes from a snapshot without holding the
+// write lock, which it takes only to catch up with the writes made during
+// the copy and to switch files; until it finishes, Reset, Checkpoint and
+// SwapFile fail with ErrCompactionInProgress. ExportSubset and CompactTo
+// hold the read lock throughout, so they block writes for longer; pair
+// them with WithCompactionWindow to keep that off-peak.
+func WithCompactionThrottle(bytesPerSec int) Option {
+	return func(o *options) {
+		o.compactRate = bytesPerSec
+	}
+}
+
+// WithMemoryReporter calls cb every interval until Close with the estimated
+// in-memory size of the data set, as returned by MemoryEstimate, so a slow
+// leak or unexpected growth shows up in metrics. cb runs on its own
+// goroutine and must not block for long.
+func WithMemoryReporter(interval time.Duration, cb func(bytes int64)) Option {
+	return func(o *options) {
+		o.memReportEvery = interval
+		o.memReport = cb
+	}
+}
+
+// WithExpirySweep removes keys whose TTL or idle window has passed from
+// memory every interval until Close, so they stop holding memory and stop
+// counting in Stats, ApproxLen and MemoryEstimate. Without it expired keys
+// are only hidden from reads, and are reclaimed by the next Compact. The
+// sweep writes nothing: the log already records each expiry, and Compact
+// still drops the expired entries from it. The interval is measured by the
+// Clock if it implements Ticker.
+func WithExpirySweep(interval time.Duration) Option {
+	return func(o *options) {
+		o.sweepEvery = interval
+	}
+}
+
+// WithIDPreallocation makes NextID reserve n IDs per durable write instead
+// of one, trading gaps after a restart for fewer fsyncs.
+func WithIDPreallocation(n int) Option {
+	return func(o *options) {
+		o.idPrealloc = n
+	}
+}
+
+// WithStorage keeps the log and the files beside it (checkpoints, compaction
+// temporaries) in s instead of the local file system, with logPath naming
+// the log within s. The KV takes ownership of s and closes it on Close or
+// when opening fails. OpenStandby ignores it and always reads the local
+// file system.
+func WithStorage(s Storage) Option {
+	return func(o *options) {
+		o.storage = s
+	}
+}
+
+// WithDeleteUndo keeps the values of the last n keys removed by any delete,
+// including those in a WriteBatch, Update or DeleteFunc, or replaced by
+// SetAlias, in memory so
+// Undelete can restore them. The buffer is not written to the log and is
+// lost on Close or restart.
+func WithDeleteUndo(n int) Option {
+	return func(o *options) {
+		o.deleteUndo = n
+	}
+}
+
+// WithSyncEvery fsyncs the log once every n writes instead of after each
+// one, trading durability for throughput: a crash can lose up to n-1 writes
+// that had already returned successfully. Sync and Close flush whatever is
+// outstanding. Values below 2 sync every write, the default.
+func WithSyncEvery(n int) Option {
+	return func(o *options) {
+		o.syncEvery = n
+	}
+}
+
+// WithNoSync never fsyncs the log after a write, leaving it to the OS to
+// write back, and syncs only on an explicit Sync and on Close. It suits
+// caches that can be rebuilt: a clean shutdown preserves every write, but
+// after a crash any write since the last Sync may be lost. Compaction,
+// Checkpoint and the other file rewrites still sync what they write.
+func WithNoSync() Option {
+	return func(o *options) {
+		o.noSync = true
+	}
+}
+
+// WithCompactOnOpenIf makes NewKV compact the log right after replay when
+// its valid part is larger than maxBytes, so a log that grew for a long
+// time opens quickly the next time. OpenInfo.CompactedOnOpen reports it.
+// It has no effect together with WithReplayFilter.
+func WithCompactOnOpenIf(maxBytes int64) Option {
+	return func(o *options) {
+		o.compactOnOpen = maxBytes
+	}
+}
+
+// WithMaxInflightWrites bounds how many writes may be waiting or running at
+// once, counting those blocked on the write lock (for example behind a
+// compaction), a slow fsync or WithWriteRateLimit. Beyond n, writes fail at
+// once with ErrBusy instead of queuing, so a server can shed load rather
+// than pile up goroutines. Zero, the default, means no limit.
+func WithMaxInflightWrites(n int) Option {
+	return func(o *options) {
+		o.maxInflight = n
+	}
+}
+
+// WithMaxAliasHops sets how many aliases Get follows before giving up and
+// reporting the key missing, and so the longest chain SetAlias accepts.
+// The default is 8.
+func WithMaxAliasHops(n int) Option {
+	return func(o *options) {
+		o.maxAliasHops = n
+	}
+}
+
+// WithDedupValues makes compaction (Compact, or CompactTo when passed to
+// it) store each value held by more than one key once, with every key
+// referring to it by SHA-256 hash, so heavily duplicated values take their
+// space on disk once. Replay resolves the references, and keys sharing a
+// value then share its memory, so reads cost nothing extra. The costs are
+// hashing every value during compaction, 32 bytes of hash per key, and
+// holding the shared values in memory until replay of the file finishes.
+// Logs written this way need a version of this package that knows the
+// blob and ref entry types.
+func WithDedupValues() Option {
+	return func(o *options) {
+		o.dedupValues = true
+	}
+}
@@ -0,0 +1,34 @@
+package kv
+
+import "container/list"
+
+// OldestKey returns the live key written least recently, for eviction
+// heuristics. Write order is tracked in memory: after a reopen it follows
+// the order of entries in the log, which a Compact rewrites in no
+// particular order.
+func (k *KV) OldestKey() (string, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.firstLive(k.writeOrder.Front(), (*list.Element).Next)
+}
+
+// NewestKey returns the live key written most recently. See OldestKey.
+func (k *KV) NewestKey() (string, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.firstLive(k.writeOrder.Back(), (*list.Element).Prev)
+}
+
+// firstLive follows the write order from e using step and returns the first
+// key that has not expired.
+func (k *KV) firstLive(e *list.Element, step func(*list.Element) *list.Element) (string, bool) {
+	if k.closed {
+		return "", false
+	}
+	for ; e != nil; e = step(e) {
+		if key := e.Value.(string); !k.expired(key) {
+			return key, true
+		}
+	}
+	return "", false
+}
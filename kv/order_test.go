@@ -0,0 +1,54 @@
+package kv
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOldestNewestKey(t *testing.T) {
+	clock := newFakeClock()
+	path := filepath.Join(t.TempDir(), "a.log")
+	k, err := Create(path, WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	if _, ok := k.OldestKey(); ok {
+		t.Error("OldestKey found a key in an empty database")
+	}
+	check := func(oldest, newest string) {
+		t.Helper()
+		if got, _ := k.OldestKey(); got != oldest {
+			t.Errorf("OldestKey = %q, want %q", got, oldest)
+		}
+		if got, _ := k.NewestKey(); got != newest {
+			t.Errorf("NewestKey = %q, want %q", got, newest)
+		}
+	}
+	for _, key := range []string{"a", "b", "c", "d"} {
+		k.Set(key, nil)
+		clock.Advance(time.Second)
+	}
+	check("a", "d")
+
+	// rewriting a key makes it the newest
+	k.Set("a", []byte("again"))
+	check("b", "a")
+	k.Del("b")
+	check("c", "a")
+	// expired keys are skipped at either end
+	k.SetWithTTL("e", nil, time.Second)
+	check("c", "e")
+	clock.Advance(time.Second)
+	check("c", "a")
+
+	// reopening restores the order from the log
+	k.Close()
+	k, err = Open(path, WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	check("c", "a")
+}
@@ -0,0 +1,68 @@
+package kv
+
+// WithBlockWhenPaused makes writes to a paused KV wait for Resume (or
+// Close) instead of failing with ErrPaused. Waiting writes still count
+// towards WithMaxInflightWrites. Compactions and the other operations that
+// rewrite the whole log (Reset, SwapFile, Relocate, Checkpoint) fail with
+// ErrPaused regardless, so a background compaction cannot hold up Close.
+func WithBlockWhenPaused() Option {
+	return func(o *options) {
+		o.blockWhenPaused = true
+	}
+}
+
+// Pause stops the log from changing while reads carry on, for example
+// while a file system snapshot is taken. It waits for a write or
+// compaction in progress to finish and syncs anything WithSyncEvery or
+// WithNoSync left unsynced, so the file is complete when it returns. Until
+// Resume, writes, compactions and the other operations that modify the log
+// fail with ErrPaused, or wait with WithBlockWhenPaused. Pausing a paused
+// KV does nothing.
+func (k *KV) Pause() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.closed {
+		return ErrClosed
+	}
+	if k.unsynced > 0 {
+		if err := k.syncLog(); err != nil {
+			return err
+		}
+	}
+	k.paused = true
+	return nil
+}
+
+// Resume lets writes through again after Pause.
+func (k *KV) Resume() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.paused = false
+	k.resumed.Broadcast()
+}
+
+// checkRewritable is checkWritable for operations that rewrite the log,
+// which fail rather than wait while k is paused. Callers must hold k.mu for
+// writing.
+func (k *KV) checkRewritable() error {
+	if k.paused && !k.closed {
+		return ErrPaused
+	}
+	if k.compacting && !k.closed {
+		return ErrCompactionInProgress
+	}
+	return k.checkWritable()
+}
+
+// waitResumed returns ErrPaused while k is paused, or with
+// WithBlockWhenPaused waits until it is not. Callers must hold k.mu for
+// writing, which is released while waiting.
+func (k *KV) waitResumed() error {
+	for k.paused && !k.closed {
+		if !k.opts.blockWhenPaused {
+			return ErrPaused
+		}
+		k.resumed.Wait()
+	}
+	return nil
+}
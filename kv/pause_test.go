@@ -0,0 +1,107 @@
+package kv
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPause(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	s := newCrashStorage()
+	k, err := Create(path, WithStorage(s), WithSyncEvery(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	k.Set("a", []byte("1"))
+	if err := k.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Pause(); err != nil {
+		t.Errorf("pausing again = %v", err)
+	}
+	if _, n := k.DurabilityLag(); n != 0 {
+		t.Errorf("%d writes unsynced after Pause", n)
+	}
+	size, _ := s.Size(path)
+
+	if v, _ := k.Get("a"); string(v) != "1" {
+		t.Errorf("Get(a) while paused = %q", v)
+	}
+	if keys := k.Keys(); len(keys) != 1 {
+		t.Errorf("Keys while paused = %q", keys)
+	}
+	var b Batch
+	b.Set("b", nil)
+	for name, write := range map[string]func() error{
+		"Set":        func() error { return k.Set("b", nil) },
+		"Del":        func() error { return k.Del("a") },
+		"WriteBatch": func() error { return k.WriteBatch(&b) },
+		"Compact":    k.Compact,
+		"Checkpoint": k.Checkpoint,
+	} {
+		if err := write(); !errors.Is(err, ErrPaused) {
+			t.Errorf("%s while paused = %v, want ErrPaused", name, err)
+		}
+	}
+	if got, _ := s.Size(path); got != size {
+		t.Errorf("the log changed from %d to %d bytes while paused", size, got)
+	}
+
+	k.Resume()
+	if err := k.Set("b", []byte("2")); err != nil {
+		t.Fatalf("Set after Resume = %v", err)
+	}
+	if v, _ := k.Get("b"); string(v) != "2" {
+		t.Errorf("Get(b) = %q after Resume", v)
+	}
+}
+
+func TestPauseBlocksWrites(t *testing.T) {
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"), WithBlockWhenPaused())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	if err := k.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- k.Set("a", []byte("1")) }()
+	select {
+	case err := <-done:
+		t.Fatalf("Set returned %v while paused", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	// the waiting write does not hold up reads
+	if _, ok := k.Get("a"); ok {
+		t.Error("the blocked write is visible")
+	}
+	if err := k.Compact(); !errors.Is(err, ErrPaused) {
+		t.Errorf("Compact while paused = %v, want ErrPaused even when blocking", err)
+	}
+
+	k.Resume()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("the blocked Set = %v after Resume", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Set still blocked after Resume")
+	}
+	if v, _ := k.Get("a"); string(v) != "1" {
+		t.Errorf("Get(a) = %q after Resume", v)
+	}
+
+	// Close releases a blocked write with ErrClosed
+	k.Pause()
+	go func() { done <- k.Set("b", nil) }()
+	time.Sleep(10 * time.Millisecond)
+	k.Close()
+	if err := <-done; !errors.Is(err, ErrClosed) {
+		t.Errorf("a write blocked across Close = %v, want ErrClosed", err)
+	}
+}
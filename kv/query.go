@@ -0,0 +1,109 @@
+package kv
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// QueryJSON returns, sorted, the keys under prefix whose value is a JSON
+// document with a field at jsonPath (dot separated, e.g. "user.age";
+// numeric parts index arrays) that compares to operand with op, one of
+// "=", "!=", ">" and "<". Numbers compare numerically when operand parses
+// as a number, strings lexically, and booleans and null only with "=" and
+// "!=" against "true", "false" or "null". Values that are not valid JSON or
+// lack the field never match, and an unknown op matches nothing. After
+// Close it matches nothing.
+func (k *KV) QueryJSON(prefix, jsonPath, op, operand string) []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.closed {
+		return nil
+	}
+	var keys []string
+	k.ascendPrefix(prefix, func(key string) bool {
+		var doc any
+		if json.Unmarshal(k.data[key], &doc) != nil {
+			return true
+		}
+		if field, ok := jsonField(doc, jsonPath); ok && jsonMatch(field, op, operand) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	return keys
+}
+
+// jsonField follows the dot-separated path from doc.
+func jsonField(doc any, path string) (any, bool) {
+	if path == "" {
+		return doc, true
+	}
+	for _, part := range strings.Split(path, ".") {
+		switch v := doc.(type) {
+		case map[string]any:
+			next, ok := v[part]
+			if !ok {
+				return nil, false
+			}
+			doc = next
+		case []any:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			doc = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+// jsonMatch compares a decoded JSON value with operand.
+func jsonMatch(field any, op, operand string) bool {
+	var cmp int
+	switch v := field.(type) {
+	case float64:
+		n, err := strconv.ParseFloat(operand, 64)
+		if err != nil {
+			return false
+		}
+		switch {
+		case v < n:
+			cmp = -1
+		case v > n:
+			cmp = 1
+		}
+	case string:
+		cmp = strings.Compare(v, operand)
+	case bool:
+		if op != "=" && op != "!=" {
+			return false
+		}
+		if strconv.FormatBool(v) != operand {
+			cmp = 1
+		}
+	case nil:
+		if op != "=" && op != "!=" {
+			return false
+		}
+		if operand != "null" {
+			cmp = 1
+		}
+	default:
+		// objects and arrays are not comparable
+		return false
+	}
+	switch op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case "<":
+		return cmp < 0
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package kv
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestQueryJSON(t *testing.T) {
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	for key, doc := range map[string]string{
+		"user:1":  `{"name":"ann","age":31,"addr":{"city":"Oslo"},"tags":["a","b"]}`,
+		"user:2":  `{"name":"bob","age":9,"addr":{"city":"Rome"},"admin":true}`,
+		"user:3":  `{"name":"cy","age":"unknown"}`,
+		"user:4":  `not json`,
+		"user:5":  `{"name":"dee","age":100,"admin":false}`,
+		"other:1": `{"name":"ann","age":50}`,
+	} {
+		k.Set(key, []byte(doc))
+	}
+	for _, tc := range []struct {
+		path, op, operand string
+		want              string
+	}{
+		// a string field compares lexically even against a number
+		{"age", ">", "30", "[user:1 user:3 user:5]"},
+		{"age", "<", "10", "[user:2]"},
+		{"age", "=", "31.0", "[user:1]"},
+		{"age", "!=", "31", "[user:2 user:3 user:5]"},
+		{"name", "=", "ann", "[user:1]"},
+		{"name", ">", "bob", "[user:3 user:5]"},
+		{"addr.city", "!=", "Oslo", "[user:2]"},
+		{"tags.1", "=", "b", "[user:1]"},
+		{"admin", "=", "true", "[user:2]"},
+		{"admin", ">", "false", "[]"},
+		{"age", "~", "31", "[]"},
+	} {
+		got := fmt.Sprint(k.QueryJSON("user:", tc.path, tc.op, tc.operand))
+		if got != tc.want {
+			t.Errorf("QueryJSON(%s %s %s) = %s, want %s", tc.path, tc.op, tc.operand, got, tc.want)
+		}
+	}
+}
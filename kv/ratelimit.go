@@ -0,0 +1,92 @@
+package kv
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket measured in log bytes. It refills at rate
+// bytes per second up to one second's worth; a write larger than what is
+// available takes the bucket into debt and waits for it to be paid off.
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(bytesPerSec int) *rateLimiter {
+	return &rateLimiter{
+		rate:   float64(bytesPerSec),
+		tokens: float64(bytesPerSec),
+		last:   time.Now(),
+	}
+}
+
+// reserve takes n tokens and returns how long the caller must wait before
+// writing them.
+func (l *rateLimiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.tokens = min(l.rate, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// cancel returns n tokens taken by a reservation that was not used.
+func (l *rateLimiter) cancel(n int) {
+	l.mu.Lock()
+	l.tokens += float64(n)
+	l.mu.Unlock()
+}
+
+// wait blocks until n bytes may be written or ctx is done.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	d := l.reserve(n)
+	if d == 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		l.cancel(n)
+		return ctx.Err()
+	}
+}
+
+// throttle waits for the write rate limit, if any, to allow n more bytes.
+// It is called before taking k.mu so a throttled writer does not hold up
+// readers.
+func (k *KV) throttle(ctx context.Context, n int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if k.limiter == nil {
+		return nil
+	}
+	return k.limiter.wait(ctx, n)
+}
+
+// admit counts a write as in flight until release is called, or fails with
+// ErrBusy if WithMaxInflightWrites' limit is already reached. It is called
+// before throttle, so writers waiting on the rate limit or for k.mu count.
+func (k *KV) admit() (release func(), err error) {
+	limit := k.opts.maxInflight
+	if limit <= 0 {
+		return func() {}, nil
+	}
+	if k.inflight.Add(1) > int64(limit) {
+		k.inflight.Add(-1)
+		return nil, ErrBusy
+	}
+	return func() { k.inflight.Add(-1) }, nil
+}
@@ -0,0 +1,124 @@
+package kv
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteRateLimit(t *testing.T) {
+	const rate = 200_000
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"), WithNoSync(), WithWriteRateLimit(rate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+
+	value := make([]byte, 1000)
+	size := SetEntrySize("key", value)
+	start := time.Now()
+	total := 0
+	for total < 2*rate {
+		if err := k.Set("key", value); err != nil {
+			t.Fatal(err)
+		}
+		total += size
+	}
+	elapsed := time.Since(start).Seconds()
+	// the bucket starts with one second's worth
+	sustained := float64(total-rate) / elapsed
+	if sustained > rate*1.1 {
+		t.Errorf("wrote %d bytes in %.2fs, %.0f B/s over the first second's burst, limit %d",
+			total, elapsed, sustained, rate)
+	}
+	if sustained < rate*0.5 {
+		t.Errorf("throughput %.0f B/s far below the limit %d", sustained, rate)
+	}
+
+	// a write waiting for the bucket can be abandoned
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = k.SetContext(ctx, "big", make([]byte, rate))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("SetContext = %v, want DeadlineExceeded", err)
+	}
+	if _, ok := k.Get("big"); ok {
+		t.Error("the abandoned write was applied")
+	}
+}
+
+func TestUpdateRateLimit(t *testing.T) {
+	const rate = 100_000
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"), WithNoSync(), WithWriteRateLimit(rate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+
+	// a fifth over the first second's burst leaves 200ms to wait off
+	start := time.Now()
+	err = k.Update(func(tx *Tx) error {
+		tx.Set("big", make([]byte, rate+rate/5))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("Update over the limit returned after %v", elapsed)
+	}
+	if _, ok := k.Get("big"); !ok {
+		t.Error("the throttled Update was not committed")
+	}
+}
+
+func TestMaxInflightWrites(t *testing.T) {
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"), WithMaxInflightWrites(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+
+	// stall writers behind the lock, as a slow disk or compaction would
+	k.mu.Lock()
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { errs <- k.Set("queued", nil) }()
+	}
+	for deadline := time.Now().Add(5 * time.Second); k.inflight.Load() < 2; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			k.mu.Unlock()
+			t.Fatal("writers never queued")
+		}
+	}
+	if err := k.Set("over", nil); !errors.Is(err, ErrBusy) {
+		t.Errorf("Set over the limit = %v, want ErrBusy", err)
+	}
+	var b Batch
+	b.Set("over", nil)
+	if err := k.WriteBatch(&b); !errors.Is(err, ErrBusy) {
+		t.Errorf("WriteBatch over the limit = %v, want ErrBusy", err)
+	}
+	err = k.Update(func(tx *Tx) error {
+		t.Error("Update over the limit ran its function")
+		return nil
+	})
+	if !errors.Is(err, ErrBusy) {
+		t.Errorf("Update over the limit = %v, want ErrBusy", err)
+	}
+	k.mu.Unlock()
+
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("queued Set = %v", err)
+		}
+	}
+	if err := k.Set("after", nil); err != nil {
+		t.Errorf("Set once the queue drained = %v", err)
+	}
+	if _, ok := k.Get("over"); ok {
+		t.Error("a rejected write was applied")
+	}
+}
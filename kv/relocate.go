@@ -0,0 +1,72 @@
+package kv
+
+import (
+	"errors"
+	"path/filepath"
+)
+
+// Relocate moves the database to newPath, for example onto a bigger volume,
+// without closing it. It compacts the live keys into a file beside newPath,
+// which may be on another file system, syncs it, renames it into place and
+// switches reads, writes and later compactions to it; the old log and its
+// checkpoint are then removed. Reads and writes wait for the write lock
+// while the copy is written, as during Compact. A file already at newPath
+// is replaced. If Relocate fails before the switch, the database stays
+// where it was; an error removing the old log is reported after the move
+// has happened.
+func (k *KV) Relocate(newPath string) error {
+	if !k.compactMu.TryLock() {
+		return ErrCompactionInProgress
+	}
+	defer k.compactMu.Unlock()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.checkRewritable(); err != nil {
+		return err
+	}
+	if k.opts.replayFilter != nil {
+		return ErrReplayFiltered
+	}
+	src, err := filepath.Abs(k.logPath)
+	if err != nil {
+		return err
+	}
+	dst, err := filepath.Abs(newPath)
+	if err != nil {
+		return err
+	}
+	if src == dst {
+		return errors.New("kv: destination is the active log")
+	}
+
+	lf := k.format
+	// the temporary file is renamed within newPath's directory, never
+	// across file systems
+	tmpName := newPath + ".compact.tmp"
+	if err := k.writeCompacted(tmpName, &lf, k.opts, nil); err != nil {
+		return err
+	}
+	if err := removeCheckpoint(k.store, newPath); err != nil {
+		_ = k.store.Remove(tmpName)
+		return err
+	}
+	if err := k.store.Rename(tmpName, newPath); err != nil {
+		_ = k.store.Remove(tmpName)
+		return err
+	}
+
+	oldPath := k.logPath
+	k.logPath = newPath
+	k.format = lf
+	// writes not yet synced are in the new, synced log
+	k.markDurable()
+	k.tombstones = 0
+	if err := k.rebuildMirror(); err != nil {
+		return err
+	}
+	k.dropExpired()
+	if err := removeCheckpoint(k.store, oldPath); err != nil {
+		return err
+	}
+	return k.store.Remove(oldPath)
+}
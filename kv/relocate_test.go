@@ -0,0 +1,70 @@
+package kv
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRelocate(t *testing.T) {
+	oldPath := filepath.Join(t.TempDir(), "a.log")
+	newPath := filepath.Join(t.TempDir(), "b.log")
+	k, err := Create(oldPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.Set("a", []byte("1"))
+	k.Set("a", []byte("2"))
+	k.SetWithTTL("ttl", []byte("3"), time.Hour)
+	if err := k.Checkpoint(); err != nil {
+		t.Fatal(err)
+	}
+	lsn := k.LastLSN()
+
+	if err := k.Relocate(oldPath); err == nil {
+		t.Error("Relocate onto the active log succeeded")
+	}
+	if err := k.Relocate(newPath); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{oldPath, checkpointPath(oldPath)} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("%s is still there: %v", p, err)
+		}
+	}
+	if v, _ := k.Get("a"); string(v) != "2" {
+		t.Errorf("Get(a) = %q after Relocate, want 2", v)
+	}
+
+	// later writes go to the new log
+	before, err := os.Stat(newPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.Set("b", []byte("4"))
+	if after, _ := os.Stat(newPath); after.Size() <= before.Size() {
+		t.Errorf("a write after Relocate left %s at %d bytes", newPath, after.Size())
+	}
+	if _, err := os.Stat(oldPath); !os.IsNotExist(err) {
+		t.Errorf("a write after Relocate recreated the old log: %v", err)
+	}
+	k.Close()
+
+	k, err = Open(newPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	for key, want := range map[string]string{"a": "2", "ttl": "3", "b": "4"} {
+		if v, _ := k.Get(key); string(v) != want {
+			t.Errorf("Get(%s) = %q from the new log, want %q", key, v, want)
+		}
+	}
+	if ttl, _ := k.TTL("ttl"); ttl <= 0 {
+		t.Errorf("TTL(ttl) = %v, want the expiry kept", ttl)
+	}
+	if n := k.LastLSN(); n != lsn+1 {
+		t.Errorf("LastLSN = %d, want %d", n, lsn+1)
+	}
+}
@@ -0,0 +1,60 @@
+package kv
+
+// reverseIndex maps each value to the keys holding it, see
+// WithReverseIndex.
+type reverseIndex map[string]map[string]struct{}
+
+func (r reverseIndex) add(key string, val []byte) {
+	keys := r[string(val)]
+	if keys == nil {
+		keys = make(map[string]struct{})
+		r[string(val)] = keys
+	}
+	keys[key] = struct{}{}
+}
+
+func (r reverseIndex) drop(key string, val []byte) {
+	keys := r[string(val)]
+	delete(keys, key)
+	if len(keys) == 0 {
+		delete(r, string(val))
+	}
+}
+
+// WithReverseIndex keeps an index from values to the keys holding them so
+// KeyOf is a map lookup rather than a scan. It stores every value a second
+// time as a map key, so it suits small reference tables rather than large
+// values.
+func WithReverseIndex() Option {
+	return func(o *options) {
+		o.reverseIndex = true
+	}
+}
+
+// KeyOf returns a live key whose value equals value, the smallest if there
+// are several. Without WithReverseIndex it scans every key.
+func (k *KV) KeyOf(value []byte) (string, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.closed {
+		return "", false
+	}
+	found, ok := "", false
+	consider := func(key string) {
+		if (!ok || key < found) && !k.expired(key) {
+			found, ok = key, true
+		}
+	}
+	if k.reverse != nil {
+		for key := range k.reverse[string(value)] {
+			consider(key)
+		}
+		return found, ok
+	}
+	for key, val := range k.data {
+		if string(val) == string(value) {
+			consider(key)
+		}
+	}
+	return found, ok
+}
@@ -0,0 +1,59 @@
+package kv
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeyOf(t *testing.T) {
+	for name, opts := range map[string][]Option{
+		"scan":    nil,
+		"indexed": {WithReverseIndex()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := NewMemoryStorage()
+			opts := append([]Option{WithStorage(s)}, opts...)
+			k, err := Create("a.log", opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (k.reverse != nil) != (name == "indexed") {
+				t.Fatalf("reverse index present = %v", k.reverse != nil)
+			}
+			check := func(value, wantKey string, wantOK bool) {
+				t.Helper()
+				if key, ok := k.KeyOf([]byte(value)); key != wantKey || ok != wantOK {
+					t.Errorf("KeyOf(%s) = %q, %v; want %q, %v", value, key, ok, wantKey, wantOK)
+				}
+			}
+			k.Set("c", []byte("x"))
+			k.Set("a", []byte("x"))
+			k.Set("b", []byte("y"))
+			check("x", "a", true) // the smallest of several
+			check("y", "b", true)
+			check("z", "", false)
+
+			k.Set("a", []byte("z"))
+			check("x", "c", true)
+			check("z", "a", true)
+			k.Del("c")
+			check("x", "", false)
+			k.SetWithTTL("e", []byte("gone"), -time.Second)
+			check("gone", "", false)
+
+			// the index is rebuilt by replay and kept across a compaction
+			k.Close()
+			if k, err = Open("a.log", opts...); err != nil {
+				t.Fatal(err)
+			}
+			defer k.Close()
+			check("z", "a", true)
+			check("y", "b", true)
+			if err := k.Compact(); err != nil {
+				t.Fatal(err)
+			}
+			check("z", "a", true)
+			check("x", "", false)
+		})
+	}
+}
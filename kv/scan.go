@@ -0,0 +1,261 @@
+package kv
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Keys returns all live keys in sorted order.
+func (k *KV) Keys() []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.rangeKeys("", "")
+}
+
+// ScanPrefix returns the keys starting with prefix in sorted order.
+func (k *KV) ScanPrefix(prefix string) []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.prefixKeys(prefix)
+}
+
+// Scan returns the keys in [start, end) in sorted order. An empty start or
+// end means no bound.
+func (k *KV) Scan(start, end string) []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.rangeKeys(start, end)
+}
+
+// numericWidth is how many digits NumericKey pads to: enough for any
+// non-negative int64.
+const numericWidth = 19
+
+// NumericKey returns prefix followed by n in decimal, zero-padded to 19
+// digits, so that keys built this way sort lexicographically in numeric
+// order and can be scanned with ScanNumeric. n must not be negative.
+func NumericKey(prefix string, n int64) string {
+	return fmt.Sprintf("%s%0*d", prefix, numericWidth, n)
+}
+
+// ScanNumeric returns, in numeric order, the keys built by NumericKey from
+// prefix and a number in [lo, hi]. Keys under prefix that are not in that
+// form, such as unpadded numbers, are not returned. Negative bounds are
+// treated as zero.
+func (k *KV) ScanNumeric(prefix string, lo, hi int64) []string {
+	lo = max(lo, 0)
+	if hi < lo {
+		return nil
+	}
+	start, end := NumericKey(prefix, lo), prefixEnd(prefix)
+	if hi < math.MaxInt64 {
+		end = NumericKey(prefix, hi+1)
+	}
+	k.mu.RLock()
+	var keys []string
+	if k.opts.keyCmp == nil {
+		keys = k.rangeKeys(start, end)
+	} else {
+		keys = k.prefixKeys(prefix)
+	}
+	k.mu.RUnlock()
+	numeric := keys[:0]
+	for _, key := range keys {
+		if len(key)-len(prefix) == numericWidth && isDigits(key[len(prefix):]) &&
+			key >= start && (end == "" || key < end) {
+			numeric = append(numeric, key)
+		}
+	}
+	if k.opts.keyCmp != nil {
+		sort.Strings(numeric)
+	}
+	return numeric
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// ScanFunc calls fn with each key starting with prefix and a copy of its
+// value, in sorted order, stopping at and returning the first error from
+// fn. With WithOrderedIndex no key slice is built at all. The read lock is
+// held throughout, so fn must not write to k.
+func (k *KV) ScanFunc(prefix string, fn func(key string, value []byte) error) error {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.closed {
+		return ErrClosed
+	}
+	var err error
+	k.ascendPrefix(prefix, func(key string) bool {
+		err = fn(key, append([]byte(nil), k.data[key]...))
+		return err == nil
+	})
+	return err
+}
+
+// ascend calls fn for the keys in [start, end) in sorted order until fn
+// returns false. Callers must hold k.mu.
+func (k *KV) ascend(start, end string, fn func(key string) bool) {
+	if k.index != nil {
+		k.index.ascend(start, end, func(key string) bool {
+			return k.expired(key) || fn(key)
+		})
+		return
+	}
+	for _, key := range k.rangeKeys(start, end) {
+		if !fn(key) {
+			return
+		}
+	}
+}
+
+// ascendPrefix calls fn for the keys starting with prefix in sorted order
+// until fn returns false. Callers must hold k.mu.
+func (k *KV) ascendPrefix(prefix string, fn func(key string) bool) {
+	if k.opts.keyCmp == nil {
+		k.ascend(prefix, prefixEnd(prefix), fn)
+		return
+	}
+	// under a custom order the prefix's keys need not be adjacent
+	k.ascend("", "", func(key string) bool {
+		return !strings.HasPrefix(key, prefix) || fn(key)
+	})
+}
+
+// prefixKeys returns the keys starting with prefix in sorted order.
+// Callers must hold k.mu.
+func (k *KV) prefixKeys(prefix string) []string {
+	if k.opts.keyCmp == nil {
+		return k.rangeKeys(prefix, prefixEnd(prefix))
+	}
+	keys := k.rangeKeys("", "")
+	matched := keys[:0]
+	for _, key := range keys {
+		if strings.HasPrefix(key, prefix) {
+			matched = append(matched, key)
+		}
+	}
+	return matched
+}
+
+// GetPrefixMap returns copies of all values whose key starts with prefix,
+// keyed by the full key, read under a single lock. The whole result is held
+// in memory, so keep prefixes narrow on large data sets.
+func (k *KV) GetPrefixMap(prefix string) map[string][]byte {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	out := make(map[string][]byte)
+	if k.closed {
+		return out
+	}
+	for key, val := range k.data {
+		if strings.HasPrefix(key, prefix) && !k.expired(key) {
+			out[key] = append([]byte(nil), val...)
+		}
+	}
+	return out
+}
+
+// GetAll returns a copy of every live key and value. The map shares nothing
+// with the store, so callers may modify it freely. It materializes the whole
+// data set and is only meant for small databases.
+func (k *KV) GetAll() map[string][]byte {
+	return k.GetPrefixMap("")
+}
+
+// CountPrefix returns how many keys start with prefix without building a
+// slice of them.
+func (k *KV) CountPrefix(prefix string) int {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.closed {
+		return 0
+	}
+	n := 0
+	for key := range k.data {
+		if strings.HasPrefix(key, prefix) && !k.expired(key) {
+			n++
+		}
+	}
+	return n
+}
+
+// CountRange returns how many keys fall in [start, end) without building a
+// slice of them. An empty end means no upper bound.
+func (k *KV) CountRange(start, end string) int {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.closed {
+		return 0
+	}
+	n := 0
+	for key := range k.data {
+		if k.inRange(key, start, end) && !k.expired(key) {
+			n++
+		}
+	}
+	return n
+}
+
+// rangeKeys returns the keys in [start, end) in sorted order, taking them
+// from the ordered index when there is one. An empty end means no upper
+// bound. Callers must hold k.mu. It returns nil once the KV is closed.
+func (k *KV) rangeKeys(start, end string) []string {
+	if k.closed {
+		return nil
+	}
+	if k.index != nil {
+		keys := k.index.rangeKeys(start, end)
+		if len(k.expiry) == 0 {
+			return keys
+		}
+		live := keys[:0]
+		for _, key := range keys {
+			if !k.expired(key) {
+				live = append(live, key)
+			}
+		}
+		return live
+	}
+	keys := make([]string, 0)
+	for key := range k.data {
+		if k.inRange(key, start, end) && !k.expired(key) {
+			keys = append(keys, key)
+		}
+	}
+	if k.opts.keyCmp == nil {
+		sort.Strings(keys)
+	} else {
+		sort.Slice(keys, func(i, j int) bool { return k.opts.keyCmp(keys[i], keys[j]) < 0 })
+	}
+	return keys
+}
+
+// prefixEnd returns the smallest key greater than every key with the given
+// prefix, or "" if there is none.
+func prefixEnd(prefix string) string {
+	b := []byte(prefix)
+	for i := len(b) - 1; i >= 0; i-- {
+		if b[i] < 0xff {
+			b[i]++
+			return string(b[:i+1])
+		}
+	}
+	return ""
+}
+
+// inRange reports whether key falls in [start, end) in k's key order. An
+// empty start or end means no bound, which matters under a custom order
+// where "" need not sort first.
+func (k *KV) inRange(key, start, end string) bool {
+	cmp := k.opts.keyCompare()
+	return (start == "" || cmp(key, start) >= 0) && (end == "" || cmp(key, end) < 0)
+}
@@ -0,0 +1,312 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCountMatchesScans(t *testing.T) {
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	for i := 0; i < 30; i++ {
+		k.Set(fmt.Sprintf("user:%02d", i), []byte("u"))
+		k.Set(fmt.Sprintf("order:%02d", i), []byte("o"))
+	}
+	k.Del("user:03")
+	k.SetWithTTL("user:99", []byte("gone"), -time.Second)
+
+	for _, prefix := range []string{"", "user:", "user:1", "order:", "none"} {
+		if got, want := k.CountPrefix(prefix), len(k.ScanPrefix(prefix)); got != want {
+			t.Errorf("CountPrefix(%q) = %d, ScanPrefix found %d", prefix, got, want)
+		}
+	}
+	for _, r := range [][2]string{{"", ""}, {"order:10", "order:20"}, {"user:", ""}, {"", "order:05"}, {"z", ""}} {
+		if got, want := k.CountRange(r[0], r[1]), len(k.Scan(r[0], r[1])); got != want {
+			t.Errorf("CountRange(%q, %q) = %d, Scan found %d", r[0], r[1], got, want)
+		}
+	}
+	if n := k.CountPrefix("user:"); n != 29 {
+		t.Errorf("CountPrefix(user:) = %d, want 29", n)
+	}
+	k.Close()
+	if n := k.CountPrefix(""); n != 0 {
+		t.Errorf("CountPrefix after Close = %d", n)
+	}
+}
+
+func benchmarkKV(b *testing.B, n int, opts ...Option) *KV {
+	b.Helper()
+	k, err := Create(filepath.Join(b.TempDir(), "a.log"), append(opts, WithNoSync())...)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { k.Close() })
+	for i := 0; i < n; i++ {
+		k.Set(fmt.Sprintf("key:%06d", i), []byte("value"))
+	}
+	return k
+}
+
+func BenchmarkCountPrefix(b *testing.B) {
+	k := benchmarkKV(b, 10000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		k.CountPrefix("key:00")
+	}
+}
+
+func BenchmarkScanPrefixLen(b *testing.B) {
+	k := benchmarkKV(b, 10000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = len(k.ScanPrefix("key:00"))
+	}
+}
+
+func TestGetPrefixMap(t *testing.T) {
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	k.Set("user:1:name", []byte("ann"))
+	k.Set("user:1:email", []byte("ann@example.com"))
+	k.Set("user:12:name", []byte("bob"))
+	k.Set("user:2:name", []byte("cy"))
+	k.SetWithTTL("user:1:token", []byte("t"), -time.Second)
+
+	m := k.GetPrefixMap("user:1:")
+	want := map[string]string{"user:1:name": "ann", "user:1:email": "ann@example.com"}
+	if len(m) != len(want) {
+		t.Fatalf("GetPrefixMap = %q, want %q", m, want)
+	}
+	for key, v := range want {
+		if string(m[key]) != v {
+			t.Errorf("m[%q] = %q, want %q", key, m[key], v)
+		}
+	}
+	// the values are copies
+	m["user:1:name"][0] = 'X'
+	if v, _ := k.Get("user:1:name"); string(v) != "ann" {
+		t.Errorf("modifying the map changed the store: %q", v)
+	}
+	if all := k.GetAll(); len(all) != 4 {
+		t.Errorf("GetAll has %d keys, want 4", len(all))
+	}
+}
+
+func TestOrderedIndexScans(t *testing.T) {
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"), WithOrderedIndex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	for _, key := range []string{"b:2", "a:1", "b:1", "c", "b:3", "a:2"} {
+		k.Set(key, []byte(key))
+	}
+	k.Del("b:2")
+	k.Set("b:0", nil)
+
+	got := fmt.Sprint(k.ScanPrefix("b:"))
+	if want := "[b:0 b:1 b:3]"; got != want {
+		t.Errorf("ScanPrefix = %s, want %s", got, want)
+	}
+	if got, want := fmt.Sprint(k.Keys()), "[a:1 a:2 b:0 b:1 b:3 c]"; got != want {
+		t.Errorf("Keys = %s, want %s", got, want)
+	}
+}
+
+func BenchmarkScanPrefix(b *testing.B) {
+	for _, bc := range []struct {
+		name string
+		opts []Option
+	}{
+		{"map", nil},
+		{"ordered", []Option{WithOrderedIndex()}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			k := benchmarkKV(b, 10000, bc.opts...)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				k.ScanPrefix("key:0050")
+			}
+		})
+	}
+}
+
+func TestGetAllIsIndependent(t *testing.T) {
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	k.Set("a", []byte("1"))
+	k.Set("b", []byte("2"))
+
+	all := k.GetAll()
+	all["a"][0] = 'X'
+	all["c"] = []byte("3")
+	delete(all, "b")
+	if v, _ := k.Get("a"); string(v) != "1" {
+		t.Errorf("modifying a value changed the store: %q", v)
+	}
+	if _, ok := k.Get("c"); ok {
+		t.Error("adding to the map added to the store")
+	}
+	if _, ok := k.Get("b"); !ok {
+		t.Error("deleting from the map deleted from the store")
+	}
+
+	// nor do later writes reach the map
+	k.Set("a", []byte("new"))
+	if string(all["a"]) != "X" {
+		t.Errorf("a later Set changed the map: %q", all["a"])
+	}
+}
+
+func TestScanFunc(t *testing.T) {
+	for _, opts := range [][]Option{nil, {WithOrderedIndex()}} {
+		k, err := Create(filepath.Join(t.TempDir(), "a.log"), opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer k.Close()
+		for i := 1; i <= 5; i++ {
+			k.Set(fmt.Sprintf("n:%d", i), []byte(strconv.Itoa(i)))
+		}
+		k.Set("other", []byte("100"))
+
+		sum := 0
+		var keys []string
+		err = k.ScanFunc("n:", func(key string, value []byte) error {
+			keys = append(keys, key)
+			n, err := strconv.Atoi(string(value))
+			sum += n
+			value[0] = 'X'
+			return err
+		})
+		if err != nil || sum != 15 {
+			t.Errorf("sum = %d, %v; want 15", sum, err)
+		}
+		if got := fmt.Sprint(keys); got != "[n:1 n:2 n:3 n:4 n:5]" {
+			t.Errorf("ScanFunc visited %s", got)
+		}
+		if v, _ := k.Get("n:1"); string(v) != "1" {
+			t.Errorf("fn changed the stored value to %q", v)
+		}
+
+		stop := errors.New("stop")
+		calls := 0
+		err = k.ScanFunc("n:", func(key string, value []byte) error {
+			calls++
+			if key == "n:3" {
+				return stop
+			}
+			return nil
+		})
+		if err != stop || calls != 3 {
+			t.Errorf("aborted ScanFunc = %v after %d calls, want stop after 3", err, calls)
+		}
+	}
+}
+
+func TestScanNumeric(t *testing.T) {
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	for _, n := range []int64{100, 9, 10, 2, 1, 99, 0, math.MaxInt64} {
+		k.Set(NumericKey("id:", n), nil)
+	}
+	k.Set("id:5", nil)    // unpadded, not in NumericKey form
+	k.Set("id:x", nil)    // not a number
+	k.Set("other:7", nil) // another prefix
+
+	numbers := func(keys []string) string {
+		var ns []string
+		for _, key := range keys {
+			ns = append(ns, strings.TrimLeft(strings.TrimPrefix(key, "id:"), "0"))
+		}
+		return fmt.Sprint(ns)
+	}
+	for _, tc := range []struct {
+		lo, hi int64
+		want   string
+	}{
+		{2, 99, "[2 9 10 99]"},
+		{-5, 10, "[ 1 2 9 10]"}, // 0 trims to ""
+		{10, 10, "[10]"},
+		{11, 98, "[]"},
+		{50, 10, "[]"},
+		{100, math.MaxInt64, fmt.Sprintf("[100 %d]", int64(math.MaxInt64))},
+	} {
+		if got := numbers(k.ScanNumeric("id:", tc.lo, tc.hi)); got != tc.want {
+			t.Errorf("ScanNumeric(%d, %d) = %s, want %s", tc.lo, tc.hi, got, tc.want)
+		}
+	}
+
+	// a plain prefix scan of the padded keys is in numeric order too
+	if got := k.Scan(NumericKey("id:", 0), NumericKey("id:", 11)); numbers(got) != "[ 1 2 9 10]" {
+		t.Errorf("Scan = %v, want the padded keys in numeric order", got)
+	}
+}
+
+func TestKeyComparator(t *testing.T) {
+	// numeric order for "n:<int>" keys, which sort before any other key
+	numeric := func(a, b string) int {
+		na, errA := strconv.Atoi(strings.TrimPrefix(a, "n:"))
+		nb, errB := strconv.Atoi(strings.TrimPrefix(b, "n:"))
+		switch {
+		case errA == nil && errB == nil:
+			return na - nb
+		case errA == nil:
+			return -1
+		case errB == nil:
+			return 1
+		}
+		return strings.Compare(a, b)
+	}
+	for _, opts := range [][]Option{nil, {WithOrderedIndex()}} {
+		opts := append(opts, WithKeyComparator(numeric))
+		k, err := Create(filepath.Join(t.TempDir(), "a.log"), opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer k.Close()
+		for _, n := range []int{10, 9, 100, 2, 1} {
+			k.Set(fmt.Sprintf("n:%d", n), []byte(strconv.Itoa(n)))
+		}
+		k.Set("a", nil)
+
+		for _, tc := range []struct {
+			name string
+			got  []string
+			want string
+		}{
+			{"Keys", k.Keys(), "[n:1 n:2 n:9 n:10 n:100 a]"},
+			{"ScanPrefix", k.ScanPrefix("n:"), "[n:1 n:2 n:9 n:10 n:100]"},
+			{"Scan", k.Scan("n:2", "n:100"), "[n:2 n:9 n:10]"},
+			{"open Scan", k.Scan("n:10", ""), "[n:10 n:100 a]"},
+		} {
+			if got := fmt.Sprint(tc.got); got != tc.want {
+				t.Errorf("%s = %s, want %s", tc.name, got, tc.want)
+			}
+		}
+		if n := k.CountRange("n:2", "n:100"); n != 3 {
+			t.Errorf("CountRange = %d, want 3", n)
+		}
+		if v, _ := k.Get("n:9"); string(v) != "9" {
+			t.Errorf("Get(n:9) = %q, want 9", v)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package kv
+
+import "time"
+
+// scheduledCompact is run by the WithScheduledCompaction worker.
+func (k *KV) scheduledCompact() {
+	if !k.inCompactionWindow() {
+		return
+	}
+	// a compaction already running (ErrCompactionInProgress) makes this one
+	// unnecessary; other errors are retried next tick
+	_ = k.Compact()
+}
+
+// inCompactionWindow reports whether the clock's time of day falls inside
+// the WithCompactionWindow window, or true when none is set.
+func (k *KV) inCompactionWindow() bool {
+	from, to := k.opts.compactFrom, k.opts.compactTo
+	if from == to {
+		return true
+	}
+	now := k.opts.clock.Now()
+	y, m, d := now.Date()
+	sinceMidnight := now.Sub(time.Date(y, m, d, 0, 0, 0, 0, now.Location()))
+	if from < to {
+		return sinceMidnight >= from && sinceMidnight < to
+	}
+	// the window wraps past midnight
+	return sinceMidnight >= from || sinceMidnight < to
+}
@@ -0,0 +1,66 @@
+package kv
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestScheduledCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	clock := newFakeClock() // midnight
+	clock.Advance(12 * time.Hour)
+	k, err := Create(path, WithClock(clock),
+		WithScheduledCompaction(time.Hour), WithCompactionWindow(22*time.Hour, 4*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	clock.waitTickers(t, 1)
+
+	size := func() int64 {
+		t.Helper()
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fi.Size()
+	}
+	churn := func() int64 {
+		t.Helper()
+		for i := 0; i < 20; i++ {
+			k.Set("key", []byte("value"))
+		}
+		return size()
+	}
+
+	// ticks from 13:00 to 21:00 are outside the window; the extra Advance
+	// waits for the last tick's work
+	before := churn()
+	for i := 0; i < 9; i++ {
+		clock.Advance(time.Hour)
+	}
+	if got := size(); got != before {
+		t.Fatalf("log went from %d to %d bytes outside the window", before, got)
+	}
+
+	// 22:00 is inside it
+	clock.Advance(time.Hour)
+	clock.Advance(time.Hour)
+	if got := size(); got >= before {
+		t.Errorf("log still %d bytes after a tick inside the window", got)
+	}
+
+	// and it keeps firing through the night
+	before = churn()
+	clock.Advance(time.Hour)
+	clock.Advance(time.Hour)
+	if got := size(); got >= before {
+		t.Errorf("log still %d bytes after another tick", got)
+	}
+
+	// Close stops the worker
+	k.Close()
+	clock.Advance(time.Hour)
+}
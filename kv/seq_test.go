@@ -0,0 +1,115 @@
+package kv
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestNextID(t *testing.T) {
+	for _, prealloc := range []int{0, 10} {
+		path := filepath.Join(t.TempDir(), "a.log")
+		k, err := Create(path, WithIDPreallocation(prealloc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var last uint64
+		next := func(k *KV) {
+			t.Helper()
+			id, err := k.NextID("orders")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id <= last {
+				t.Errorf("prealloc %d: NextID = %d after %d", prealloc, id, last)
+			}
+			last = id
+		}
+		for i := 0; i < 4; i++ {
+			next(k)
+		}
+		if last != 4 {
+			t.Errorf("prealloc %d: fourth ID = %d, want 4", prealloc, last)
+		}
+		if id, _ := k.NextID("users"); id != 1 {
+			t.Errorf("prealloc %d: a second sequence starts at %d, want 1", prealloc, id)
+		}
+		k.Close()
+
+		// reopening mid-block skips the rest of it rather than reusing IDs
+		k, err = Open(path, WithIDPreallocation(prealloc))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 15; i++ {
+			next(k)
+		}
+		if prealloc > 0 && last != 10+15 {
+			t.Errorf("prealloc %d: last ID = %d, want 25 after the skipped block", prealloc, last)
+		}
+		k.Close()
+	}
+}
+
+func TestNextIDConcurrent(t *testing.T) {
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"), WithIDPreallocation(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	var mu sync.Mutex
+	seen := make(map[uint64]bool)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				id, err := k.NextID("s")
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				if seen[id] {
+					t.Errorf("ID %d issued twice", id)
+				}
+				seen[id] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(seen) != 400 {
+		t.Errorf("%d distinct IDs, want 400", len(seen))
+	}
+}
+
+func TestNextIDDurableWithoutSync(t *testing.T) {
+	for _, opts := range [][]Option{{WithNoSync()}, {WithSyncEvery(100)}} {
+		path := filepath.Join(t.TempDir(), "a.log")
+		s := newCrashStorage()
+		vetoAll := func(EntryType, string, []byte) ([]byte, error) { return nil, errInjected }
+		opts := append(opts, WithStorage(s), WithWriteInterceptor(vetoAll))
+		k, err := Create(path, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var last uint64
+		for i := 0; i < 3; i++ {
+			if last, err = k.NextID("orders"); err != nil {
+				t.Fatalf("NextID with every write vetoed = %v, want the interceptor bypassed", err)
+			}
+		}
+		// the process dies without Close or Sync
+		s.crash()
+		k, err = Open(path, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id, err := k.NextID("orders"); err != nil || id <= last {
+			t.Errorf("NextID after a crash = %d, %v; want more than %d", id, err, last)
+		}
+		k.Close()
+	}
+}
@@ -0,0 +1,173 @@
+package kv
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// standbyPollInterval is how often a standby checks the primary's log for
+// new entries.
+const standbyPollInterval = 100 * time.Millisecond
+
+// standby tracks how far a read-only KV has followed its primary's log.
+type standby struct {
+	offset   int64 // end of the last applied entry
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func (sb *standby) halt() {
+	sb.stopOnce.Do(func() { close(sb.stop) })
+	<-sb.done
+}
+
+// OpenStandby opens the log written by another process read-only, replays
+// it, and then keeps following it like tail -f, applying entries as the
+// primary appends them. If the primary compacts or resets its log, the
+// standby reloads from the new file. Writes fail with ErrReadOnly until
+// Promote is called. WithMemoryReporter and WithExpirySweep run from the
+// start; WithScheduledCompaction and WithTombstoneCompaction take effect
+// once the standby is promoted.
+func OpenStandby(primaryLogPath string, opts ...Option) (*KV, error) {
+	o, err := resolveOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	s := newFileStorage(true)
+	k := newKV(primaryLogPath, s, o)
+	lf, ok, err := readHeader(storageReader{s, primaryLogPath})
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	if !ok {
+		s.Close()
+		return nil, fmt.Errorf("primary log %s has no header yet", primaryLogPath)
+	}
+	k.format = lf
+	k.lsn = lf.baseLSN
+	started := o.clock.Now()
+	replayed, end, _, err := k.replayFrom(lf.headerLen())
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	k.openInfo = OpenInfo{
+		EntriesReplayed: replayed,
+		KeysLoaded:      len(k.data),
+		BytesRead:       end,
+		ReplayDuration:  o.clock.Now().Sub(started),
+	}
+	k.standby = &standby{
+		offset: end,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go k.followPrimary(k.standby)
+	k.startMemoryWorkers()
+	return k, nil
+}
+
+func (k *KV) followPrimary(sb *standby) {
+	defer close(sb.done)
+	t := time.NewTicker(standbyPollInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-sb.stop:
+			return
+		case <-t.C:
+			// errors are transient from here (e.g. mid-rename); retry next tick
+			_ = k.catchUp(sb)
+		}
+	}
+}
+
+// catchUp applies whatever the primary appended since the last call, or
+// reloads everything if the log file was replaced or shrank.
+func (k *KV) catchUp(sb *standby) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.closed {
+		return ErrClosed
+	}
+	same, err := k.store.(*fileStorage).sameFile(k.logPath)
+	if err != nil {
+		return err
+	}
+	size, err := k.store.Size(k.logPath)
+	if err != nil {
+		return err
+	}
+	if !same || size < sb.offset {
+		return k.reloadStandby(sb)
+	}
+	if size == sb.offset {
+		return nil
+	}
+	_, end, _, err := k.replayFrom(sb.offset)
+	if err != nil {
+		return err
+	}
+	sb.offset = end
+	return nil
+}
+
+// reloadStandby rebuilds memory from the file now at k.logPath.
+// Callers must hold k.mu for writing.
+func (k *KV) reloadStandby(sb *standby) error {
+	k.store.(*fileStorage).forget(k.logPath)
+	lf, ok, err := readHeader(storageReader{k.store, k.logPath})
+	if err != nil || !ok {
+		return err
+	}
+	k.clearMemory()
+	k.format = lf
+	k.lsn = lf.baseLSN
+	_, end, _, err := k.replayFrom(lf.headerLen())
+	if err != nil {
+		return err
+	}
+	sb.offset = end
+	return nil
+}
+
+// Promote turns a standby into a writable KV: it stops following, applies
+// anything still outstanding, reopens the log for appends and starts the
+// background compactions configured by options. The old primary must no
+// longer be writing to the file.
+func (k *KV) Promote() error {
+	k.mu.RLock()
+	sb := k.standby
+	k.mu.RUnlock()
+	if sb == nil {
+		return ErrNotStandby
+	}
+	sb.halt()
+	if err := k.catchUp(sb); err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	s := newFileStorage(false)
+	// drop any torn tail the primary left behind
+	if err := s.Truncate(k.logPath, sb.offset); err != nil {
+		s.Close()
+		return err
+	}
+	k.store.Close()
+	k.store = s
+	k.standby = nil
+	if err := k.rebuildMirror(); err != nil {
+		return err
+	}
+	// Close snapshots the workers after cancelling closeCtx, so workers
+	// started before that are seen and stopped
+	if k.closeCtx.Err() == nil {
+		k.startCompactionWorkers()
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package kv
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// waitValue waits for the standby k to show value for key.
+func waitValue(t *testing.T, k *KV, key, value string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		v, ok := k.Get(key)
+		if ok && string(v) == value {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("standby has %s = %q, %v; want %q", key, v, ok, value)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStandbyFollowsPrimary(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	primary, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+	primary.Set("a", []byte("1"))
+
+	sb, err := OpenStandby(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sb.Close()
+	if v, _ := sb.Get("a"); string(v) != "1" {
+		t.Errorf("standby opened with a = %q, want 1", v)
+	}
+	if err := sb.Set("b", nil); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("Set on a standby = %v, want ErrReadOnly", err)
+	}
+
+	primary.Set("a", []byte("2"))
+	primary.Set("b", []byte("x"))
+	waitValue(t, sb, "b", "x")
+	waitValue(t, sb, "a", "2")
+
+	// a compaction replaces the file under the standby
+	primary.Del("b")
+	if err := primary.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	primary.Set("c", []byte("3"))
+	waitValue(t, sb, "c", "3")
+	if _, ok := sb.Get("b"); ok {
+		t.Error("standby still has a key deleted before the compaction")
+	}
+
+	primary.Close()
+	if err := sb.Promote(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sb.Set("d", []byte("4")); err != nil {
+		t.Fatalf("Set after Promote = %v", err)
+	}
+	if err := sb.Promote(); !errors.Is(err, ErrNotStandby) {
+		t.Errorf("second Promote = %v, want ErrNotStandby", err)
+	}
+	sb.Close()
+	k, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	for key, want := range map[string]string{"a": "2", "c": "3", "d": "4"} {
+		if v, _ := k.Get(key); string(v) != want {
+			t.Errorf("%s = %q after Promote, want %q", key, v, want)
+		}
+	}
+}
+
+func TestStandbyWorkers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	primary, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		primary.Set("key", []byte("value"))
+	}
+
+	clock := newFakeClock()
+	reports := make(chan int64, 1)
+	sb, err := OpenStandby(path, WithClock(clock),
+		WithMemoryReporter(time.Minute, func(n int64) {
+			select {
+			case reports <- n:
+			default:
+			}
+		}),
+		WithScheduledCompaction(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sb.Close()
+
+	// the memory reporter runs on the standby, compaction does not
+	clock.waitTickers(t, 1)
+	clock.Advance(time.Minute)
+	select {
+	case <-reports:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no memory report from the standby")
+	}
+	clock.mu.Lock()
+	tickers := len(clock.tickers)
+	clock.mu.Unlock()
+	if tickers != 1 {
+		t.Errorf("%d tickers on a standby, want only the memory reporter's", tickers)
+	}
+
+	// once promoted, scheduled compaction runs too
+	primary.Close()
+	if err := sb.Promote(); err != nil {
+		t.Fatal(err)
+	}
+	clock.waitTickers(t, 2)
+	before, _ := sb.store.Size(path)
+	clock.Advance(time.Hour)
+	clock.Advance(time.Hour) // waits for the first tick's compaction
+	if after, _ := sb.store.Size(path); after >= before {
+		t.Errorf("log still %d bytes after a scheduled compaction on the promoted standby", after)
+	}
+}
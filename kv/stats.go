@@ -0,0 +1,67 @@
+package kv
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+)
+
+// Stats summarizes the live data set.
+type Stats struct {
+	// Keys is the number of live keys.
+	Keys int
+	// TotalKeyBytes is the sum of the lengths of all live keys.
+	TotalKeyBytes int64
+	// TotalValueBytes is the sum of the lengths of all live values.
+	TotalValueBytes int64
+}
+
+// Stats returns the current totals. They are maintained on every write, so
+// this does not walk the data set.
+func (k *KV) Stats() Stats {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return Stats{
+		Keys:            len(k.data),
+		TotalKeyBytes:   k.keyBytes,
+		TotalValueBytes: k.valueBytes,
+	}
+}
+
+// ApproxLen returns the number of keys without taking the lock, so it stays
+// cheap however busy the database is. It reads a counter maintained by
+// writes, which may be momentarily off while writes are in flight, and it
+// still counts keys whose TTL has passed until Compact or a WithExpirySweep
+// sweep removes them.
+func (k *KV) ApproxLen() int {
+	return int(k.keyCount.Load())
+}
+
+// StateHash returns a SHA-256 digest of every live key and value in key
+// order, so two databases holding the same data hash alike however they got
+// there: different write histories, compaction or replication. Each key and
+// value is length-prefixed, so distinct data sets cannot collide by
+// concatenation. It reads the whole data set under the read lock.
+func (k *KV) StateHash() [32]byte {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	h := sha256.New()
+	var n [4]byte
+	keys := k.rangeKeys("", "")
+	if k.opts.keyCmp != nil {
+		// the hash does not depend on WithKeyComparator
+		sort.Strings(keys)
+	}
+	for _, key := range keys {
+		val := k.data[key]
+		binary.BigEndian.PutUint32(n[:], uint32(len(key)))
+		h.Write(n[:])
+		h.Write([]byte(key))
+		binary.BigEndian.PutUint32(n[:], uint32(len(val)))
+		h.Write(n[:])
+		h.Write(val)
+	}
+	var sum [32]byte
+	h.Sum(sum[:0])
+	return sum
+}
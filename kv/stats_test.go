@@ -0,0 +1,159 @@
+package kv
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestStatsByteTotals(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	k, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(k *KV, keys int, keyBytes, valueBytes int64) {
+		t.Helper()
+		want := Stats{Keys: keys, TotalKeyBytes: keyBytes, TotalValueBytes: valueBytes}
+		if got := k.Stats(); got != want {
+			t.Errorf("Stats = %+v, want %+v", got, want)
+		}
+	}
+	k.Set("ab", []byte("12345"))
+	k.Set("c", []byte("1"))
+	check(k, 2, 3, 6)
+	k.Set("ab", []byte("12"))
+	check(k, 2, 3, 3)
+	k.Del("c")
+	check(k, 1, 2, 2)
+	k.Del("missing")
+	var b Batch
+	b.Set("d", []byte("xyz"))
+	b.Set("ab", []byte("1234"))
+	b.Del("ab")
+	k.WriteBatch(&b)
+	check(k, 1, 1, 3)
+	k.Close()
+
+	// replay arrives at the same totals
+	k, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	check(k, 1, 1, 3)
+	k.Compact()
+	check(k, 1, 1, 3)
+}
+
+func TestApproxLen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	k, err := Create(path, WithNoSync())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				key := fmt.Sprintf("g%d:%d", g, i)
+				k.Set(key, nil)
+				k.Set(key, []byte("again"))
+				if i%4 == 0 {
+					k.Del(key)
+					k.Del(key)
+				}
+			}
+		}()
+	}
+	// reading while writes are in flight is safe and roughly right
+	for i := 0; i < 100; i++ {
+		if n := k.ApproxLen(); n < 0 || n > 800 {
+			t.Fatalf("ApproxLen = %d mid-workload", n)
+		}
+	}
+	wg.Wait()
+	if n, want := k.ApproxLen(), len(k.Keys()); n != want || n != 600 {
+		t.Errorf("ApproxLen = %d, Keys has %d, want 600", n, want)
+	}
+	k.Close()
+	k, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	if n := k.ApproxLen(); n != 600 {
+		t.Errorf("ApproxLen after reopen = %d, want 600", n)
+	}
+}
+
+func TestStateHash(t *testing.T) {
+	dir := t.TempDir()
+	k, err := Create(filepath.Join(dir, "a.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	var feed syncBuffer
+	stop := k.StreamChanges(&feed)
+	for i := 0; i < 50; i++ {
+		k.Set(fmt.Sprintf("k%d", i%20), []byte(fmt.Sprint(i)))
+	}
+	k.Del("k3")
+	var b Batch
+	b.Set("b1", []byte("x"))
+	b.Del("k4")
+	k.WriteBatch(&b)
+	stop()
+	hash := k.StateHash()
+
+	if err := k.CompactTo(filepath.Join(dir, "copy.log")); err != nil {
+		t.Fatal(err)
+	}
+	compacted, err := Open(filepath.Join(dir, "copy.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer compacted.Close()
+	if compacted.StateHash() != hash {
+		t.Error("compacted copy hashes differently")
+	}
+
+	replica, err := Create(filepath.Join(dir, "replica.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replica.Close()
+	for _, l := range parseChanges(t, &feed.buf) {
+		switch l.Op {
+		case "set":
+			replica.Set(l.Key, l.Value)
+		case "del":
+			replica.Del(l.Key)
+		}
+	}
+	if replica.StateHash() != hash {
+		t.Error("replica built from the change stream hashes differently")
+	}
+
+	replica.Set("k0", []byte("diverged"))
+	if replica.StateHash() == hash {
+		t.Error("a changed value kept the same hash")
+	}
+	// length prefixes keep "ab"+"c" apart from "a"+"bc"
+	dbs, pairs := make([]*KV, 2), [][2]string{{"ab", "c"}, {"a", "bc"}}
+	for i, p := range pairs {
+		if dbs[i], err = Create(filepath.Join(dir, fmt.Sprintf("x%d.log", i))); err != nil {
+			t.Fatal(err)
+		}
+		defer dbs[i].Close()
+		dbs[i].Set(p[0], []byte(p[1]))
+	}
+	if dbs[0].StateHash() == dbs[1].StateHash() {
+		t.Error("different key/value splits collide")
+	}
+}
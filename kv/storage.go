@@ -0,0 +1,309 @@
+package kv
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Storage is where a KV keeps its files: the log, plus the temporary and
+// checkpoint files written next to it. Files are named by path and hold
+// bytes appended in order. The default, used unless WithStorage is given,
+// is the local file system.
+//
+// A KV serializes writes to a file but may call ReadAt concurrently with
+// other ReadAt calls.
+type Storage interface {
+	// Append writes p at the end of the named file, creating it if needed.
+	Append(name string, p []byte) error
+	// ReadAt reads from the named file like io.ReaderAt.
+	ReadAt(name string, p []byte, off int64) (int, error)
+	// Sync makes everything appended to the named file durable.
+	Sync(name string) error
+	// Size returns the length of the named file, or an error matching
+	// fs.ErrNotExist if there is none.
+	Size(name string) (int64, error)
+	// Truncate cuts the named file to size bytes.
+	Truncate(name string, size int64) error
+	// Rename atomically and durably replaces newName with oldName.
+	Rename(oldName, newName string) error
+	// Remove durably deletes the named file, returning an error matching
+	// fs.ErrNotExist if there is none.
+	Remove(name string) error
+	// Close releases anything the Storage holds open. It is called by
+	// KV.Close.
+	Close() error
+}
+
+// storageReader reads one file of a Storage as an io.ReaderAt.
+type storageReader struct {
+	s    Storage
+	name string
+}
+
+func (r storageReader) ReadAt(p []byte, off int64) (int, error) {
+	return r.s.ReadAt(r.name, p, off)
+}
+
+// sectionFrom returns a reader over the named file from off to its current
+// end.
+func sectionFrom(s Storage, name string, off int64) (io.Reader, error) {
+	size, err := s.Size(name)
+	if err != nil {
+		return nil, err
+	}
+	return io.NewSectionReader(storageReader{s, name}, off, max(size-off, 0)), nil
+}
+
+// fileStorage is the default Storage, backed by the local file system. It
+// keeps a handle open per file and fsyncs directories after creating or
+// renaming files so the directory entries are durable too.
+type fileStorage struct {
+	mu       sync.Mutex
+	files    map[string]*os.File
+	readOnly bool
+}
+
+func newFileStorage(readOnly bool) *fileStorage {
+	return &fileStorage{files: make(map[string]*os.File), readOnly: readOnly}
+}
+
+// file returns the open handle for name, opening it if needed. With create
+// a missing file is created.
+func (s *fileStorage) file(name string, create bool) (*os.File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f, ok := s.files[name]; ok {
+		return f, nil
+	}
+	var f *os.File
+	var err error
+	switch {
+	case s.readOnly:
+		f, err = os.Open(name)
+	case create:
+		f, err = os.OpenFile(name, os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_EXCL, 0o664)
+		if err == nil {
+			// make the new directory entry durable
+			if err := syncDir(filepath.Dir(name)); err != nil {
+				f.Close()
+				return nil, err
+			}
+			break
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+		fallthrough
+	default:
+		f, err = os.OpenFile(name, os.O_RDWR|os.O_APPEND, 0o664)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.files[name] = f
+	return f, nil
+}
+
+// forget closes and drops the handle for name, if one is open.
+func (s *fileStorage) forget(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if f, ok := s.files[name]; ok {
+		f.Close()
+		delete(s.files, name)
+	}
+}
+
+func (s *fileStorage) Append(name string, p []byte) error {
+	f, err := s.file(name, true)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(p)
+	return err
+}
+
+func (s *fileStorage) ReadAt(name string, p []byte, off int64) (int, error) {
+	f, err := s.file(name, false)
+	if err != nil {
+		return 0, err
+	}
+	return f.ReadAt(p, off)
+}
+
+func (s *fileStorage) Sync(name string) error {
+	f, err := s.file(name, false)
+	if err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+func (s *fileStorage) Size(name string) (int64, error) {
+	s.mu.Lock()
+	f, ok := s.files[name]
+	s.mu.Unlock()
+	var fi os.FileInfo
+	var err error
+	if ok {
+		fi, err = f.Stat()
+	} else {
+		fi, err = os.Stat(name)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+func (s *fileStorage) Truncate(name string, size int64) error {
+	f, err := s.file(name, false)
+	if err != nil {
+		return err
+	}
+	return f.Truncate(size)
+}
+
+func (s *fileStorage) Rename(oldName, newName string) error {
+	s.forget(oldName)
+	s.forget(newName)
+	if err := os.Rename(oldName, newName); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(newName)); err != nil {
+		return err
+	}
+	if dir := filepath.Dir(oldName); dir != filepath.Dir(newName) {
+		return syncDir(dir)
+	}
+	return nil
+}
+
+func (s *fileStorage) Remove(name string) error {
+	s.forget(name)
+	if err := os.Remove(name); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(name))
+}
+
+func (s *fileStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var first error
+	for name, f := range s.files {
+		if err := f.Close(); err != nil && first == nil {
+			first = err
+		}
+		delete(s.files, name)
+	}
+	return first
+}
+
+// sameFile reports whether the open handle for name still refers to the
+// file at that path, i.e. it has not been replaced by a rename.
+func (s *fileStorage) sameFile(name string) (bool, error) {
+	f, err := s.file(name, false)
+	if err != nil {
+		return false, err
+	}
+	cur, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	onDisk, err := os.Stat(name)
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(cur, onDisk), nil
+}
+
+// memStorage is a Storage held entirely in memory.
+type memStorage struct {
+	mu    sync.RWMutex
+	files map[string][]byte
+}
+
+// NewMemoryStorage returns a Storage that keeps files in memory, for tests
+// and throwaway databases. Nothing survives the process, but a KV reopened
+// on the same Storage value sees what was written before.
+func NewMemoryStorage() Storage {
+	return &memStorage{files: make(map[string][]byte)}
+}
+
+func (m *memStorage) Append(name string, p []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[name] = append(m.files[name], p...)
+	return nil
+}
+
+func (m *memStorage) ReadAt(name string, p []byte, off int64) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	data, ok := m.files[name]
+	if !ok {
+		return 0, &fs.PathError{Op: "read", Path: name, Err: fs.ErrNotExist}
+	}
+	if off >= int64(len(data)) {
+		return 0, io.EOF
+	}
+	return bytes.NewReader(data).ReadAt(p, off)
+}
+
+func (m *memStorage) Sync(string) error { return nil }
+
+func (m *memStorage) Size(name string) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	data, ok := m.files[name]
+	if !ok {
+		return 0, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return int64(len(data)), nil
+}
+
+func (m *memStorage) Truncate(name string, size int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.files[name]
+	if !ok {
+		return &fs.PathError{Op: "truncate", Path: name, Err: fs.ErrNotExist}
+	}
+	if size < int64(len(data)) {
+		// copy so readers of the old contents are unaffected by appends
+		m.files[name] = append([]byte(nil), data[:size]...)
+	} else {
+		m.files[name] = append(data, make([]byte, size-int64(len(data)))...)
+	}
+	return nil
+}
+
+func (m *memStorage) Rename(oldName, newName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.files[oldName]
+	if !ok {
+		return &fs.PathError{Op: "rename", Path: oldName, Err: fs.ErrNotExist}
+	}
+	m.files[newName] = data
+	delete(m.files, oldName)
+	return nil
+}
+
+func (m *memStorage) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.files[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(m.files, name)
+	return nil
+}
+
+func (m *memStorage) Close() error { return nil }
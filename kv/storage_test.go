@@ -0,0 +1,214 @@
+package kv
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// crashStorage is a fake Storage that keeps appended bytes pending until
+// Sync, so crash can throw away whatever was never synced.
+type crashStorage struct {
+	mu      sync.Mutex
+	durable map[string][]byte
+	pending map[string][]byte
+	ops     map[string]int
+}
+
+func newCrashStorage() *crashStorage {
+	return &crashStorage{
+		durable: make(map[string][]byte),
+		pending: make(map[string][]byte),
+		ops:     make(map[string]int),
+	}
+}
+
+func (s *crashStorage) file(name string) ([]byte, bool) {
+	d, ok := s.durable[name]
+	p, pok := s.pending[name]
+	return append(append([]byte(nil), d...), p...), ok || pok
+}
+
+func (s *crashStorage) Append(name string, p []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ops["append"]++
+	s.pending[name] = append(s.pending[name], p...)
+	return nil
+}
+
+func (s *crashStorage) ReadAt(name string, p []byte, off int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.file(name)
+	if !ok {
+		return 0, fs.ErrNotExist
+	}
+	if off >= int64(len(data)) {
+		return 0, io.EOF
+	}
+	return bytes.NewReader(data).ReadAt(p, off)
+}
+
+func (s *crashStorage) Sync(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ops["sync"]++
+	s.durable[name] = append(s.durable[name], s.pending[name]...)
+	delete(s.pending, name)
+	return nil
+}
+
+func (s *crashStorage) Size(name string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.file(name)
+	if !ok {
+		return 0, fs.ErrNotExist
+	}
+	return int64(len(data)), nil
+}
+
+func (s *crashStorage) Truncate(name string, size int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.file(name)
+	if !ok {
+		return fs.ErrNotExist
+	}
+	s.durable[name] = data[:min(size, int64(len(data)))]
+	delete(s.pending, name)
+	return nil
+}
+
+func (s *crashStorage) Rename(oldName, newName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ops["rename"]++
+	data, ok := s.file(oldName)
+	if !ok {
+		return fs.ErrNotExist
+	}
+	s.durable[newName] = data
+	delete(s.pending, newName)
+	delete(s.durable, oldName)
+	delete(s.pending, oldName)
+	return nil
+}
+
+func (s *crashStorage) Remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.file(name); !ok {
+		return fs.ErrNotExist
+	}
+	delete(s.durable, name)
+	delete(s.pending, name)
+	return nil
+}
+
+func (s *crashStorage) Close() error { return nil }
+
+// crash loses everything not synced.
+func (s *crashStorage) crash() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = make(map[string][]byte)
+}
+
+func TestFakeStorage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	s := newCrashStorage()
+	k, err := Create(path, WithStorage(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		k.Set("a", []byte{byte('0' + i)})
+	}
+	var b Batch
+	b.Set("b", []byte("2"))
+	b.Set("c", []byte("3"))
+	k.WriteBatch(&b)
+	k.Del("c")
+	if err := k.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	k.Set("d", []byte("4"))
+	k.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("the KV touched the file system: %v", err)
+	}
+	if s.ops["append"] == 0 || s.ops["sync"] == 0 || s.ops["rename"] == 0 {
+		t.Errorf("operations seen: %v", s.ops)
+	}
+
+	// every write was synced before returning, so a crash loses none
+	s.crash()
+	k, err = Open(path, WithStorage(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprintf("%q", k.GetAll()); got != `map["a":"9" "b":"2" "d":"4"]` {
+		t.Errorf("after a crash: %s", got)
+	}
+	k.Close()
+
+	// with WithNoSync only what Sync covered survives
+	k, err = Open(path, WithStorage(s), WithNoSync())
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.Set("e", []byte("5"))
+	k.Sync()
+	k.Set("f", []byte("6"))
+	// the process dies: k is abandoned without Close
+	s.crash()
+	k, err = Open(path, WithStorage(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	if got := fmt.Sprintf("%q", k.GetAll()); got != `map["a":"9" "b":"2" "d":"4" "e":"5"]` {
+		t.Errorf("after an unsynced write and a crash: %s", got)
+	}
+}
+
+func TestNoSyncCleanClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	s := newCrashStorage()
+	k, err := Create(path, WithStorage(s), WithNoSync())
+	if err != nil {
+		t.Fatal(err)
+	}
+	syncs := s.ops["sync"]
+	for i := 0; i < 10; i++ {
+		k.Set(fmt.Sprintf("k%d", i), []byte("v"))
+	}
+	k.Del("k0")
+	if n := s.ops["sync"]; n != syncs {
+		t.Errorf("writes synced %d times, want none", n-syncs)
+	}
+	if err := k.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Close synced every write, so a crash afterwards loses nothing
+	s.crash()
+	k, err = Open(path, WithStorage(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	if n := k.Stats().Keys; n != 9 {
+		t.Errorf("%d keys after a clean Close and a crash, want 9", n)
+	}
+	if _, ok := k.Get("k0"); ok {
+		t.Error("the delete was lost")
+	}
+}
@@ -0,0 +1,57 @@
+package kv
+
+import "fmt"
+
+// SwapFile replaces the whole dataset with the log at path, for example one
+// built with a separate KV and closed, in one step: readers see either the
+// old data or the new, never a mix. path is replayed in full first, so a
+// damaged file is rejected with the active log untouched; it is then renamed
+// over the active log, so it must be in the same Storage (by default, on
+// the same file system) and no longer be open for writing. Change streams
+// are not told about the swap, and LastLSN continues from the new file's
+// numbering.
+func (k *KV) SwapFile(path string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.checkRewritable(); err != nil {
+		return err
+	}
+
+	lf, ok, err := readHeader(storageReader{k.store, path})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("kv: %s has no log header", path)
+	}
+	next := newKV(path, k.store, k.opts)
+	next.format = lf
+	next.lsn = lf.baseLSN
+	_, _, tail, err := next.replayFrom(lf.headerLen())
+	if err != nil {
+		return err
+	}
+	if tail != nil {
+		return tail
+	}
+
+	// the checkpoint describes the old log
+	if err := removeCheckpoint(k.store, k.logPath); err != nil {
+		return err
+	}
+	if err := k.store.Rename(path, k.logPath); err != nil {
+		return err
+	}
+	k.format = next.format
+	k.lsn = next.lsn
+	k.markDurable()
+	k.data, k.meta, k.writeOrder = next.data, next.meta, next.writeOrder
+	k.index, k.expiry, k.aliases = next.index, next.expiry, next.aliases
+	k.idle = nil
+	k.reverse = next.reverse
+	k.tombstones = next.tombstones
+	k.keyBytes, k.valueBytes = next.keyBytes, next.valueBytes
+	k.seqs = nil
+	k.keyCount.Store(next.keyCount.Load())
+	return k.rebuildMirror()
+}
@@ -0,0 +1,84 @@
+package kv
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSwapFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.log")
+	k, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	k.Set("old", []byte("1"))
+	k.Set("shared", []byte("old"))
+
+	nextPath := filepath.Join(dir, "next.log")
+	next, err := Create(nextPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next.Set("new", []byte("2"))
+	next.Set("shared", []byte("new"))
+	next.Close()
+
+	if err := k.SwapFile(nextPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := k.Get("old"); ok {
+		t.Error("old key survived the swap")
+	}
+	for key, want := range map[string]string{"new": "2", "shared": "new"} {
+		if v, _ := k.Get(key); string(v) != want {
+			t.Errorf("Get(%q) = %q, want %q", key, v, want)
+		}
+	}
+	if _, err := os.Stat(nextPath); !os.IsNotExist(err) {
+		t.Errorf("swapped-in file still at its old path: %v", err)
+	}
+	// writes after the swap go to the new log
+	k.Set("after", []byte("3"))
+	k.Close()
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if keys := reopened.Keys(); len(keys) != 3 {
+		t.Errorf("keys after reopen = %q", keys)
+	}
+}
+
+func TestSwapFileRejectsDamagedFile(t *testing.T) {
+	s := NewMemoryStorage()
+	k, err := Create("a.log", WithStorage(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	k.Set("keep", []byte("1"))
+
+	next, err := Create("next.log", WithStorage(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	next.Set("new", []byte("2"))
+	next.Close()
+	size, err := s.Size("next.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	flipByte(t, s, "next.log", size-1)
+
+	if err := k.SwapFile("next.log"); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("SwapFile of a damaged file: err = %v", err)
+	}
+	if v, _ := k.Get("keep"); string(v) != "1" {
+		t.Errorf("Get(keep) = %q after a failed swap", v)
+	}
+}
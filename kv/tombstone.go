@@ -0,0 +1,84 @@
+package kv
+
+// minTombstonesForRatio is how many tombstones there must be before
+// WithTombstoneCompaction's ratio is checked, so a small database is not
+// compacted on every delete.
+const minTombstonesForRatio = 64
+
+// WithTombstoneCompaction compacts in the background once the log holds at
+// least maxCount delete entries, or once deletes make up at least maxRatio
+// of the delete entries and live keys together. Either threshold is off
+// when zero. It suits delete-heavy workloads such as queues and sessions,
+// whose logs fill with tombstones while few keys stay live. Deletes are
+// counted from open, so ones already in a checkpointed part of the log are
+// not, and the count restarts after each compaction.
+func WithTombstoneCompaction(maxCount int, maxRatio float64) Option {
+	return func(o *options) {
+		o.tombstoneCount = maxCount
+		o.tombstoneRatio = maxRatio
+	}
+}
+
+// countTombstones adds the delete entries among payloads to the count
+// behind WithTombstoneCompaction and wakes its worker once a threshold is
+// reached. Callers must hold k.mu for writing.
+func (k *KV) countTombstones(payloads ...[]byte) {
+	for _, p := range payloads {
+		if len(p) > 0 && EntryType(p[0]) == OpDel {
+			k.tombstones++
+		}
+	}
+	if k.tombstoneKick == nil || !k.tooManyTombstones() {
+		return
+	}
+	select {
+	case k.tombstoneKick <- struct{}{}:
+	default:
+		// the worker already has a wakeup pending
+	}
+}
+
+// tooManyTombstones reports whether a WithTombstoneCompaction threshold is
+// reached. Callers must hold k.mu.
+func (k *KV) tooManyTombstones() bool {
+	n := k.tombstones
+	if limit := k.opts.tombstoneCount; limit > 0 && n >= limit {
+		return true
+	}
+	ratio := k.opts.tombstoneRatio
+	if ratio <= 0 || n < minTombstonesForRatio {
+		return false
+	}
+	return float64(n)/float64(n+len(k.data)) >= ratio
+}
+
+// startTombstoneWorker starts the worker that compacts when
+// countTombstones wakes it, at once if replay already found too many. It
+// must only be called while opening, or by Promote with k.mu held for
+// writing.
+func (k *KV) startTombstoneWorker() {
+	k.tombstoneKick = make(chan struct{}, 1)
+	if k.tooManyTombstones() {
+		k.tombstoneKick <- struct{}{}
+	}
+	w := &worker{stop: make(chan struct{}), done: make(chan struct{})}
+	k.workers = append(k.workers, w)
+	go func() {
+		defer close(w.done)
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-k.tombstoneKick:
+				k.mu.RLock()
+				due := k.tooManyTombstones()
+				k.mu.RUnlock()
+				if due {
+					// as with scheduled compaction, a failure waits for
+					// the next delete to try again
+					_ = k.Compact()
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,69 @@
+package kv
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// waitCompacted waits until a background compaction has cut the log below
+// size.
+func waitCompacted(t *testing.T, k *KV, path string, size int64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if got, _ := k.store.Size(path); got < size {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("the deletes did not trigger a compaction")
+}
+
+func TestTombstoneCompaction(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		count    int
+		ratio    float64
+		deletes  int
+		triggers bool
+	}{
+		{"count", 50, 0, 50, true},
+		{"under count", 50, 0, 49, false},
+		{"ratio", 0, 0.5, 70, true},
+		{"ratio below the minimum", 0, 0.1, minTombstonesForRatio - 1, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "a.log")
+			k, err := Create(path, WithTombstoneCompaction(tc.count, tc.ratio))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer k.Close()
+			for i := 0; i < 100; i++ {
+				k.Set(fmt.Sprintf("k%03d", i), []byte("value"))
+			}
+			// a compaction leaves the log smaller than before the deletes
+			before, _ := k.store.Size(path)
+			for i := 0; i < tc.deletes; i++ {
+				k.Del(fmt.Sprintf("k%03d", i))
+			}
+			if tc.triggers {
+				waitCompacted(t, k, path, before)
+			} else {
+				size, _ := k.store.Size(path)
+				time.Sleep(20 * time.Millisecond)
+				if got, _ := k.store.Size(path); got != size {
+					t.Fatalf("the log went from %d to %d bytes, want no compaction", size, got)
+				}
+			}
+			if n := k.Stats().Keys; n != 100-tc.deletes {
+				t.Errorf("%d keys, want %d", n, 100-tc.deletes)
+			}
+			if v, _ := k.Get("k099"); string(v) != "value" {
+				t.Errorf("Get(k099) = %q, want value", v)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package kv
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// buildExpirePayload encodes [type][key len][key][8 bytes unix nanos].
+func buildExpirePayload(key []byte, at time.Time) []byte {
+	buf := make([]byte, 0, 1+4+len(key)+8)
+	buf = append(buf, byte(OpExpire))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(key)))
+	buf = append(buf, key...)
+	return binary.BigEndian.AppendUint64(buf, uint64(at.UnixNano()))
+}
+
+func decodeExpire(payload []byte) (string, time.Time, error) {
+	key, err := decodeDel(payload[:max(len(payload)-8, 0)])
+	if err != nil || len(payload) < 1+4+len(key)+8 {
+		return "", time.Time{}, fmt.Errorf("%w: expire entry", ErrMalformedEntry)
+	}
+	nanos := int64(binary.BigEndian.Uint64(payload[len(payload)-8:]))
+	return key, time.Unix(0, nanos), nil
+}
+
+// SetWithTTL sets key to value and expires it after ttl. The value and its
+// expiry are written as one atomic group. Expired keys are hidden from
+// reads right away but stay in memory, counted by Stats and ApproxLen,
+// until the next Compact reclaims them and drops them from the log, or
+// until a WithExpirySweep sweep. A later Set or Del of the key clears the
+// TTL.
+func (k *KV) SetWithTTL(key string, value []byte, ttl time.Duration) error {
+	release, err := k.admit()
+	if err != nil {
+		return err
+	}
+	defer release()
+	if err := k.throttle(context.Background(), SetEntrySize(key, value)); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.checkWritable(); err != nil {
+		return err
+	}
+	return k.setExpiring(key, value, k.opts.clock.Now().Add(ttl))
+}
+
+// setExpiring passes a write of key through the write interceptor, then
+// logs and applies it together with an expiry at at; the caller holds the
+// write lock.
+func (k *KV) setExpiring(key string, value []byte, at time.Time) error {
+	value, err := k.intercept(OpSet, key, value)
+	if err != nil {
+		return err
+	}
+	payloads := append(buildSetPayloads(key, value, k.opts.chunkSize), buildExpirePayload([]byte(key), at))
+	if err := k.writeEntries(payloads...); err != nil {
+		return err
+	}
+	val := append([]byte(nil), value...)
+	k.put(key, val)
+	k.setExpiry(key, at)
+	k.publish(OpSet, key, val)
+	return nil
+}
+
+// TTL returns how long key has left to live. ok is false if the key is
+// missing or expired; a zero duration with ok true means no TTL.
+func (k *KV) TTL(key string) (ttl time.Duration, ok bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.closed {
+		return 0, false
+	}
+	key, ok = k.resolveLive(key)
+	if !ok {
+		return 0, false
+	}
+	at, has := k.expiryOf(key)
+	if !has {
+		return 0, true
+	}
+	return at.Sub(k.opts.clock.Now()), true
+}
+
+// expiryOf returns when key expires if nothing changes: for a key set with
+// SetWithIdleTTL, one idle window after its last access. Callers must hold
+// k.mu.
+func (k *KV) expiryOf(key string) (time.Time, bool) {
+	if ik, ok := k.idle[key]; ok {
+		return ik.deadline(), true
+	}
+	at, ok := k.expiry[key]
+	return at, ok
+}
+
+func (k *KV) setExpiry(key string, at time.Time) {
+	if k.expiry == nil {
+		k.expiry = make(map[string]time.Time)
+	}
+	k.expiry[key] = at
+}
+
+// sweepExpired is run by the WithExpirySweep worker.
+func (k *KV) sweepExpired() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if !k.closed {
+		k.dropExpired()
+	}
+}
+
+// expired reports whether key has a TTL that has passed.
+// Callers must hold k.mu.
+func (k *KV) expired(key string) bool {
+	at, ok := k.expiryOf(key)
+	return ok && !k.opts.clock.Now().Before(at)
+}
+
+// live reports whether key is present and not expired.
+// Callers must hold k.mu.
+func (k *KV) live(key string) bool {
+	_, ok := k.data[key]
+	return ok && !k.expired(key)
+}
+
+// keyPayloads returns the entries that recreate key's current state in a
+// fresh log: its value plus, if it has a TTL, the expiry.
+// Callers must hold k.mu.
+func (k *KV) keyPayloads(key string, val []byte) [][]byte {
+	return k.keyPayloadsChunked(key, val, k.opts.chunkSize)
+}
+
+// keyPayloadsChunked is keyPayloads with an explicit chunk size.
+func (k *KV) keyPayloadsChunked(key string, val []byte, chunkSize int) [][]byte {
+	payloads := buildSetPayloads(key, val, chunkSize)
+	if at, ok := k.expiryOf(key); ok {
+		payloads = append(payloads, buildExpirePayload([]byte(key), at))
+	}
+	return payloads
+}
+
+// groupSize is how many bytes appendEntries writes for payloads.
+func groupSize(payloads [][]byte) int {
+	n := 0
+	for _, p := range payloads {
+		n += 8 + len(p)
+	}
+	if len(payloads) > 1 {
+		n += 8 + len(buildBatchBeginPayload()) + 8 + len(buildBatchCommitPayload(0))
+	}
+	return n
+}
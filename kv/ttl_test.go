@@ -0,0 +1,201 @@
+package kv
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExpiryWithFakeClock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	clock := newFakeClock()
+	k, err := Create(path, WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	if err := k.SetWithTTL("session", []byte("s"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	k.Set("user", []byte("u"))
+
+	clock.Advance(59 * time.Second)
+	if ttl, ok := k.TTL("session"); !ok || ttl != time.Second {
+		t.Errorf("TTL = %v, %v; want 1s, true", ttl, ok)
+	}
+	if _, ok := k.Get("session"); !ok {
+		t.Fatal("session expired early")
+	}
+
+	clock.Advance(time.Second)
+	if _, ok := k.Get("session"); ok {
+		t.Error("session still readable at its expiry")
+	}
+	if _, ok := k.TTL("session"); ok {
+		t.Error("TTL reports an expired key")
+	}
+	if got := k.Keys(); len(got) != 1 || got[0] != "user" {
+		t.Errorf("Keys = %q, want [user]", got)
+	}
+
+	// Compact drops it from the log, so it stays gone after reopening
+	// with a real clock
+	if err := k.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	k.Close()
+	k, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	if n := k.Stats().Keys; n != 1 {
+		t.Errorf("Stats().Keys after reopening = %d, want 1", n)
+	}
+}
+
+func TestCompactKeepsTTLs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	clock := newFakeClock()
+	k, err := Create(path, WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	k.Set("plain", []byte("p"))
+	k.SetWithTTL("short", []byte("s"), time.Minute)
+	k.SetWithTTL("long", []byte("l"), time.Hour)
+	k.SetWithTTL("cleared", []byte("c"), time.Minute)
+	k.Set("cleared", []byte("c2"))
+
+	clock.Advance(2 * time.Minute)
+	if err := k.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if n := k.Stats().Keys; n != 3 {
+		t.Errorf("Stats().Keys after Compact = %d, want 3", n)
+	}
+	k.Close()
+
+	// nothing expired is resurrected, even with the clock turned back
+	k, err = Open(path, WithClock(newFakeClock()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	if _, ok := k.Get("short"); ok {
+		t.Error("expired key came back after Compact")
+	}
+	if ttl, ok := k.TTL("long"); !ok || ttl != time.Hour {
+		t.Errorf("TTL(long) = %v, %v; want the original 1h from the same start", ttl, ok)
+	}
+	for _, key := range []string{"plain", "cleared"} {
+		if ttl, ok := k.TTL(key); !ok || ttl != 0 {
+			t.Errorf("TTL(%s) = %v, %v; want no TTL", key, ttl, ok)
+		}
+	}
+}
+
+func TestIdleTTL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	clock := newFakeClock()
+	k, err := Create(path, WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.SetWithIdleTTL("read", []byte("r"), 10*time.Second)
+	k.SetWithIdleTTL("unread", []byte("u"), 10*time.Second)
+
+	// each Get starts the window again
+	for i := 0; i < 5; i++ {
+		clock.Advance(6 * time.Second)
+		if _, ok := k.Get("read"); !ok {
+			t.Fatalf("read expired after %v despite being read", time.Duration(i+1)*6*time.Second)
+		}
+	}
+	if _, ok := k.Get("unread"); ok {
+		t.Error("unread outlived its idle window")
+	}
+	if ttl, ok := k.TTL("read"); !ok || ttl != 10*time.Second {
+		t.Errorf("TTL(read) = %v, %v; want a fresh 10s window", ttl, ok)
+	}
+
+	// Compact logs the slid expiry, which no longer slides after reopening
+	clock.Advance(4 * time.Second)
+	if err := k.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	k.Close()
+	k, err = Open(path, WithClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	if ttl, ok := k.TTL("read"); !ok || ttl != 6*time.Second {
+		t.Errorf("TTL(read) after reopening = %v, %v; want 6s", ttl, ok)
+	}
+	clock.Advance(5 * time.Second)
+	k.Get("read")
+	clock.Advance(time.Second)
+	if _, ok := k.Get("read"); ok {
+		t.Error("read after reopening still slides its expiry")
+	}
+
+	// a plain Set clears the idle expiry
+	k.SetWithIdleTTL("cleared", []byte("c"), time.Second)
+	k.Set("cleared", []byte("c"))
+	clock.Advance(time.Minute)
+	if _, ok := k.Get("cleared"); !ok {
+		t.Error("Set did not clear the idle expiry")
+	}
+
+	// Peek reads without starting the window again
+	k.SetWithIdleTTL("peeked", []byte("p"), 10*time.Second)
+	for i := 0; i < 2; i++ {
+		clock.Advance(4 * time.Second)
+		if v, ok := k.Peek("peeked"); !ok || string(v) != "p" {
+			t.Fatalf("Peek = %q, %v", v, ok)
+		}
+	}
+	clock.Advance(4 * time.Second)
+	if _, ok := k.Peek("peeked"); ok {
+		t.Error("Peek kept an idle key alive")
+	}
+}
+
+func TestExpirySweep(t *testing.T) {
+	clock := newFakeClock()
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"), WithClock(clock), WithExpirySweep(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	clock.waitTickers(t, 1)
+	k.Set("keep", []byte("k"))
+	k.SetWithTTL("ttl", []byte("t"), 30*time.Second)
+	k.SetWithIdleTTL("idle", []byte("i"), 90*time.Second)
+	memory := k.MemoryEstimate()
+
+	// expired keys are hidden at once but counted until a sweep
+	clock.Advance(45 * time.Second)
+	if _, ok := k.Get("ttl"); ok {
+		t.Error("ttl readable after its expiry")
+	}
+	if n := k.ApproxLen(); n != 3 {
+		t.Errorf("ApproxLen before the sweep = %d, want 3", n)
+	}
+	// sweeps at one and two minutes, the idle key expiring in between; the
+	// last Advance waits for the second sweep
+	clock.Advance(15 * time.Second)
+	clock.Advance(time.Minute)
+	clock.Advance(time.Minute)
+	if n, s := k.ApproxLen(), k.Stats().Keys; n != 1 || s != 1 {
+		t.Errorf("after sweeps ApproxLen = %d and Stats().Keys = %d, want 1", n, s)
+	}
+	if got := k.MemoryEstimate(); got >= memory {
+		t.Errorf("MemoryEstimate = %d after the sweeps, want below %d", got, memory)
+	}
+	if v, _ := k.Get("keep"); string(v) != "k" {
+		t.Errorf("Get(keep) = %q after the sweeps", v)
+	}
+}
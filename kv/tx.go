@@ -0,0 +1,124 @@
+package kv
+
+import "context"
+
+// Tx is a read-write transaction passed to the function given to Update.
+// Reads see the database as it was when Update began plus the
+// transaction's own writes; writes are buffered until the function returns.
+// A Tx must not be used after that function returns.
+type Tx struct {
+	k     *KV
+	batch Batch
+	// pending holds the latest buffered value per key; nil marks a delete
+	pending map[string][]byte
+}
+
+// Get returns a copy of key's value as seen by the transaction, following
+// aliases the transaction has not overwritten.
+func (tx *Tx) Get(key string) ([]byte, bool) {
+	for hops := 0; ; hops++ {
+		if val, ok := tx.pending[key]; ok {
+			if val == nil {
+				return nil, false
+			}
+			return append([]byte(nil), val...), true
+		}
+		target, ok := tx.k.aliases[key]
+		if !ok {
+			break
+		}
+		if hops >= tx.k.opts.maxAliasHops {
+			return nil, false
+		}
+		key = target
+	}
+	if !tx.k.live(key) {
+		return nil, false
+	}
+	return append([]byte(nil), tx.k.data[key]...), true
+}
+
+// Set buffers a set of key to value. The value is copied.
+func (tx *Tx) Set(key string, value []byte) {
+	tx.batch.Set(key, value)
+	tx.pending[key] = tx.batch.ops[len(tx.batch.ops)-1].value
+	if tx.pending[key] == nil {
+		tx.pending[key] = []byte{}
+	}
+}
+
+// Del buffers a delete of key.
+func (tx *Tx) Del(key string) {
+	tx.batch.Del(key)
+	tx.pending[key] = nil
+}
+
+// Update runs fn in a serializable read-write transaction. The write lock
+// is held throughout, so no other write can interleave with fn's reads and
+// writes, and fn must not call other KV methods. If fn returns nil its
+// writes are committed as one batch with a single fsync, all or nothing
+// across a crash; if it returns an error nothing is written and that error
+// is returned. Update counts against WithMaxInflightWrites like any write.
+// Its size is only known once fn has run, so under WithWriteRateLimit it
+// waits after committing, outside the lock, rather than before.
+func (k *KV) Update(fn func(tx *Tx) error) error {
+	release, err := k.admit()
+	if err != nil {
+		return err
+	}
+	defer release()
+	b, err := k.update(fn)
+	if err != nil {
+		return err
+	}
+	return k.throttle(context.Background(), b.size())
+}
+
+// update runs and commits fn under the write lock and returns the batch it
+// wrote.
+func (k *KV) update(fn func(tx *Tx) error) (*Batch, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.checkWritable(); err != nil {
+		return nil, err
+	}
+	tx := &Tx{k: k, pending: make(map[string][]byte)}
+	if err := fn(tx); err != nil {
+		return nil, err
+	}
+	return &tx.batch, k.writeBatch(&tx.batch)
+}
+
+// ReadTx is a read-only transaction passed to the function given to View.
+// It must not be used after that function returns.
+type ReadTx struct {
+	k *KV
+}
+
+// Get returns a copy of key's value, following aliases.
+func (tx *ReadTx) Get(key string) ([]byte, bool) {
+	key, ok := tx.k.resolveLive(key)
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), tx.k.data[key]...), true
+}
+
+// Scan returns the keys in [start, end) in sorted order. An empty start or
+// end means no bound.
+func (tx *ReadTx) Scan(start, end string) []string {
+	return tx.k.rangeKeys(start, end)
+}
+
+// View runs fn with a consistent read-only view: every Get and Scan inside
+// fn sees the same state. It holds the read lock for the duration, so
+// views run alongside each other and other reads, while writes wait until
+// fn returns; keep fn short, and do not call other KV methods from it.
+func (k *KV) View(fn func(tx *ReadTx) error) error {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.closed {
+		return ErrClosed
+	}
+	return fn(&ReadTx{k: k})
+}
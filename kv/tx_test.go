@@ -0,0 +1,173 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUpdateCommits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	k, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	k.Set("from", []byte("10"))
+	k.Set("gone", []byte("x"))
+
+	err = k.Update(func(tx *Tx) error {
+		v, _ := tx.Get("from")
+		tx.Set("to", v)
+		tx.Del("gone")
+		tx.Set("from", []byte("0"))
+		// reads see the transaction's own writes
+		if v, _ := tx.Get("from"); string(v) != "0" {
+			t.Errorf("tx.Get(from) = %q after tx.Set", v)
+		}
+		if _, ok := tx.Get("gone"); ok {
+			t.Error("tx.Get found a key the transaction deleted")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lsn := k.LastLSN()
+	k.Close()
+	k, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	if got := fmt.Sprintf("%q", k.GetAll()); got != `map["from":"0" "to":"10"]` {
+		t.Errorf("after Update: %s", got)
+	}
+	if k.LastLSN() != lsn || lsn != 3 {
+		t.Errorf("LastLSN = %d, want the transaction to be one write (3)", k.LastLSN())
+	}
+}
+
+func TestUpdateRollsBack(t *testing.T) {
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	k.Set("a", []byte("1"))
+	lsn := k.LastLSN()
+
+	abort := errors.New("abort")
+	err = k.Update(func(tx *Tx) error {
+		tx.Set("a", []byte("2"))
+		tx.Set("b", []byte("2"))
+		tx.Del("a")
+		return abort
+	})
+	if err != abort {
+		t.Errorf("Update = %v, want fn's error", err)
+	}
+	if v, _ := k.Get("a"); string(v) != "1" {
+		t.Errorf("a = %q after a failed Update", v)
+	}
+	if _, ok := k.Get("b"); ok {
+		t.Error("b written by a failed Update")
+	}
+	if k.LastLSN() != lsn {
+		t.Error("a failed Update was logged")
+	}
+}
+
+func TestUpdateIsolation(t *testing.T) {
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"), WithNoSync())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	k.Set("x", []byte("before"))
+
+	// a plain write started inside the transaction waits for it
+	done := make(chan struct{})
+	k.Update(func(tx *Tx) error {
+		go func() {
+			defer close(done)
+			k.Set("x", []byte("other"))
+		}()
+		time.Sleep(20 * time.Millisecond)
+		if v, _ := tx.Get("x"); string(v) != "before" {
+			t.Errorf("tx saw a concurrent write: %q", v)
+		}
+		tx.Set("x", []byte("tx"))
+		return nil
+	})
+	<-done
+	if v, _ := k.Get("x"); string(v) != "other" {
+		t.Errorf("x = %q, want the concurrent write applied after the transaction", v)
+	}
+
+	// read-modify-write increments never lose an update
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			k.Update(func(tx *Tx) error {
+				v, _ := tx.Get("n")
+				n, _ := strconv.Atoi(string(v))
+				tx.Set("n", []byte(strconv.Itoa(n+1)))
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+	if v, _ := k.Get("n"); string(v) != "20" {
+		t.Errorf("n = %q after 20 concurrent increments", v)
+	}
+}
+
+func TestViewSnapshot(t *testing.T) {
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	k.Set("a", []byte("1"))
+	k.Set("b", []byte("2"))
+
+	wrote := make(chan struct{})
+	err = k.View(func(tx *ReadTx) error {
+		go func() {
+			defer close(wrote)
+			k.Set("a", []byte("changed"))
+			k.Set("c", []byte("3"))
+			k.Del("b")
+		}()
+		for i := 0; i < 5; i++ {
+			if v, _ := tx.Get("a"); string(v) != "1" {
+				t.Errorf("View saw a = %q", v)
+			}
+			if _, ok := tx.Get("b"); !ok {
+				t.Error("View lost b")
+			}
+			if got := fmt.Sprint(tx.Scan("", "")); got != "[a b]" {
+				t.Errorf("View scanned %s", got)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-wrote
+	k.View(func(tx *ReadTx) error {
+		if got := fmt.Sprint(tx.Scan("", "")); got != "[a c]" {
+			t.Errorf("a later View scanned %s", got)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,48 @@
+package kv
+
+// deletedValue is a value removed by Del, kept for Undelete.
+type deletedValue struct {
+	key   string
+	value []byte
+}
+
+// keepDeleted records the value key held before a delete, dropping the
+// oldest record once WithDeleteUndo's limit is reached. Callers must hold
+// k.mu for writing.
+func (k *KV) keepDeleted(key string, value []byte) {
+	n := k.opts.deleteUndo
+	if n <= 0 {
+		return
+	}
+	if len(k.undo) >= n {
+		k.undo = append(k.undo[:0], k.undo[len(k.undo)-n+1:]...)
+	}
+	k.undo = append(k.undo, deletedValue{key: key, value: value})
+}
+
+// Undelete sets key back to the value it held before its most recent delete
+// still kept by WithDeleteUndo, and forgets that record. It reports false if
+// no value is kept for key, or if key has been written again since and so
+// holds a value that must not be overwritten.
+func (k *KV) Undelete(key string) (bool, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err := k.checkWritable(); err != nil {
+		return false, err
+	}
+	if k.live(key) {
+		return false, nil
+	}
+	for i := len(k.undo) - 1; i >= 0; i-- {
+		if k.undo[i].key != key {
+			continue
+		}
+		// the value already went through the write interceptor once
+		if err := k.writeSet(key, k.undo[i].value); err != nil {
+			return false, err
+		}
+		k.undo = append(k.undo[:i], k.undo[i+1:]...)
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,66 @@
+package kv
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestUndeleteAfterEveryDeletePath(t *testing.T) {
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"), WithDeleteUndo(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	for _, key := range []string{"del", "batch", "tx", "func"} {
+		k.Set(key, []byte(key+"-value"))
+	}
+
+	k.Del("del")
+	var b Batch
+	b.Del("batch")
+	if err := k.WriteBatch(&b); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Update(func(tx *Tx) error {
+		tx.Del("tx")
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k.DeleteFunc(func(key string, _ []byte) bool { return key == "func" }); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"del", "batch", "tx", "func"} {
+		ok, err := k.Undelete(key)
+		if err != nil || !ok {
+			t.Errorf("Undelete(%q) = %v, %v", key, ok, err)
+			continue
+		}
+		if v, _ := k.Get(key); string(v) != key+"-value" {
+			t.Errorf("Get(%q) = %q after Undelete", key, v)
+		}
+	}
+}
+
+func TestUndeleteLimitAndRewrite(t *testing.T) {
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"), WithDeleteUndo(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	for _, key := range []string{"a", "b", "c"} {
+		k.Set(key, []byte("1"))
+		k.Del(key)
+	}
+	if ok, _ := k.Undelete("a"); ok {
+		t.Error("Undelete(a) succeeded past the buffer limit")
+	}
+	k.Set("b", []byte("2"))
+	if ok, _ := k.Undelete("b"); ok {
+		t.Error("Undelete(b) overwrote a newer value")
+	}
+	if ok, _ := k.Undelete("c"); !ok {
+		t.Error("Undelete(c) failed")
+	}
+}
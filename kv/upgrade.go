@@ -0,0 +1,109 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// LatestLogVersion is the log format version NewKV writes for new files.
+// Version 1 logs, which have no header, can still be opened.
+const LatestLogVersion = logVersion2
+
+// LogVersion returns the format version of the log at logPath without
+// opening it.
+func LogVersion(logPath string) (uint16, error) {
+	f, err := os.Open(logPath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	lf, ok, err := readHeader(f)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, fmt.Errorf("kv: %s has no complete header", logPath)
+	}
+	return lf.version, nil
+}
+
+// UpgradeLog rewrites the log at logPath in format version targetVersion,
+// compacting it on the way, and keeps the original next to it as
+// logPath.v<old version>.bak, replacing any earlier backup. The backup is
+// made durable before the upgraded log is renamed over logPath in one step,
+// so a crash at any point leaves either the old or the new log in place. A
+// log already at targetVersion is left alone. Nothing may have the log open
+// while it runs. Only LatestLogVersion can be written.
+func UpgradeLog(logPath string, targetVersion uint16) error {
+	if targetVersion != LatestLogVersion {
+		return fmt.Errorf("kv: cannot write log version %d", targetVersion)
+	}
+	k, err := Open(logPath)
+	if err != nil {
+		return err
+	}
+	from := k.format.version
+	if from == targetVersion {
+		return k.Close()
+	}
+	tmpName := logPath + ".upgrade"
+	if err := k.CompactTo(tmpName, WithChecksum(k.format.checksum)); err != nil {
+		k.Close()
+		return err
+	}
+	// the checkpoint describes the old log
+	if err := removeCheckpoint(k.store, logPath); err != nil {
+		k.Close()
+		return err
+	}
+	if err := k.Close(); err != nil {
+		return err
+	}
+	dir := filepath.Dir(logPath)
+	backup := fmt.Sprintf("%s.v%d.bak", logPath, from)
+	if err := os.Remove(backup); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	// a hard link keeps the old log at logPath until the rename replaces it
+	if err := os.Link(logPath, backup); err != nil {
+		if err := copyFile(logPath, backup); err != nil {
+			return err
+		}
+	}
+	if err := syncDir(dir); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, logPath); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// copyFile durably copies src to dst, which must not exist, for file
+// systems without hard links.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
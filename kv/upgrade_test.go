@@ -0,0 +1,95 @@
+package kv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUpgradeLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	v1 := logFormat{version: logVersion1, checksum: ChecksumIEEE}
+	var old []byte
+	old = appendLogEntry(old, v1, buildSetPayload([]byte("a"), []byte("1")))
+	old = appendLogEntry(old, v1, buildSetPayload([]byte("b"), []byte("2")))
+	old = appendLogEntry(old, v1, buildSetPayload([]byte("a"), []byte("3")))
+	old = appendLogEntry(old, v1, buildDelPayload([]byte("b")))
+	if err := os.WriteFile(path, old, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := LogVersion(path); err != nil || v != logVersion1 {
+		t.Fatalf("LogVersion = %d, %v; want 1", v, err)
+	}
+
+	// a stale backup is replaced, and the log is never missing from path:
+	// a crash at any directory sync would leave a usable log
+	if err := os.WriteFile(path+".v1.bak", []byte("stale"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(orig func(string) error) { syncDir = orig }(syncDir)
+	syncs := 0
+	syncDir = func(dir string) error {
+		syncs++
+		v, err := LogVersion(path)
+		if err != nil {
+			t.Errorf("no log at directory sync %d: %v", syncs, err)
+		}
+		backup, _ := os.ReadFile(path + ".v1.bak")
+		if v == LatestLogVersion && !bytes.Equal(backup, old) {
+			t.Errorf("log replaced before its backup was made, at directory sync %d", syncs)
+		}
+		return nil
+	}
+	if err := UpgradeLog(path, LatestLogVersion); err != nil {
+		t.Fatal(err)
+	}
+	if syncs < 2 {
+		t.Errorf("UpgradeLog synced the directory %d times, want after the backup and the rename", syncs)
+	}
+	if v, err := LogVersion(path); err != nil || v != LatestLogVersion {
+		t.Errorf("LogVersion after upgrading = %d, %v", v, err)
+	}
+	if backup, err := os.ReadFile(path + ".v1.bak"); err != nil || !bytes.Equal(backup, old) {
+		t.Errorf("backup of the v1 log missing or changed: %v", err)
+	}
+	k, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := k.Get("a"); string(v) != "3" {
+		t.Errorf("a = %q after upgrading, want 3", v)
+	}
+	if info := k.OpenInfo(); info.EntriesReplayed != 1 {
+		t.Errorf("upgraded log replays %d entries, want 1 after compacting", info.EntriesReplayed)
+	}
+	k.Close()
+
+	// upgrading again does nothing
+	before, _ := os.ReadFile(path)
+	if err := UpgradeLog(path, LatestLogVersion); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := os.ReadFile(path); !bytes.Equal(before, after) {
+		t.Error("upgrading a current log rewrote it")
+	}
+	if err := UpgradeLog(path, LatestLogVersion+1); err == nil {
+		t.Error("UpgradeLog to an unknown version succeeded")
+	}
+}
+
+func TestOpenRefusesNewerVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.log")
+	hdr := encodeHeader(logFormat{version: logVersion2, checksum: ChecksumIEEE})
+	binary.BigEndian.PutUint16(hdr[4:6], LatestLogVersion+1)
+	if err := os.WriteFile(path, hdr, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := Open(path)
+	if !errors.Is(err, ErrUnsupportedVersion) || !strings.Contains(err.Error(), "UpgradeLog") {
+		t.Errorf("Open of a newer log = %v, want ErrUnsupportedVersion pointing at UpgradeLog", err)
+	}
+}
@@ -0,0 +1,87 @@
+package kv
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestVerifyOnlineClean(t *testing.T) {
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	k.Set("a", []byte("1"))
+	k.Set("b", []byte("2"))
+	k.Del("b")
+	k.SetWithTTL("live", []byte("3"), time.Hour)
+	k.SetWithTTL("expired", []byte("4"), time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	rep, err := k.VerifyOnline()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rep.OK() {
+		t.Errorf("report for a healthy database = %+v", rep)
+	}
+	if rep.KeysChecked != 2 {
+		t.Errorf("KeysChecked = %d, want 2", rep.KeysChecked)
+	}
+}
+
+func TestVerifyOnlineFindsDivergence(t *testing.T) {
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	k.Set("a", []byte("1"))
+	k.Set("b", []byte("2"))
+	k.Set("c", []byte("3"))
+	// damage memory behind the log's back
+	k.mu.Lock()
+	k.data["a"] = []byte("x")
+	k.remove("b")
+	k.put("d", []byte("4"))
+	k.mu.Unlock()
+
+	rep, err := k.VerifyOnline()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.OK() {
+		t.Fatal("report is OK after memory was damaged")
+	}
+	if len(rep.Mismatched) != 1 || rep.Mismatched[0] != "a" {
+		t.Errorf("Mismatched = %v, want [a]", rep.Mismatched)
+	}
+	if len(rep.Extra) != 1 || rep.Extra[0] != "b" {
+		t.Errorf("Extra = %v, want [b]", rep.Extra)
+	}
+	if len(rep.Missing) != 1 || rep.Missing[0] != "d" {
+		t.Errorf("Missing = %v, want [d]", rep.Missing)
+	}
+}
+
+func TestVerifyOnlineCorruption(t *testing.T) {
+	s := NewMemoryStorage()
+	k, err := Create("a.log", WithStorage(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	k.Set("a", []byte("1"))
+	k.Set("b", []byte("2"))
+	size, _ := s.Size("a.log")
+	flipByte(t, s, "a.log", size-1)
+
+	rep, err := k.VerifyOnline()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(rep.Corruption, ErrChecksumMismatch) {
+		t.Errorf("Corruption = %v, want a checksum mismatch", rep.Corruption)
+	}
+}
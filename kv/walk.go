@@ -0,0 +1,142 @@
+package kv
+
+import (
+	"crypto/sha256"
+	"fmt"
+)
+
+// WalkLog calls fn for every set, delete, expire and alias entry in the log
+// in the order they were written, including values that have since been
+// overwritten or deleted. offset is the position of the entry's frame in the
+// file and value is nil for deletes and expiries, and the target for
+// aliases. A key set by reference to a shared value (see WithDedupValues)
+// is reported as a set, and a custom entry (see WriteCustom) with an empty
+// key and its payload as value. Uncommitted batches and a torn tail are
+// skipped, as on replay. Walking stops at the first error returned by fn.
+//
+// The read lock is held for the whole walk, so fn must not write to k.
+func (k *KV) WalkLog(fn func(offset int64, typ EntryType, key string, value []byte) error) error {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if k.closed {
+		return ErrClosed
+	}
+	start := k.format.headerLen()
+	r, err := sectionFrom(k.store, k.logPath, start)
+	if err != nil {
+		return err
+	}
+	entries, _, _, err := readLog(r, start, k.format, true)
+	if err != nil {
+		return err
+	}
+	// values of a deduplicated log's blobs, reported with each ref to them
+	blobs := make(map[[sha256.Size]byte][]byte)
+	// a chunked value is reported once, as a set at its first chunk's offset
+	var chunkKey string
+	var chunkVal []byte
+	chunkOff := int64(-1)
+	flush := func() error {
+		if chunkOff < 0 {
+			return nil
+		}
+		off := chunkOff
+		chunkOff = -1
+		return fn(off, OpSet, chunkKey, chunkVal)
+	}
+	for _, e := range entries {
+		if len(e.payload) == 0 {
+			continue
+		}
+		typ := EntryType(e.payload[0])
+		if typ == OpChunk {
+			key, part, first, err := decodeChunk(e.payload)
+			if err != nil {
+				return err
+			}
+			if first {
+				if err := flush(); err != nil {
+					return err
+				}
+				chunkKey, chunkVal, chunkOff = key, nil, e.offset
+			}
+			chunkVal = append(chunkVal, part...)
+			continue
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+		switch typ {
+		case OpSet:
+			key, val, err := decodeSet(e.payload)
+			if err != nil {
+				return err
+			}
+			if err := fn(e.offset, typ, key, val); err != nil {
+				return err
+			}
+		case OpDel:
+			key, err := decodeDel(e.payload)
+			if err != nil {
+				return err
+			}
+			if err := fn(e.offset, typ, key, nil); err != nil {
+				return err
+			}
+		case OpBlob:
+			sum, val, err := decodeBlob(e.payload)
+			if err != nil {
+				return err
+			}
+			blobs[sum] = val
+		case OpRef:
+			key, sum, err := decodeRef(e.payload)
+			if err != nil {
+				return err
+			}
+			if err := fn(e.offset, OpSet, key, blobs[sum]); err != nil {
+				return err
+			}
+		case OpAlias:
+			alias, target, err := decodeAlias(e.payload)
+			if err != nil {
+				return err
+			}
+			if err := fn(e.offset, typ, alias, []byte(target)); err != nil {
+				return err
+			}
+		case OpExpire:
+			key, _, err := decodeExpire(e.payload)
+			if err != nil {
+				return err
+			}
+			if err := fn(e.offset, typ, key, nil); err != nil {
+				return err
+			}
+		default:
+			if typ >= OpCustom {
+				if err := fn(e.offset, typ, "", e.payload[1:]); err != nil {
+					return err
+				}
+				continue
+			}
+			return &CorruptionError{Offset: e.offset, Err: fmt.Errorf("%w %d", ErrUnknownEntryType, typ)}
+		}
+	}
+	return flush()
+}
+
+// WriteCount returns how many set and delete entries for key the current
+// log holds. A key with a high count relative to its one live value is
+// churning and is what Compact reclaims space from. It walks the whole log,
+// so it is meant for debugging rather than hot paths.
+func (k *KV) WriteCount(key string) (int, error) {
+	n := 0
+	err := k.WalkLog(func(_ int64, typ EntryType, k string, _ []byte) error {
+		if k == key && (typ == OpSet || typ == OpDel) {
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
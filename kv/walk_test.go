@@ -0,0 +1,112 @@
+package kv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestWalkLogSeesEveryWrite(t *testing.T) {
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"), WithChunkSize(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	k.Set("a", []byte("1"))
+	k.Set("b", []byte("2"))
+	k.Set("a", []byte("3"))
+	k.Del("b")
+	k.Set("big", []byte("0123456789"))
+	k.SetAlias("al", "a")
+
+	var got []string
+	last := int64(-1)
+	err = k.WalkLog(func(offset int64, typ EntryType, key string, value []byte) error {
+		if offset <= last {
+			t.Errorf("offset %d after %d", offset, last)
+		}
+		last = offset
+		got = append(got, fmt.Sprintf("%d %s=%s", typ, key, value))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		fmt.Sprintf("%d a=1", OpSet),
+		fmt.Sprintf("%d b=2", OpSet),
+		fmt.Sprintf("%d a=3", OpSet),
+		fmt.Sprintf("%d b=", OpDel),
+		fmt.Sprintf("%d big=0123456789", OpSet),
+		fmt.Sprintf("%d al=a", OpAlias),
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("walk = %q\nwant %q", got, want)
+	}
+
+	stop := errors.New("stop")
+	n := 0
+	err = k.WalkLog(func(int64, EntryType, string, []byte) error {
+		n++
+		return stop
+	})
+	if !errors.Is(err, stop) || n != 1 {
+		t.Errorf("WalkLog = %v after %d calls, want fn's error after 1", err, n)
+	}
+}
+
+func TestWriteCount(t *testing.T) {
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"), WithChunkSize(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	for i := 0; i < 5; i++ {
+		k.Set("churn", []byte(fmt.Sprint("value ", i)))
+	}
+	k.Del("churn")
+	var b Batch
+	b.Set("churn", []byte("x"))
+	b.Set("other", []byte("y"))
+	k.WriteBatch(&b)
+	k.Set("other", []byte("z"))
+
+	for key, want := range map[string]int{"churn": 7, "other": 2, "missing": 0} {
+		if n, err := k.WriteCount(key); err != nil || n != want {
+			t.Errorf("WriteCount(%s) = %d, %v; want %d", key, n, err, want)
+		}
+	}
+	if err := k.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := k.WriteCount("churn"); n != 1 {
+		t.Errorf("WriteCount after Compact = %d, want 1", n)
+	}
+}
+
+func TestWalkLogResolvesDedupRefs(t *testing.T) {
+	k, err := Create(filepath.Join(t.TempDir(), "a.log"), WithDedupValues())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer k.Close()
+	shared := bytes.Repeat([]byte("s"), 100)
+	k.Set("a", shared)
+	k.Set("b", shared)
+	if err := k.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	sets := map[string][]byte{}
+	k.WalkLog(func(_ int64, typ EntryType, key string, value []byte) error {
+		if typ != OpSet {
+			t.Errorf("walk reported type %d for %q", typ, key)
+		}
+		sets[key] = value
+		return nil
+	})
+	if len(sets) != 2 || !bytes.Equal(sets["a"], shared) || !bytes.Equal(sets["b"], shared) {
+		t.Errorf("walk of a deduplicated log = %q", sets)
+	}
+}
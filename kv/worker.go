@@ -0,0 +1,73 @@
+package kv
+
+import (
+	"sync"
+	"time"
+)
+
+// worker is a background goroutine started at open that Close stops.
+type worker struct {
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func (w *worker) halt() {
+	w.stopOnce.Do(func() { close(w.stop) })
+	<-w.done
+}
+
+// every starts a worker calling fn each interval, ticked by the Clock if it
+// implements Ticker. It must only be called while opening, before k is
+// shared, or by Promote with k.mu held for writing.
+func (k *KV) every(interval time.Duration, fn func()) {
+	w := &worker{stop: make(chan struct{}), done: make(chan struct{})}
+	k.workers = append(k.workers, w)
+	go func() {
+		defer close(w.done)
+		var tick <-chan time.Time
+		var stop func()
+		if t, ok := k.opts.clock.(Ticker); ok {
+			tick, stop = t.Tick(interval)
+		} else {
+			tick, stop = realClock{}.Tick(interval)
+		}
+		defer stop()
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-tick:
+				fn()
+			}
+		}
+	}()
+}
+
+// startWorkers starts the background work configured by options.
+func (k *KV) startWorkers() {
+	k.startMemoryWorkers()
+	k.startCompactionWorkers()
+}
+
+// startMemoryWorkers starts the background work that does not write the
+// log, which runs on a standby too.
+func (k *KV) startMemoryWorkers() {
+	if k.opts.memReportEvery > 0 && k.opts.memReport != nil {
+		k.every(k.opts.memReportEvery, k.reportMemory)
+	}
+	if k.opts.sweepEvery > 0 {
+		k.every(k.opts.sweepEvery, k.sweepExpired)
+	}
+}
+
+// startCompactionWorkers starts the background compactions, which a
+// standby only runs once promoted.
+func (k *KV) startCompactionWorkers() {
+	if k.opts.compactEvery > 0 {
+		k.every(k.opts.compactEvery, k.scheduledCompact)
+	}
+	if k.opts.tombstoneCount > 0 || k.opts.tombstoneRatio > 0 {
+		k.startTombstoneWorker()
+	}
+}
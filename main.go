@@ -13,9 +13,9 @@ import (
 func help() {
 	fmt.Println("commands:")
 	fmt.Println("  set <key> <value>")
-	fmt.Println("  get <key>")
+	fmt.Println("  get [--raw] <key>")
 	fmt.Println("  del <key>")
-	fmt.Println("  compact")
+	fmt.Println("  compact [--dry-run]")
 	fmt.Println("  exit")
 }
 
@@ -58,8 +58,20 @@ func main() {
 				}
 			}
 		case "get":
+			if len(parts) == 3 && parts[1] == "--raw" {
+				// length-prefixed so values containing newlines can be
+				// read back exactly; -1 means the key is absent
+				if val, ok := db.Get(parts[2]); ok {
+					fmt.Printf("%d\n", len(val))
+					os.Stdout.Write(val)
+					fmt.Println()
+				} else {
+					fmt.Println(-1)
+				}
+				break
+			}
 			if len(parts) != 2 {
-				fmt.Println("usage: get <key>")
+				fmt.Println("usage: get [--raw] <key>")
 			} else {
 				key := parts[1]
 				if val, ok := db.Get(key); ok {
@@ -80,6 +92,15 @@ func main() {
 				}
 			}
 		case "compact":
+			if len(parts) == 2 && parts[1] == "--dry-run" {
+				live, total, err := db.CompactEstimate()
+				if err != nil {
+					fmt.Printf("compact error: %v\n", err)
+				} else {
+					fmt.Printf("live %d bytes of %d, would reclaim %d bytes\n", live, total, total-live)
+				}
+				break
+			}
 			fmt.Println("Compacting log...")
 			if err := db.Compact(); err != nil {
 				fmt.Printf("compact error: %v\n", err)
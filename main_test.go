@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"godb/kv"
+)
+
+// runCLI runs the CLI in dir with input on stdin and returns its stdout.
+func runCLI(t *testing.T, dir, input string) string {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	in, err := os.CreateTemp(t.TempDir(), "stdin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	in.WriteString(input)
+	in.Seek(0, io.SeekStart)
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin, stdout := os.Stdin, os.Stdout
+	os.Stdin, os.Stdout = in, w
+	defer func() { os.Stdin, os.Stdout = stdin, stdout }()
+
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+	main()
+	w.Close()
+	return <-out
+}
+
+func TestGetRaw(t *testing.T) {
+	dir := t.TempDir()
+	value := "line one\nline two\n\nend"
+	db, err := kv.Create(filepath.Join(dir, "db.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Set("multi", []byte(value))
+	db.Close()
+
+	out := runCLI(t, dir, "get --raw multi\nget --raw missing\nexit\n")
+	// skip the banner and help up to the first prompt
+	_, out, _ = strings.Cut(out, "  exit\n> ")
+	br := bufio.NewReader(strings.NewReader(out))
+	line, err := br.ReadString('\n')
+	if err != nil {
+		t.Fatalf("reading the length: %v in %q", err, out)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil {
+		t.Fatalf("length line %q: %v", line, err)
+	}
+	got := make([]byte, n)
+	if _, err := io.ReadFull(br, got); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != value {
+		t.Errorf("raw value = %q, want %q", got, value)
+	}
+	rest, _ := io.ReadAll(br)
+	if want := "\n> -1\n> bye\n"; string(rest) != want {
+		t.Errorf("after the value: %q, want %q", rest, want)
+	}
+}
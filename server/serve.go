@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"godb/kv"
+)
+
+// DefaultShutdownTimeout bounds how long Serve waits for in-flight requests
+// after its context is cancelled.
+const DefaultShutdownTimeout = 10 * time.Second
+
+// Server serves the HTTP API on an address and owns the KV's lifetime: when
+// it stops, the KV is closed.
+type Server struct {
+	// ShutdownTimeout overrides DefaultShutdownTimeout when non-zero.
+	ShutdownTimeout time.Duration
+
+	addr      string
+	db        *kv.KV
+	http      *http.Server
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewServer returns a Server for db listening on addr.
+func NewServer(addr string, db *kv.KV, opts ...Option) *Server {
+	return &Server{
+		addr: addr,
+		db:   db,
+		http: &http.Server{Handler: NewHandler(db, opts...)},
+	}
+}
+
+// Serve listens on the server's address and handles requests until ctx is
+// cancelled. It then stops accepting connections, waits for in-flight
+// requests, and closes the KV exactly once.
+func (s *Server) Serve(ctx context.Context) error {
+	ln, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return err
+	}
+	return s.ServeListener(ctx, ln)
+}
+
+// ServeListener is like Serve but accepts connections on ln.
+func (s *Server) ServeListener(ctx context.Context, ln net.Listener) error {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.http.Serve(ln)
+	}()
+
+	select {
+	case err := <-errc:
+		// the listener failed before we were asked to stop
+		if cerr := s.closeDB(); err == nil {
+			err = cerr
+		}
+		return err
+	case <-ctx.Done():
+	}
+
+	timeout := s.ShutdownTimeout
+	if timeout == 0 {
+		timeout = DefaultShutdownTimeout
+	}
+	sctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := s.http.Shutdown(sctx)
+	if serr := <-errc; !errors.Is(serr, http.ErrServerClosed) && err == nil {
+		err = serr
+	}
+	if cerr := s.closeDB(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (s *Server) closeDB() error {
+	s.closeOnce.Do(func() {
+		s.closeErr = s.db.Close()
+	})
+	return s.closeErr
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"godb/kv"
+)
+
+func TestServeShutsDownGracefully(t *testing.T) {
+	db := newTestDB(t)
+	s := NewServer("", db)
+	// a handler that holds its request until released
+	started, release := make(chan struct{}), make(chan struct{})
+	api := s.http.Handler
+	s.http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-release
+		}
+		api.ServeHTTP(w, r)
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- s.ServeListener(ctx, ln) }()
+
+	base := "http://" + ln.Addr().String()
+	// without keep-alives no idle or speculatively dialed connection is
+	// left for Shutdown to wait on
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	resp, err := client.Get(base + "/admin/stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /admin/stats: %d", resp.StatusCode)
+	}
+
+	slow := make(chan int, 1)
+	go func() {
+		resp, err := client.Get(base + "/slow")
+		if err != nil {
+			slow <- 0
+			return
+		}
+		resp.Body.Close()
+		slow <- resp.StatusCode
+	}()
+	<-started
+	cancel()
+	select {
+	case err := <-served:
+		t.Fatalf("Serve returned %v with a request in flight", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := db.Set("a", []byte("1")); err != nil {
+		t.Errorf("KV closed before in-flight requests finished: %v", err)
+	}
+	close(release)
+	if code := <-slow; code != http.StatusNotFound {
+		t.Errorf("in-flight request got %d, want the handler's 404", code)
+	}
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Serve = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return after shutdown")
+	}
+	if err := db.Set("a", []byte("2")); !errors.Is(err, kv.ErrClosed) {
+		t.Errorf("Set after Serve returned: %v, want ErrClosed", err)
+	}
+	if _, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+		t.Error("server still accepts connections")
+	}
+}
+
+func TestServeListenerFailureClosesDB(t *testing.T) {
+	db := newTestDB(t)
+	s := NewServer("", db)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	if err := s.ServeListener(context.Background(), ln); err == nil {
+		t.Error("ServeListener on a closed listener succeeded")
+	}
+	if err := db.Set("a", nil); !errors.Is(err, kv.ErrClosed) {
+		t.Errorf("Set = %v, want ErrClosed", err)
+	}
+}
@@ -0,0 +1,236 @@
+// Package server exposes a KV over HTTP.
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"godb/kv"
+)
+
+// DefaultMaxBodyBytes caps request bodies unless WithMaxBodyBytes is used.
+const DefaultMaxBodyBytes = 8 << 20
+
+// Option configures a Handler.
+type Option func(*Handler)
+
+// WithMaxBodyBytes sets the largest request body the handler will read.
+func WithMaxBodyBytes(n int64) Option {
+	return func(h *Handler) {
+		h.maxBody = n
+	}
+}
+
+// WithAdminToken requires "Authorization: Bearer <token>" on the /admin
+// endpoints. Without it they are open to anyone who can reach the handler.
+func WithAdminToken(token string) Option {
+	return func(h *Handler) {
+		h.adminToken = token
+	}
+}
+
+// Handler serves the HTTP API for a KV.
+type Handler struct {
+	db         *kv.KV
+	maxBody    int64
+	adminToken string
+	mux        *http.ServeMux
+}
+
+// NewHandler returns a Handler serving db.
+func NewHandler(db *kv.KV, opts ...Option) *Handler {
+	h := &Handler{
+		db:      db,
+		maxBody: DefaultMaxBodyBytes,
+		mux:     http.NewServeMux(),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	h.mux.HandleFunc("POST /batch", h.handleBatch)
+	h.mux.HandleFunc("POST /admin/compact", h.admin(h.handleCompact))
+	h.mux.HandleFunc("GET /admin/stats", h.admin(h.handleStats))
+	h.mux.HandleFunc("POST /admin/verify", h.admin(h.handleVerify))
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// batchOp is one element of a POST /batch body. Value is base64 in JSON.
+type batchOp struct {
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+	Value []byte `json:"value,omitempty"`
+}
+
+type opStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// handleBatch applies a JSON array of set/del operations as one WriteBatch
+// and answers with one status per operation. If any operation is invalid
+// nothing is written.
+func (h *Handler) handleBatch(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxBody)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	var ops []batchOp
+	if err := dec.Decode(&ops); err != nil {
+		var tooBig *http.MaxBytesError
+		if errors.As(err, &tooBig) {
+			writeError(w, http.StatusRequestEntityTooLarge, err)
+			return
+		}
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if dec.More() {
+		writeError(w, http.StatusBadRequest, errors.New("unexpected data after batch"))
+		return
+	}
+
+	statuses := make([]opStatus, len(ops))
+	var b kv.Batch
+	invalid := false
+	for i, op := range ops {
+		switch {
+		case op.Key == "":
+			statuses[i] = opStatus{Status: "invalid", Error: "missing key"}
+			invalid = true
+		case op.Op == "set":
+			b.Set(op.Key, op.Value)
+		case op.Op == "del":
+			b.Del(op.Key)
+		default:
+			statuses[i] = opStatus{Status: "invalid", Error: fmt.Sprintf("unknown op %q", op.Op)}
+			invalid = true
+		}
+	}
+	if invalid {
+		for i := range statuses {
+			if statuses[i].Status == "" {
+				statuses[i].Status = "skipped"
+			}
+		}
+		writeJSON(w, http.StatusBadRequest, statuses)
+		return
+	}
+
+	if err := h.db.WriteBatch(&b); err != nil {
+		for i := range statuses {
+			statuses[i] = opStatus{Status: "error", Error: err.Error()}
+		}
+		writeJSON(w, http.StatusInternalServerError, statuses)
+		return
+	}
+	for i := range statuses {
+		statuses[i].Status = "ok"
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+// admin wraps an /admin handler with the WithAdminToken check.
+func (h *Handler) admin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.adminToken != "" {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(h.adminToken)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// compactResult is the body of a POST /admin/compact response.
+type compactResult struct {
+	BytesBefore  int64   `json:"bytes_before"`
+	BytesAfter   int64   `json:"bytes_after"`
+	DurationSecs float64 `json:"duration_seconds"`
+	KeysRetained int     `json:"keys_retained"`
+}
+
+// handleCompact runs Compact and reports the log size on either side of it.
+func (h *Handler) handleCompact(w http.ResponseWriter, r *http.Request) {
+	_, before, err := h.db.CompactEstimate()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	start := time.Now()
+	if err := h.db.Compact(); err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, kv.ErrCompactionInProgress) {
+			code = http.StatusConflict
+		}
+		writeError(w, code, err)
+		return
+	}
+	elapsed := time.Since(start)
+	_, after, err := h.db.CompactEstimate()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, compactResult{
+		BytesBefore:  before,
+		BytesAfter:   after,
+		DurationSecs: elapsed.Seconds(),
+		KeysRetained: h.db.Stats().Keys,
+	})
+}
+
+func (h *Handler) handleStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.db.Stats())
+}
+
+// verifyResult is the body of a POST /admin/verify response.
+type verifyResult struct {
+	OK          bool     `json:"ok"`
+	KeysChecked int      `json:"keys_checked"`
+	Mismatched  []string `json:"mismatched,omitempty"`
+	Missing     []string `json:"missing,omitempty"`
+	Extra       []string `json:"extra,omitempty"`
+	Corruption  string   `json:"corruption,omitempty"`
+}
+
+// handleVerify runs VerifyOnline. Problems it finds are reported in the
+// body with 200; only failing to run the check is an error status.
+func (h *Handler) handleVerify(w http.ResponseWriter, r *http.Request) {
+	rep, err := h.db.VerifyOnline()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	res := verifyResult{
+		OK:          rep.OK(),
+		KeysChecked: rep.KeysChecked,
+		Mismatched:  rep.Mismatched,
+		Missing:     rep.Missing,
+		Extra:       rep.Extra,
+	}
+	if rep.Corruption != nil {
+		res.Corruption = rep.Corruption.Error()
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
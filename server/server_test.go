@@ -0,0 +1,174 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"godb/kv"
+)
+
+func newTestDB(t *testing.T) *kv.KV {
+	t.Helper()
+	db, err := kv.Create(filepath.Join(t.TempDir(), "db.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func do(t *testing.T, h http.Handler, method, path, body string, header ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestBatchMixed(t *testing.T) {
+	db := newTestDB(t)
+	db.Set("old", []byte("x"))
+	h := NewHandler(db)
+
+	// "MQ==" and "Mg==" are base64 for "1" and "2"
+	body := `[{"op":"set","key":"a","value":"MQ=="},{"op":"del","key":"old"},{"op":"set","key":"b","value":"Mg=="}]`
+	rec := do(t, h, "POST", "/batch", body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var statuses []opStatus
+	if err := json.NewDecoder(rec.Body).Decode(&statuses); err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 3 {
+		t.Fatalf("statuses = %+v", statuses)
+	}
+	for i, s := range statuses {
+		if s.Status != "ok" {
+			t.Errorf("op %d: %+v", i, s)
+		}
+	}
+	if v, _ := db.Get("a"); string(v) != "1" {
+		t.Errorf("Get(a) = %q", v)
+	}
+	if v, _ := db.Get("b"); string(v) != "2" {
+		t.Errorf("Get(b) = %q", v)
+	}
+	if _, ok := db.Get("old"); ok {
+		t.Error("old was not deleted")
+	}
+}
+
+func TestBatchRejectsBadBodies(t *testing.T) {
+	db := newTestDB(t)
+	h := NewHandler(db, WithMaxBodyBytes(128))
+	for name, tc := range map[string]struct {
+		body string
+		code int
+	}{
+		"malformed":     {`[{"op":"set",`, http.StatusBadRequest},
+		"not an array":  {`{"op":"set","key":"a"}`, http.StatusBadRequest},
+		"unknown field": {`[{"op":"set","key":"a","extra":1}]`, http.StatusBadRequest},
+		"trailing data": {`[] []`, http.StatusBadRequest},
+		"bad base64":    {`[{"op":"set","key":"a","value":"!!"}]`, http.StatusBadRequest},
+		"too large":     {`[{"op":"set","key":"a","value":"` + strings.Repeat("QUFB", 64) + `"}]`, http.StatusRequestEntityTooLarge},
+	} {
+		if rec := do(t, h, "POST", "/batch", tc.body); rec.Code != tc.code {
+			t.Errorf("%s: status %d, want %d: %s", name, rec.Code, tc.code, rec.Body)
+		}
+	}
+
+	// one invalid operation fails the whole batch
+	rec := do(t, h, "POST", "/batch", `[{"op":"set","key":"a","value":"MQ=="},{"op":"put","key":"b"}]`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var statuses []opStatus
+	if err := json.NewDecoder(rec.Body).Decode(&statuses); err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 2 || statuses[0].Status != "skipped" || statuses[1].Status != "invalid" {
+		t.Errorf("statuses = %+v", statuses)
+	}
+	if keys := db.Keys(); len(keys) != 0 {
+		t.Errorf("a rejected batch wrote %q", keys)
+	}
+}
+
+func TestAdminCompact(t *testing.T) {
+	db := newTestDB(t)
+	for i := 0; i < 20; i++ {
+		db.Set("a", []byte("value"))
+	}
+	db.Set("b", []byte("value"))
+	h := NewHandler(db)
+
+	rec := do(t, h, "POST", "/admin/compact", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var res compactResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.BytesAfter >= res.BytesBefore {
+		t.Errorf("compaction went from %d to %d bytes", res.BytesBefore, res.BytesAfter)
+	}
+	if res.KeysRetained != 2 {
+		t.Errorf("keys_retained = %d, want 2", res.KeysRetained)
+	}
+	if _, size, _ := db.CompactEstimate(); size != res.BytesAfter {
+		t.Errorf("bytes_after = %d, log is %d", res.BytesAfter, size)
+	}
+}
+
+func TestAdminStats(t *testing.T) {
+	db := newTestDB(t)
+	db.Set("a", []byte("1"))
+	db.Set("b", []byte("2"))
+	db.Del("b")
+	rec := do(t, NewHandler(db), "GET", "/admin/stats", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var stats kv.Stats
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats != db.Stats() {
+		t.Errorf("stats = %+v, want %+v", stats, db.Stats())
+	}
+}
+
+func TestAdminToken(t *testing.T) {
+	db := newTestDB(t)
+	h := NewHandler(db, WithAdminToken("s3cret"))
+	for _, auth := range []string{"", "Bearer wrong", "s3cret", "Basic s3cret"} {
+		for _, ep := range [][2]string{{"POST", "/admin/compact"}, {"GET", "/admin/stats"}} {
+			rec := do(t, h, ep[0], ep[1], "", "Authorization", auth)
+			if rec.Code != http.StatusUnauthorized {
+				t.Errorf("%s %s with %q: status %d, want 401", ep[0], ep[1], auth, rec.Code)
+			}
+			if rec.Header().Get("WWW-Authenticate") != "Bearer" {
+				t.Errorf("%s %s: no WWW-Authenticate challenge", ep[0], ep[1])
+			}
+		}
+	}
+	if rec := do(t, h, "GET", "/admin/stats", "", "Authorization", "Bearer s3cret"); rec.Code != http.StatusOK {
+		t.Errorf("authorized stats: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := do(t, h, "POST", "/admin/compact", "", "Authorization", "Bearer s3cret"); rec.Code != http.StatusOK {
+		t.Errorf("authorized compact: status %d: %s", rec.Code, rec.Body)
+	}
+	// the token only guards /admin
+	if rec := do(t, h, "POST", "/batch", `[{"op":"set","key":"a","value":"MQ=="}]`); rec.Code != http.StatusOK {
+		t.Errorf("batch without a token: status %d: %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,68 @@
+// Package shard routes keys to godb instances with consistent hashing, so
+// clients running several instances agree on which one owns a key and
+// adding or removing an instance moves only a small share of keys.
+package shard
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+// DefaultReplicas is the number of points per node used when NewRing is
+// given replicas <= 0.
+const DefaultReplicas = 100
+
+// Ring maps keys to nodes. It is safe for concurrent use once built.
+type Ring struct {
+	points []uint32 // sorted hashes of every node replica
+	owners map[uint32]string
+}
+
+// NewRing places each node on the ring at replicas points. More replicas
+// spread keys more evenly at the cost of memory.
+func NewRing(nodes []string, replicas int) *Ring {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+	r := &Ring{owners: make(map[uint32]string, len(nodes)*replicas)}
+	for _, node := range nodes {
+		for i := 0; i < replicas; i++ {
+			h := hash(node + "#" + strconv.Itoa(i))
+			if _, taken := r.owners[h]; taken {
+				// keep the first owner of a colliding point so the result
+				// does not depend on the order nodes were given in
+				if node > r.owners[h] {
+					continue
+				}
+			} else {
+				r.points = append(r.points, h)
+			}
+			r.owners[h] = node
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// Node returns the node owning key: the first point clockwise from the
+// key's hash. It returns "" for an empty ring.
+func (r *Ring) Node(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := hash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
+
+// hash uses MD5, as ketama does, for its even spread; it is not used for
+// security.
+func hash(s string) uint32 {
+	sum := md5.Sum([]byte(s))
+	return binary.BigEndian.Uint32(sum[:4])
+}
@@ -0,0 +1,60 @@
+package shard
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRingDistribution(t *testing.T) {
+	nodes := []string{"db1:7000", "db2:7000", "db3:7000", "db4:7000"}
+	r := NewRing(nodes, 0)
+	const keys = 100000
+	counts := make(map[string]int)
+	for i := 0; i < keys; i++ {
+		counts[r.Node(fmt.Sprintf("user:%d", i))]++
+	}
+	if len(counts) != len(nodes) {
+		t.Fatalf("keys landed on %d nodes, want %d: %v", len(counts), len(nodes), counts)
+	}
+	fair := keys / len(nodes)
+	for node, n := range counts {
+		if n < fair*3/4 || n > fair*5/4 {
+			t.Errorf("%s owns %d keys, want within 25%% of %d", node, n, fair)
+		}
+	}
+}
+
+func TestRingDeterministic(t *testing.T) {
+	a := NewRing([]string{"a", "b", "c"}, 50)
+	b := NewRing([]string{"c", "a", "b"}, 50)
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprint(i)
+		if a.Node(key) != b.Node(key) {
+			t.Fatalf("node order changed the owner of %q", key)
+		}
+	}
+	if n := NewRing(nil, 10).Node("x"); n != "" {
+		t.Errorf("empty ring returned %q", n)
+	}
+}
+
+func TestRingMinimalRemapping(t *testing.T) {
+	before := NewRing([]string{"a", "b", "c", "d"}, 0)
+	after := NewRing([]string{"a", "b", "c", "d", "e"}, 0)
+	const keys = 50000
+	moved := 0
+	for i := 0; i < keys; i++ {
+		key := fmt.Sprintf("key%d", i)
+		from, to := before.Node(key), after.Node(key)
+		if from != to {
+			moved++
+			if to != "e" {
+				t.Fatalf("%s moved from %s to %s, not to the new node", key, from, to)
+			}
+		}
+	}
+	// ideally a fifth of the keys move to the new node
+	if moved < keys/10 || moved > keys*3/10 {
+		t.Errorf("%d of %d keys moved, want about %d", moved, keys, keys/5)
+	}
+}